@@ -0,0 +1,170 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fatih/color"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+变化告警
+1、monitor每次扫描与上一次对比，新开放端口与服务变化产生告警，指定-alert-closed时已关闭端口与消失主机同样告警
+2、-alert 指定告警输出，多个以逗号分割：
+   stdout                  在终端输出
+   file:<路径>             以json行追加写入文件
+   webhook:<url>           与-notify的通用webhook相同，以json格式POST到指定地址（alerts字段为告警列表），失败时重试；
+                           也可直接写http://或https://开头的地址
+   syslog                  写入本机syslog，syslog:udp://host:514 或 syslog:tcp://host:514 发送到远程syslog（windows不支持）
+   slack:<url>等           机器人通知，与-notify的格式相同，消息内容使用alert模板
+3、某个告警输出失败时记录错误，不影响其他输出与后续扫描
+*/
+
+// 告警类型
+const (
+	alertOpened   = "opened"
+	alertClosed   = "closed"
+	alertChanged  = "changed"
+	alertNewHost  = "new_host"
+	alertGoneHost = "gone_host"
+)
+
+// monitorAlert 一条变化告警
+type monitorAlert struct {
+	Time    time.Time   `json:"time"`
+	Run     int         `json:"run"` // 第几次监控扫描
+	Type    string      `json:"type"`
+	Host    string      `json:"host"`
+	Message string      `json:"message"`
+	Result  *ScanResult `json:"result,omitempty"` // 当前结果，已关闭端口为关闭前的结果
+	Old     *ScanResult `json:"old,omitempty"`    // 服务变化前的结果
+}
+
+// 根据两次结果的差异生成告警，closed为true时包含已关闭端口与消失主机
+func diffAlerts(diff *ResultDiff, run int, closed bool) []monitorAlert {
+	now := time.Now()
+	var alerts []monitorAlert
+	add := func(alertType string, host string, message string, result *ScanResult, old *ScanResult) {
+		alerts = append(alerts, monitorAlert{Time: now, Run: run, Type: alertType, Host: host, Message: message, Result: result, Old: old})
+	}
+
+	for _, host := range diff.NewHosts {
+		add(alertNewHost, host, changeNewHost+" "+host, nil, nil)
+	}
+	for i := range diff.Opened {
+		result := &diff.Opened[i]
+		add(alertOpened, result.IP, fmt.Sprintf("%s %s %s", changeOpened, resultKey(*result), serviceText(*result)), result, nil)
+	}
+	for i := range diff.Changed {
+		change := &diff.Changed[i]
+		add(alertChanged, change.New.IP, fmt.Sprintf("%s %s %s -> %s", changeService, resultKey(change.New),
+			serviceText(change.Old), serviceText(change.New)), &change.New, &change.Old)
+	}
+	if !closed {
+		return alerts
+	}
+	for _, host := range diff.GoneHosts {
+		add(alertGoneHost, host, changeGoneHost+" "+host, nil, nil)
+	}
+	for i := range diff.Closed {
+		result := &diff.Closed[i]
+		add(alertClosed, result.IP, fmt.Sprintf("%s %s %s", changeClosed, resultKey(*result), serviceText(*result)), result, nil)
+	}
+	return alerts
+}
+
+// alertSink 告警输出
+type alertSink interface {
+	name() string
+	send(alerts []monitorAlert) error
+}
+
+// 解析-alert参数
+func parseAlertSinks(spec string) ([]alertSink, error) {
+	var sinks []alertSink
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		kind, value, _ := strings.Cut(item, ":")
+		switch {
+		case item == "":
+			continue
+		case item == "stdout":
+			sinks = append(sinks, stdoutSink{})
+		case kind == "file" && value != "":
+			sinks = append(sinks, &fileSink{path: value})
+		case kind == "syslog":
+			sink, err := newSyslogSink(value)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink)
+		case notifierKinds[kind], kind == "http", kind == "https":
+			// 直接写http://或https://开头的地址时为通用webhook
+			if !notifierKinds[kind] {
+				item = "webhook:" + item
+			}
+			n, err := parseNotifier(item)
+			if err != nil {
+				return nil, wrapError(ErrInvalidArgument, err)
+			}
+			sinks = append(sinks, notifierSink{notifier: n})
+		default:
+			return nil, fmt.Errorf("%w: 不支持的告警输出: %s，支持stdout、file:<路径>、webhook:<url>、syslog以及slack、dingtalk、wecom、feishu机器人", ErrInvalidArgument, item)
+		}
+	}
+	return sinks, nil
+}
+
+// 终端输出
+type stdoutSink struct{}
+
+func (stdoutSink) name() string {
+	return "stdout"
+}
+
+func (stdoutSink) send(alerts []monitorAlert) error {
+	for _, alert := range alerts {
+		line := fmt.Sprintf("[告警] %s %s", alert.Time.Format("2006-01-02 15:04:05"), alert.Message)
+		if alert.Type == alertClosed || alert.Type == alertGoneHost {
+			color.Yellow(line)
+		} else {
+			color.Red(line)
+		}
+	}
+	return nil
+}
+
+// 以json行追加写入文件
+type fileSink struct {
+	path string
+	mu   sync.Mutex
+}
+
+func (s *fileSink) name() string {
+	return "file:" + s.path
+}
+
+func (s *fileSink) send(alerts []monitorAlert) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := ensureDir(s.path); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	for _, alert := range alerts {
+		if err := encoder.Encode(alert); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package tools
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// webhook告警与-notify的通用webhook相同，失败时重试
+func TestWebhookAlertSink(t *testing.T) {
+	stub := newNotifyStub(t, stubResponse{http.StatusServiceUnavailable, "busy"}, stubResponse{http.StatusOK, "ok"})
+	oldRetries := notifyRetries
+	notifyRetries = 2
+	t.Cleanup(func() { notifyRetries = oldRetries })
+
+	for _, spec := range []string{"webhook:" + stub.server.URL + "/alert", stub.server.URL + "/alert"} {
+		sinks, err := parseAlertSinks(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sinks) != 1 || sinks[0].name() != "webhook" {
+			t.Fatalf("%s 解析为 %v，期望webhook", spec, sinks)
+		}
+	}
+
+	sinks, _ := parseAlertSinks("webhook:" + stub.server.URL + "/alert")
+	result := ScanResult{IP: "10.1.1.2", Port: 6379, Protocol: "tcp", Service: "redis", Status: "open"}
+	alerts := []monitorAlert{{Time: time.Now(), Run: 2, Type: alertOpened, Host: result.IP, Message: "新开放端口 10.1.1.2:6379/tcp redis", Result: &result}}
+	if err := sinks[0].send(alerts); err != nil {
+		t.Fatalf("告警发送失败: %v", err)
+	}
+
+	requests := stub.received()
+	if len(requests) != 2 {
+		t.Fatalf("发送了%d次，期望失败后重试1次", len(requests))
+	}
+	body := requests[1].body
+	sent, _ := body["alerts"].([]any)
+	if body["event"] != "alert" || len(sent) != 1 || field(sent[0].(map[string]any), "result", "port") != float64(6379) {
+		t.Fatalf("告警请求体为 %v", body)
+	}
+}
+
+func TestParseAlertSinks(t *testing.T) {
+	sinks, err := parseAlertSinks("stdout, file:alerts.jsonl, slack:https://hooks.slack.com/services/T000/B000/XXXX")
+	if err != nil || len(sinks) != 3 {
+		t.Fatalf("解析结果为 %v %v", sinks, err)
+	}
+	for _, spec := range []string{"file:", "webhook:", "webhook:ftp://example.com", "mail:ops@example.com"} {
+		if _, err := parseAlertSinks(spec); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("%q 的错误为 %v，期望ErrInvalidArgument", spec, err)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+//go:build !windows
+
+package tools
+
+import (
+	"fmt"
+	"log/syslog"
+	"net/url"
+)
+
+// 写入syslog，每条告警一行
+type syslogSink struct {
+	writer *syslog.Writer
+}
+
+// addr为空时写入本机syslog，否则为 udp://host:port 或 tcp://host:port
+func newSyslogSink(addr string) (alertSink, error) {
+	network, raddr := "", ""
+	if addr != "" {
+		u, err := url.Parse(addr)
+		if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Host == "" {
+			return nil, fmt.Errorf("%w: syslog地址不合法: %s，例如 udp://10.1.1.1:514", ErrInvalidArgument, addr)
+		}
+		network, raddr = u.Scheme, u.Host
+	}
+	writer, err := syslog.Dial(network, raddr, syslog.LOG_WARNING|syslog.LOG_DAEMON, "miao")
+	if err != nil {
+		return nil, fmt.Errorf("连接syslog失败: %w", err)
+	}
+	return &syslogSink{writer: writer}, nil
+}
+
+func (s *syslogSink) name() string {
+	return "syslog"
+}
+
+func (s *syslogSink) send(alerts []monitorAlert) error {
+	for _, alert := range alerts {
+		if err := s.writer.Warning(alert.Message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
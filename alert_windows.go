@@ -0,0 +1,8 @@
+package tools
+
+import "fmt"
+
+// windows没有log/syslog，不支持syslog告警输出
+func newSyslogSink(addr string) (alertSink, error) {
+	return nil, fmt.Errorf("%w: windows不支持syslog告警输出", ErrInvalidArgument)
+}
@@ -0,0 +1,195 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/fatih/color"
+	"log/slog"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+banner识别
+1、连接开放端口并读取服务主动发送的banner，未收到数据时发送HTTP请求再读取
+2、根据banner识别SSH、FTP、SMTP、POP3、IMAP、HTTP、MySQL、Redis等常见服务的名称、产品与版本
+3、无法识别时按端口库推测服务名
+4、只使用tcp连接，可经由代理进行，用于未安装nmap或使用代理的场景
+*/
+
+// 单次读取banner的最大长度
+const maxBannerSize = 4096
+
+// banner识别规则，按顺序匹配，第一个分组为产品版本信息
+var bannerRules = []struct {
+	service string
+	pattern *regexp.Regexp
+}{
+	{"ssh", regexp.MustCompile(`^SSH-[\d.]+-(\S+)`)},
+	{"ftp", regexp.MustCompile(`(?i)^220[ -].*\bftp\b(.*)`)},
+	{"smtp", regexp.MustCompile(`(?i)^220[ -].*\b(?:smtp|esmtp|mail)\b(.*)`)},
+	{"ftp", regexp.MustCompile(`^220[ -](.*)`)},
+	{"pop3", regexp.MustCompile(`^\+OK(.*)`)},
+	{"imap", regexp.MustCompile(`^\* OK(.*)`)},
+	{"redis", regexp.MustCompile(`^-(?:ERR|NOAUTH|DENIED)(.*)`)},
+	{"http", regexp.MustCompile(`^HTTP/[\d.]+ \d{3}`)},
+}
+
+// http响应头中的Server，例如 nginx/1.24.0、Apache/2.4.57 (Debian)
+var serverHeader = regexp.MustCompile(`(?im)^Server:\s*([^\r\n]+)`)
+
+// 常见产品名称，与nmap的命名保持一致，便于漏洞关联
+var bannerProducts = map[string]string{
+	"apache":  "Apache httpd",
+	"nginx":   "nginx",
+	"openssh": "OpenSSH",
+	"iis":     "Microsoft IIS httpd",
+}
+
+// 对开放端口进行banner识别，UDP端口无法通过连接识别，不计入结果
+func bannerDetect(ctx context.Context, portMap map[string][]string, cfg *Config) []ScanResult {
+
+	logSection("端口服务探测（banner识别）")
+
+	var results []ScanResult
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, cfg.Threads)
+
+	db, _ := defaultPortDB()
+	for ip, portSlice := range portMap {
+		for _, port := range portSlice {
+			if strings.HasPrefix(port, "U:") || ctx.Err() != nil {
+				continue
+			}
+			intPort, _ := strconv.Atoi(port)
+
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(ip string, port int) {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				result := ScanResult{IP: ip, Port: port, Protocol: "tcp", Status: "open"}
+				banner, err := grabBanner(ip, port, cfg)
+				if err != nil {
+					slog.Debug("banner读取失败", "addr", net.JoinHostPort(ip, strconv.Itoa(port)), "error", err)
+				}
+				result.Service, result.Product, result.Version = parseBanner(banner)
+				if result.Service == "" && db != nil {
+					result.Service = db.Service(port, "tcp")
+				}
+
+				mu.Lock()
+				results = append(results, result)
+				mu.Unlock()
+			}(ip, intPort)
+		}
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].IP != results[j].IP {
+			return results[i].IP < results[j].IP
+		}
+		return results[i].Port < results[j].Port
+	})
+
+	lastIP := ""
+	for _, r := range results {
+		if r.IP != lastIP {
+			color.Yellow("[ip] %s\n", r.IP)
+			lastIP = r.IP
+		}
+		line := fmt.Sprintf("%d/%s: %s %s %s %s", r.Port, r.Protocol, r.Status, r.Service, r.Product, r.Version)
+		fmt.Println(line)
+		fileWrite(line)
+	}
+	return results
+}
+
+// 读取服务主动发送的banner，未收到数据时发送HTTP请求
+func grabBanner(ip string, port int, cfg *Config) ([]byte, error) {
+	conn, err := dialTCP(net.JoinHostPort(ip, strconv.Itoa(port)), cfg.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	buf := make([]byte, maxBannerSize)
+	conn.SetReadDeadline(time.Now().Add(cfg.Timeout))
+	if n, _ := conn.Read(buf); n > 0 {
+		return buf[:n], nil
+	}
+
+	conn.SetDeadline(time.Now().Add(cfg.Timeout))
+	request := fmt.Sprintf("HEAD / HTTP/1.0\r\nHost: %s\r\nUser-Agent: %s\r\n\r\n", ip, userAgent)
+	if _, err := conn.Write([]byte(request)); err != nil {
+		return nil, err
+	}
+	n, err := conn.Read(buf)
+	if n > 0 {
+		return buf[:n], nil
+	}
+	return nil, err
+}
+
+// 根据banner识别服务名、产品与版本
+func parseBanner(banner []byte) (service string, product string, version string) {
+	if len(banner) == 0 {
+		return "", "", ""
+	}
+
+	// MySQL握手包：3字节长度、1字节序号、协议版本10、以0结尾的版本号
+	if len(banner) > 5 && banner[4] == 0x0a {
+		if end := bytes.IndexByte(banner[5:], 0); end > 0 {
+			version = string(banner[5 : 5+end])
+			product = "MySQL"
+			if strings.Contains(strings.ToLower(version), "mariadb") {
+				product = "MariaDB"
+			}
+			return "mysql", product, version
+		}
+	}
+
+	text := string(banner)
+	firstLine, _, _ := strings.Cut(text, "\n")
+	firstLine = strings.TrimSpace(firstLine)
+
+	for _, rule := range bannerRules {
+		match := rule.pattern.FindStringSubmatch(firstLine)
+		if match == nil {
+			continue
+		}
+		service = rule.service
+		switch service {
+		case "http":
+			if header := serverHeader.FindStringSubmatch(text); header != nil {
+				product, version = splitProduct(strings.TrimSpace(header[1]))
+			}
+		case "ssh":
+			// OpenSSH_9.6p1 Ubuntu-3ubuntu13
+			product, version = splitProduct(strings.Replace(match[1], "_", "/", 1))
+		}
+		return service, product, version
+	}
+	return "", "", ""
+}
+
+// 拆分 产品/版本 形式的字符串，例如 nginx/1.24.0、Apache/2.4.57 (Debian)
+func splitProduct(value string) (string, string) {
+	name, version, _ := strings.Cut(value, "/")
+	if fields := strings.Fields(version); len(fields) > 0 {
+		version = fields[0]
+	}
+	if product, ok := bannerProducts[strings.ToLower(name)]; ok {
+		name = product
+	}
+	return name, version
+}
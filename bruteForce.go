@@ -0,0 +1,634 @@
+package tools
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"database/sql/driver"
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/go-sql-driver/mysql"
+	"github.com/hirochachacha/go-smb2"
+	"github.com/lib/pq"
+	mssql "github.com/microsoft/go-mssqldb"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/yaml.v3"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 内置的弱口令字典
+//
+//go:embed data/brute.yaml
+var embeddedBruteDict []byte
+
+// 弱口令检测的服务，端口或nmap识别的服务名匹配即进行检测
+type bruteService struct {
+	name     string // 服务名称，用于-brute-services与字典
+	display  string // 输出中使用的名称
+	ports    []int
+	services []string
+	lockout  bool // 账号存在锁定策略，按-brute-lockout-attempts控制每个账号的尝试频率
+	login    func(t bruteTarget, user string, pass string) (bool, error)
+}
+
+var bruteServices = []bruteService{
+	{"ssh", "SSH", []int{22}, []string{"ssh"}, false, sshLogin},
+	{"ftp", "FTP", []int{21}, []string{"ftp"}, false, ftpLogin},
+	{"mysql", "MySQL", []int{3306}, []string{"mysql"}, false, mysqlLogin},
+	{"mssql", "MSSQL", []int{1433}, []string{"ms-sql-s"}, true, mssqlLogin},
+	{"postgresql", "PostgreSQL", []int{5432}, []string{"postgresql"}, false, postgresLogin},
+	{"redis", "Redis", []int{6379}, []string{"redis"}, false, redisLogin},
+	{"smb", "SMB", []int{445}, []string{"microsoft-ds", "smb"}, true, smbLogin},
+	{"rdp", "RDP", []int{3389}, []string{"ms-wbt-server", "rdp"}, true, rdpLogin},
+}
+
+// bruteStopError 无需继续检测该目标的原因，例如未设置密码、任意账号均可登录
+type bruteStopError struct {
+	reason string
+}
+
+func (e *bruteStopError) Error() string {
+	return e.reason
+}
+
+// 账号已被锁定，跳过该账号
+var errAccountLocked = errors.New("账号已被锁定")
+
+// 目标连续出现连接错误的次数上限，超过后停止检测该目标
+const maxBruteErrors = 3
+
+// 登录结果使用的NTSTATUS，SMB与RDP共用
+const (
+	ntStatusLogonFailure       = 0xC000006D
+	ntStatusAccountRestriction = 0xC000006E
+	ntStatusPasswordExpired    = 0xC0000071
+	ntStatusAccountDisabled    = 0xC0000072
+	ntStatusAccountExpired     = 0xC0000193
+	ntStatusPasswordMustChange = 0xC0000224
+	ntStatusAccountLockedOut   = 0xC0000234
+)
+
+// 字典文件结构
+type bruteDict struct {
+	Users     map[string][]string `yaml:"users"`
+	Passwords []string            `yaml:"passwords"`
+}
+
+var (
+	bruteUsersPath     string
+	brutePasswordsPath string
+	bruteDictOnce      sync.Once
+	bruteDictCache     *bruteDict
+	bruteDictErr       error
+)
+
+// 设置用户名与密码字典文件，需要在首次使用字典之前调用
+func setBruteWordlists(users string, passwords string) {
+	bruteUsersPath = users
+	brutePasswordsPath = passwords
+}
+
+// 获取弱口令字典，指定的字典文件覆盖内置字典
+func loadBruteDict() (*bruteDict, error) {
+	bruteDictOnce.Do(func() {
+		var dict bruteDict
+		if err := yaml.Unmarshal(embeddedBruteDict, &dict); err != nil {
+			bruteDictErr = fmt.Errorf("内置弱口令字典解析失败: %v", err)
+			return
+		}
+
+		if bruteUsersPath != "" {
+			users, err := readWordlist(bruteUsersPath)
+			if err != nil {
+				bruteDictErr = err
+				return
+			}
+			for name := range dict.Users {
+				// redis只进行密码认证
+				if name != "redis" {
+					dict.Users[name] = users
+				}
+			}
+		}
+		if brutePasswordsPath != "" {
+			passwords, err := readWordlist(brutePasswordsPath)
+			if err != nil {
+				bruteDictErr = err
+				return
+			}
+			dict.Passwords = passwords
+		}
+		bruteDictCache = &dict
+	})
+	return bruteDictCache, bruteDictErr
+}
+
+// 读取字典文件，每行一个，忽略空行
+func readWordlist(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("字典文件读取失败: %v", err)
+	}
+	defer file.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if word := strings.TrimRight(scanner.Text(), "\r"); word != "" {
+			words = append(words, word)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("字典文件读取失败: %v", err)
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("字典文件为空: %s", path)
+	}
+	return words, nil
+}
+
+// 判断服务名是否支持弱口令检测
+func isBruteService(name string) bool {
+	for _, service := range bruteServices {
+		if service.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// 对开放端口进行弱口令检测，成功登录的账号作为安全问题写入结果
+func bruteResults(results []ScanResult, cfg *Config) {
+	dict, err := loadBruteDict()
+	if err != nil {
+		slog.Error(err.Error())
+		return
+	}
+
+	logSection("弱口令检测")
+	slog.Warn("弱口令检测会产生大量登录失败记录，请确认已获得目标授权")
+
+	// go-sql-driver默认将连接错误输出到标准错误
+	mysql.SetLogger(log.New(io.Discard, "", 0))
+
+	enabled := make(map[string]bool)
+	for _, name := range strings.Split(cfg.BruteServices, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			enabled[name] = true
+		}
+	}
+
+	// 每种服务单独限制同时检测的目标数
+	sems := make(map[string]chan struct{})
+	for _, service := range bruteServices {
+		sems[service.name] = make(chan struct{}, cfg.BruteThreads)
+	}
+
+	var wg sync.WaitGroup
+	for i := range results {
+		if results[i].Status != "open" || results[i].Protocol == "udp" {
+			continue
+		}
+		service := matchBruteService(&results[i])
+		if service == nil || (len(enabled) > 0 && !enabled[service.name]) {
+			continue
+		}
+		// 已确认未授权访问的服务无需再检测口令
+		if hasFinding(results[i], service.name) {
+			continue
+		}
+
+		wg.Add(1)
+		go func(result *ScanResult, service *bruteService) {
+			defer wg.Done()
+			sem := sems[service.name]
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			target := bruteTarget{ip: result.IP, port: result.Port, cfg: cfg}
+			result.Findings = append(result.Findings, target.run(service, dict.Users[service.name], dict.Passwords)...)
+		}(&results[i], service)
+	}
+	wg.Wait()
+}
+
+// 根据端口或服务名查找对应的弱口令检测服务
+func matchBruteService(result *ScanResult) *bruteService {
+	service := strings.ToLower(result.Service)
+	for i := range bruteServices {
+		for _, name := range bruteServices[i].services {
+			if service == name {
+				return &bruteServices[i]
+			}
+		}
+	}
+	// nmap已识别为其他服务时不再按端口匹配
+	if service != "" && service != "unknown" {
+		return nil
+	}
+	for i := range bruteServices {
+		for _, port := range bruteServices[i].ports {
+			if result.Port == port {
+				return &bruteServices[i]
+			}
+		}
+	}
+	return nil
+}
+
+// 结果中是否已存在指定检查项的问题
+func hasFinding(result ScanResult, check string) bool {
+	for _, finding := range result.Findings {
+		if finding.Check == check {
+			return true
+		}
+	}
+	return false
+}
+
+// 弱口令检测的目标
+type bruteTarget struct {
+	ip   string
+	port int
+	cfg  *Config
+}
+
+func (t bruteTarget) addr() string {
+	return net.JoinHostPort(t.ip, strconv.Itoa(t.port))
+}
+
+// 建立tcp连接并设置单次登录的超时时间
+func (t bruteTarget) dial() (net.Conn, error) {
+	conn, err := dialTCP(t.addr(), t.cfg.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(t.cfg.BruteTimeout))
+	return conn, nil
+}
+
+// 对单个目标进行检测，按密码依次尝试全部用户名，便于控制每个账号的尝试频率
+func (t bruteTarget) run(service *bruteService, users []string, passwords []string) []Finding {
+	var findings []Finding
+	done := make(map[string]bool) // 已找到密码或已被锁定的账号
+	tried := make(map[string]bool)
+	errCount := 0
+	attempts := 0
+
+	for round, pattern := range passwords {
+		if len(done) == len(users) {
+			break
+		}
+		// 存在账号锁定策略的服务，每个账号尝试指定次数后等待锁定计数重置
+		if service.lockout && t.cfg.BruteLockoutAttempts > 0 && t.cfg.BruteLockoutWait > 0 &&
+			round > 0 && round%t.cfg.BruteLockoutAttempts == 0 {
+			slog.Info(fmt.Sprintf("%s 已对每个账号尝试%d次，等待%s以避免账号锁定", t.addr(), t.cfg.BruteLockoutAttempts, t.cfg.BruteLockoutWait))
+			time.Sleep(t.cfg.BruteLockoutWait)
+		}
+
+		for _, user := range users {
+			pass := strings.ReplaceAll(pattern, "{user}", user)
+			if done[user] || tried[user+"\x00"+pass] {
+				continue
+			}
+			tried[user+"\x00"+pass] = true
+
+			if attempts > 0 && t.cfg.BruteDelay > 0 {
+				time.Sleep(t.cfg.BruteDelay)
+			}
+			attempts++
+
+			ok, err := service.login(t, user, pass)
+			slog.Log(context.Background(), levelTrace, "登录尝试", "addr", t.addr(), "service", service.name, "user", user, "success", ok, "error", err)
+			var stop *bruteStopError
+			switch {
+			case errors.As(err, &stop):
+				slog.Info(fmt.Sprintf("%s %s %s", t.addr(), service.display, stop.reason))
+				return findings
+			case errors.Is(err, errAccountLocked):
+				slog.Warn(fmt.Sprintf("%s %s 账号 %s 已被锁定，跳过", t.addr(), service.display, user))
+				done[user] = true
+			case err != nil:
+				errCount++
+				slog.Debug("登录出错", "addr", t.addr(), "service", service.name, "user", user, "error", err)
+				if errCount >= maxBruteErrors {
+					slog.Warn(fmt.Sprintf("%s %s 连续%d次连接失败，停止检测: %v", t.addr(), service.display, errCount, err))
+					return findings
+				}
+			case ok:
+				errCount = 0
+				done[user] = true
+
+				finding := Finding{
+					Check:    "brute",
+					Severity: severityHigh,
+					Title:    service.display + "弱口令",
+					Detail:   t.credential(user, pass),
+				}
+				line := fmt.Sprintf("%s [%s] %s %s", t.addr(), finding.Severity, finding.Title, finding.Detail)
+				color.Red(line) // 原子性输出日志
+				fileWrite(line)
+				findings = append(findings, finding)
+
+				if t.cfg.BruteStopOnSuccess {
+					return findings
+				}
+			default:
+				errCount = 0
+			}
+		}
+	}
+	return findings
+}
+
+// 账号的文字描述，默认不输出明文密码
+func (t bruteTarget) credential(user string, pass string) string {
+	switch {
+	case pass == "":
+		pass = "<空>"
+	case !t.cfg.BruteShowPassword:
+		pass = "******"
+	}
+	if user == "" {
+		return "密码: " + pass
+	}
+	return fmt.Sprintf("用户名: %s 密码: %s", user, pass)
+}
+
+// 拆分 DOMAIN\user 格式的用户名
+func splitDomainUser(user string) (string, string) {
+	if domain, name, ok := strings.Cut(user, `\`); ok {
+		return domain, name
+	}
+	return "", user
+}
+
+// 随机字符串，用于生成不存在的账号
+func randomString(n int) string {
+	buf := make([]byte, n/2)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func sshLogin(t bruteTarget, user string, pass string) (bool, error) {
+	conn, err := t.dial()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	config := &ssh.ClientConfig{
+		User: user,
+		Auth: []ssh.AuthMethod{
+			ssh.Password(pass),
+			ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+				answers := make([]string, len(questions))
+				for i := range answers {
+					answers[i] = pass
+				}
+				return answers, nil
+			}),
+		},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, t.addr(), config)
+	if err != nil {
+		if strings.Contains(err.Error(), "unable to authenticate") {
+			return false, nil
+		}
+		return false, err
+	}
+	ssh.NewClient(sshConn, chans, reqs).Close()
+	return true, nil
+}
+
+func ftpLogin(t bruteTarget, user string, pass string) (bool, error) {
+	conn, err := t.dial()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	text := textproto.NewConn(conn)
+	if _, _, err := text.ReadResponse(220); err != nil {
+		return false, err
+	}
+
+	if err := text.PrintfLine("USER %s", user); err != nil {
+		return false, err
+	}
+	code, _, err := text.ReadResponse(0)
+	switch {
+	case code == 230:
+		return true, nil
+	case code == 530:
+		return false, nil
+	case code != 331:
+		return false, fmt.Errorf("FTP响应错误: %d %v", code, err)
+	}
+
+	if err := text.PrintfLine("PASS %s", pass); err != nil {
+		return false, err
+	}
+	code, _, err = text.ReadResponse(0)
+	switch {
+	case code == 230 || code == 202:
+		text.PrintfLine("QUIT")
+		return true, nil
+	case code == 530 || code == 532:
+		return false, nil
+	}
+	return false, fmt.Errorf("FTP响应错误: %d %v", code, err)
+}
+
+func mysqlLogin(t bruteTarget, user string, pass string) (bool, error) {
+	config := mysql.NewConfig()
+	config.User = user
+	config.Passwd = pass
+	config.Net = "tcp"
+	config.Addr = t.addr()
+	config.Timeout = t.cfg.Timeout
+	config.ReadTimeout = t.cfg.BruteTimeout
+	config.WriteTimeout = t.cfg.BruteTimeout
+	config.DialFunc = dialContext
+
+	connector, err := mysql.NewConnector(config)
+	if err != nil {
+		return false, err
+	}
+	err = pingDatabase(connector, t.cfg.BruteTimeout)
+
+	// 1045: Access denied
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == 1045 {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func mssqlLogin(t bruteTarget, user string, pass string) (bool, error) {
+	query := url.Values{}
+	query.Set("dial timeout", strconv.Itoa(timeoutSeconds(t.cfg.Timeout)))
+	query.Set("connection timeout", strconv.Itoa(timeoutSeconds(t.cfg.BruteTimeout)))
+	query.Set("TrustServerCertificate", "true")
+	dsn := &url.URL{Scheme: "sqlserver", User: url.UserPassword(user, pass), Host: t.addr(), RawQuery: query.Encode()}
+
+	connector, err := mssql.NewConnector(dsn.String())
+	if err != nil {
+		return false, err
+	}
+	connector.Dialer = contextDialer{}
+	err = pingDatabase(connector, t.cfg.BruteTimeout)
+
+	// 18456: Login failed，18486: 账号被锁定
+	var mssqlErr mssql.Error
+	if errors.As(err, &mssqlErr) {
+		switch mssqlErr.Number {
+		case 18456:
+			return false, nil
+		case 18486:
+			return false, errAccountLocked
+		}
+	}
+	return err == nil, err
+}
+
+func postgresLogin(t bruteTarget, user string, pass string) (bool, error) {
+	quote := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	dsn := fmt.Sprintf("host='%s' port=%d user='%s' password='%s' dbname=postgres sslmode=disable connect_timeout=%d",
+		quote.Replace(t.ip), t.port, quote.Replace(user), quote.Replace(pass), timeoutSeconds(t.cfg.Timeout))
+
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return false, err
+	}
+	connector.Dialer(pqDialer{})
+	err = pingDatabase(connector, t.cfg.BruteTimeout)
+
+	// 28P01: 密码错误，28000: 认证被拒绝，3D000: 数据库不存在（认证已成功）
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "28P01", "28000":
+			return false, nil
+		case "3D000":
+			return true, nil
+		}
+	}
+	return err == nil, err
+}
+
+// 使用数据库驱动建立连接
+func pingDatabase(connector driver.Connector, timeout time.Duration) error {
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return db.PingContext(ctx)
+}
+
+// 转换为整数秒，最少1秒
+func timeoutSeconds(timeout time.Duration) int {
+	if seconds := int(timeout / time.Second); seconds > 0 {
+		return seconds
+	}
+	return 1
+}
+
+func redisLogin(t bruteTarget, user string, pass string) (bool, error) {
+	conn, err := t.dial()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	args := []string{"AUTH", pass}
+	if user != "" {
+		args = []string{"AUTH", user, pass}
+	}
+	var cmd strings.Builder
+	fmt.Fprintf(&cmd, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&cmd, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := conn.Write([]byte(cmd.String())); err != nil {
+		return false, err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case strings.HasPrefix(reply, "+OK"):
+		return true, nil
+	case strings.Contains(reply, "no password is set") || strings.Contains(reply, "without any password configured"):
+		return false, &bruteStopError{"未设置密码，存在未授权访问"}
+	case strings.HasPrefix(reply, "-ERR max number of clients"):
+		return false, errors.New(strings.TrimSpace(reply))
+	case strings.HasPrefix(reply, "-"):
+		return false, nil
+	}
+	return false, fmt.Errorf("无效的Redis响应: %q", reply)
+}
+
+func smbLogin(t bruteTarget, user string, pass string) (bool, error) {
+	ok, err := smbSession(t, user, pass)
+	if !ok || err != nil {
+		return ok, err
+	}
+	// 部分服务器会将不存在的账号映射为访客，使用随机账号验证登录结果是否可信
+	if guest, err := smbSession(t, randomString(12), randomString(12)); err == nil && guest {
+		return false, &bruteStopError{"任意账号均可登录（映射为访客），跳过检测"}
+	}
+	return true, nil
+}
+
+func smbSession(t bruteTarget, user string, pass string) (bool, error) {
+	conn, err := t.dial()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	domain, name := splitDomainUser(user)
+	dialer := &smb2.Dialer{Initiator: &smb2.NTLMInitiator{User: name, Password: pass, Domain: domain}}
+	session, err := dialer.Dial(conn)
+	if err != nil {
+		var respErr *smb2.ResponseError
+		if errors.As(err, &respErr) {
+			return ntStatusResult(respErr.Code)
+		}
+		return false, err
+	}
+	session.Logoff()
+	return true, nil
+}
+
+// 根据NTSTATUS判断登录结果，密码过期、需要修改密码与账号受限均说明密码正确
+func ntStatusResult(status uint32) (bool, error) {
+	switch status {
+	case ntStatusLogonFailure, ntStatusAccountDisabled, ntStatusAccountExpired:
+		return false, nil
+	case ntStatusAccountLockedOut:
+		return false, errAccountLocked
+	case ntStatusPasswordExpired, ntStatusPasswordMustChange, ntStatusAccountRestriction:
+		return true, nil
+	}
+	return false, fmt.Errorf("登录失败，NTSTATUS: 0x%08X", status)
+}
@@ -0,0 +1,676 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 子命令定义
+type command struct {
+	name  string
+	brief string
+	run   func(args []string) error
+}
+
+func commandList() []command {
+	return []command{
+		{"scan", "端口扫描，依次进行端口开放探测、服务识别并导出结果（默认子命令）", runScan},
+		{"discover", "仅进行主机存活探测", runDiscover},
+		{"detect", "对指定的ip:port列表进行服务识别", runDetect},
+		{"report", "将保存的json结果转换为其他格式", runReport},
+		{"diff", "对比两次扫描结果的差异", runDiff},
+		{"coordinator", "分布式扫描的协调端，切分目标与端口分发给worker并汇总结果", runCoordinator},
+		{"worker", "分布式扫描的工作端，从coordinator领取分片进行扫描", runWorker},
+		{"serve", "以REST API的方式接收扫描任务，可查询进度、获取结果与取消任务", runServe},
+		{"monitor", "按计划反复扫描并与上一次结果对比，端口或服务变化时发出告警", runMonitor},
+		{"notify", "向配置的通知地址发送测试通知", runNotify},
+		{"history", "查询-db数据库中的历史扫描记录与各端口的首次、最近发现时间", runHistory},
+	}
+}
+
+// 根据第一个参数分发子命令，第一个参数为选项或为空时按scan处理，兼容旧的用法
+func runCommand(args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		if len(args) > 0 && (args[0] == "-h" || args[0] == "-help" || args[0] == "--help") {
+			printUsage()
+			return nil
+		}
+		return runScan(args)
+	}
+
+	if args[0] == "help" {
+		printUsage()
+		return nil
+	}
+
+	for _, cmd := range commandList() {
+		if cmd.name == args[0] {
+			return cmd.run(args[1:])
+		}
+	}
+	return fmt.Errorf("%w: 未知的子命令: %s，可通过-h查看用法", ErrInvalidArgument, args[0])
+}
+
+func printUsage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintln(out, "用法: miao <子命令> [参数]")
+	fmt.Fprintln(out, "")
+	fmt.Fprintln(out, "子命令:")
+	for _, cmd := range commandList() {
+		fmt.Fprintf(out, "  %-12s %s\n", cmd.name, cmd.brief)
+	}
+	fmt.Fprintln(out, "")
+	fmt.Fprintln(out, "未指定子命令时默认执行scan，可通过 miao <子命令> -h 查看各子命令的参数")
+	fmt.Fprintln(out, "")
+	fmt.Fprintln(out, "退出码:")
+	fmt.Fprintf(out, "  %d 运行成功\n", ExitOK)
+	fmt.Fprintf(out, "  %d 运行失败，未能完成扫描或未能保存结果\n", ExitFailure)
+	fmt.Fprintf(out, "  %d 输入有误，目标、端口、参数或配置不合法\n", ExitUsage)
+	fmt.Fprintf(out, "  %d 部分失败，结果已保存，但部分阶段出错\n", ExitPartial)
+}
+
+// 创建子命令的参数集合
+func newFlagSet(name string, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "用法: miao %s [参数]\n%s\n\n参数:\n", name, usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// 启动图标，日志级别为info及以上时输出到stderr
+const banner = `
+		|￣￣￣￣￣￣￣￣￣￣￣| 	
+		 |                    |	
+		 |  miaomiao~         |	        
+		||＿＿＿＿＿＿＿＿＿＿_|        
+	        ||                             
+	 (\__/) ||          < portScan 端口扫描工具 >                    
+	 (•ㅅ•) ||                           
+	 / 　 づv               
+	 `
+
+// 注册配置相关参数，返回解析命令行后用于合并配置的函数
+func configFlags(fs *flag.FlagSet, cmd string) func() (*Config, error) {
+	cli := defaultConfig()
+	registerConfigFlags(fs, cmd, cli, defaultConfig())
+	configPath := fs.String("config", "", "指定配置文件路径，默认读取"+userConfigPath())
+	verboseInput := fs.Bool("v", false, "输出调试信息，等同于 -log-level debug")
+	traceInput := fs.Bool("vv", false, "输出更详细的调试信息（包括每次连接），等同于 -log-level trace")
+	quietInput := fs.Bool("q", false, "只输出结果以及警告和错误，等同于 -log-level warn")
+
+	return func() (*Config, error) {
+		cfg, err := loadConfig(*configPath, fs, cli)
+		if err != nil {
+			return nil, wrapError(ErrInvalidArgument, err)
+		}
+		switch {
+		case *traceInput:
+			cfg.LogLevel = "trace"
+		case *verboseInput:
+			cfg.LogLevel = "debug"
+		case *quietInput:
+			cfg.LogLevel = "warn"
+		}
+		if err := setupLogging(cfg); err != nil {
+			return nil, err
+		}
+		if consoleEnabled(slog.LevelInfo) {
+			fmt.Fprintln(os.Stderr, banner)
+		}
+		applyConfig(cfg)
+		// 只有提供-metrics参数的子命令启动指标服务
+		if fs.Lookup("metrics") != nil {
+			if err := startMetricsServer(cfg.Metrics); err != nil {
+				return nil, err
+			}
+		}
+		return cfg, nil
+	}
+}
+
+// 将配置中的全局设置应用到各模块
+func applyConfig(cfg *Config) {
+	setPortDBPath(cfg.PortDB)
+	setProfilePath(cfg.ProfileFile)
+	setConfigProfiles(cfg.Profiles)
+	setFingerprintDirs(cfg.FingerprintDirs)
+	setBruteWordlists(cfg.BruteUsers, cfg.BrutePasswords)
+	setVulnDBPaths(cfg.VulnDB)
+	setTagRules(cfg.TagMap, cfg.TagRules)
+	setProxyChain(cfg.Proxy)
+	setSourceAddress(cfg.SourceIP, cfg.Interface, cfg.SourcePort)
+	if proxyEnabled() && cfg.SourcePort != 0 {
+		slog.Warn("经由代理时源端口由代理决定，-g不生效")
+	}
+	setTextOutput(cfg.TextOutput)
+	setResultDBPath(cfg.DB)
+	setNotifiers(cfg.Notify, cfg.NotifyTemplate, cfg.NotifyRetries)
+}
+
+func runScan(args []string) error {
+	fs := newFlagSet("scan", "端口扫描，依次进行端口开放探测、服务识别并导出excel与json结果")
+	loadCfg := configFlags(fs, "scan")
+	dumpConfigInput := fs.Bool("dump-config", false, "输出合并后生效的配置并退出")
+	listProfilesInput := fs.Bool("list-profiles", false, "列出全部可用的端口配置")
+	discoverInput := fs.Bool("discover", false, "扫描端口前先进行主机存活探测，只扫描存活的ip")
+	fs.Parse(args)
+
+	cfg, err := loadCfg()
+	if err != nil {
+		return err
+	}
+
+	if *dumpConfigInput {
+		return dumpConfig(cfg)
+	}
+
+	// 列出端口配置后直接退出
+	if *listProfilesInput {
+		return listProfiles()
+	}
+
+	// 计算花费时间
+	startTime := time.Now()
+
+	portSpec, err := resolvePorts(cfg)
+	if err != nil {
+		return err
+	}
+
+	targets, err := loadTargets(cfg, portSpec)
+	if err != nil {
+		return err
+	}
+
+	if err := prepareProbes(cfg); err != nil {
+		return err
+	}
+
+	// 剔除不存活的ip，获取存活ip
+	if *discoverInput {
+		targets = aliveTargets(targets, ipAliveCheck(targetIPs(targets), cfg))
+		if len(targets) == 0 {
+			slog.Warn("未发现存活的ip")
+			return nil
+		}
+	}
+
+	results, scanErr := scanTargets(context.Background(), targets, cfg)
+	report := &ScanReport{
+		StartTime: startTime,
+		Targets:   targetDescription(cfg),
+		Ports:     cfg.Ports,
+		Results:   results,
+	}
+	report.EndTime = time.Now()
+
+	if err := saveOutputs(report, cfg); err != nil {
+		return err
+	}
+	if err := recordRun("scan", report); err != nil {
+		return err
+	}
+	notifyScan(cfg, "scan", report, scanErr)
+
+	// 花费时间计算
+	slog.Info(fmt.Sprintf("运行完毕，花费时间: %s", time.Since(startTime)))
+	if scanErr != nil {
+		return &PartialError{Err: scanErr}
+	}
+	return nil
+}
+
+// 对目标进行端口扫描与服务识别，服务识别失败时仍返回开放端口以及失败原因
+// ctx取消后不再发起新的探测，返回已完成部分的结果与ctx的错误
+func scanTargets(ctx context.Context, targets []Target, cfg *Config) ([]ScanResult, error) {
+	groups := portGroups(targets)
+
+	// 扫描开放端口
+	portMap := openPort(ctx, groups, cfg)
+
+	// 识别服务
+	var results []ScanResult
+	var err error
+	// UDP端口无法通过连接探测，直接交由nmap进行识别，不使用nmap时跳过
+	useNmap := cfg.Detector == detectorNmap && !proxyEnabled()
+	for _, group := range groups {
+		if len(group.spec.UDP) == 0 {
+			continue
+		}
+		if !useNmap {
+			slog.Warn("UDP端口只能由nmap直接探测，当前服务识别方式或使用代理时跳过UDP端口")
+			break
+		}
+		for _, udpPort := range group.spec.UDPStrings() {
+			for _, ip := range group.ips {
+				portMap[ip] = append(portMap[ip], "U:"+udpPort)
+			}
+		}
+	}
+	if cfg.Detector == detectorNone {
+		results = portMapResults(portMap)
+	} else {
+		results, err = detectServices(ctx, portMap, cfg)
+	}
+
+	if tagErr := tagResults(results, targets, cfg); tagErr != nil {
+		err = errors.Join(err, tagErr)
+	}
+	enrichResults(ctx, results, cfg)
+	if ctx.Err() != nil {
+		return results, errors.Join(err, ctx.Err())
+	}
+	return results, err
+}
+
+// 对服务识别后的结果进行进一步探测，ctx取消后跳过尚未开始的探测
+func enrichResults(ctx context.Context, results []ScanResult, cfg *Config) {
+	if ctx.Err() != nil {
+		return
+	}
+	if cfg.HTTPProbe {
+		httpProbeResults(results, cfg)
+		if cfg.Fingerprint {
+			fingerprintResults(results, cfg)
+		}
+	}
+	if cfg.UnauthCheck && ctx.Err() == nil {
+		unauthCheckResults(results, cfg)
+	}
+	if cfg.Brute && ctx.Err() == nil {
+		bruteResults(results, cfg)
+	}
+	if cfg.TLSInspect && ctx.Err() == nil {
+		tlsInspectResults(results, cfg)
+	}
+	if cfg.VulnMatch {
+		vulnResults(results, cfg)
+	}
+}
+
+// 提前加载指纹规则、弱口令字典、漏洞库与标签对应关系，有误时不开始扫描
+func prepareProbes(cfg *Config) error {
+	if _, err := loadTagRules(); err != nil {
+		return err
+	}
+	if _, err := loadNotifyTemplates(); err != nil {
+		return err
+	}
+	if cfg.HTTPProbe && cfg.Fingerprint {
+		if _, err := loadFingerprints(); err != nil {
+			return err
+		}
+	}
+	if cfg.Brute {
+		if _, err := loadBruteDict(); err != nil {
+			return err
+		}
+	}
+	if cfg.VulnMatch {
+		if _, err := loadVulnDB(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 解析配置中的端口与排除端口
+func resolvePorts(cfg *Config) (*PortSpec, error) {
+	// 加载端口库，topN与服务名均依赖该数据
+	if _, err := defaultPortDB(); err != nil {
+		return nil, err
+	}
+
+	// 解析port参数，获取具体端口内容
+	portSpec, err := parsePortSpec(cfg.Ports)
+	if err != nil {
+		return nil, wrapError(ErrInvalidPortSpec, err)
+	}
+
+	// 剔除需要排除的端口
+	excludeSpec, err := resolveExcludePorts(cfg)
+	if err != nil {
+		return nil, err
+	}
+	portSpec.Exclude(excludeSpec)
+	if portSpec.Empty() {
+		return nil, fmt.Errorf("%w: 排除后没有需要扫描的端口", ErrInvalidPortSpec)
+	}
+	return portSpec, nil
+}
+
+// 解析配置中的排除端口，未指定时返回nil
+func resolveExcludePorts(cfg *Config) (*PortSpec, error) {
+	if cfg.ExcludePorts == "" {
+		return nil, nil
+	}
+	excludeSpec, err := parsePortSpec(cfg.ExcludePorts)
+	if err != nil {
+		return nil, fmt.Errorf("%w: 排除端口 %w", ErrInvalidPortSpec, err)
+	}
+	return excludeSpec, nil
+}
+
+// 目标的文字描述，用于结果记录
+func targetDescription(cfg *Config) string {
+	switch {
+	case cfg.Targets != "":
+		return cfg.Targets
+	case cfg.TargetFile == stdinTarget:
+		return "标准输入"
+	}
+	return cfg.TargetFile
+}
+
+// 获取扫描目标，判断是通过ip参数传参还是通过文件传参
+// portSpec为-p指定的端口，目标文件中未单独指定端口的目标扫描这些端口
+func loadTargets(cfg *Config, portSpec *PortSpec) ([]Target, error) {
+	// 检测是否输入目标
+	if cfg.Targets == "" && cfg.TargetFile == "" {
+		return nil, fmt.Errorf("%w: 未指定目标 可通过-h查看用法", ErrInvalidTarget)
+	}
+
+	slog.Info("输入目标：" + targetDescription(cfg))
+	fileWrite(fmt.Sprintf("探测目标：%s", targetDescription(cfg)))
+
+	var targets []Target
+	if cfg.Targets != "" {
+		// 检测ip参数格式是否合法，获取具体的ip
+		ipSlice, err := ipFormatCheck(cfg.Targets)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ipSlice {
+			targets = append(targets, Target{IP: ip, Ports: portSpec})
+		}
+	} else {
+		excludeSpec, err := resolveExcludePorts(cfg)
+		if err != nil {
+			return nil, err
+		}
+		if targets, err = readTargetFile(cfg.TargetFile, portSpec, excludeSpec); err != nil {
+			return nil, err
+		}
+		if len(targets) == 0 {
+			return nil, fmt.Errorf("%w: 目标文件中没有任何目标", ErrInvalidTarget)
+		}
+	}
+
+	slog.Info(fmt.Sprintf("共发现%d个ip", len(targets)))
+	return targets, nil
+}
+
+// 目标的ip列表
+func targetIPs(targets []Target) []string {
+	ipSlice := make([]string, 0, len(targets))
+	for _, target := range targets {
+		ipSlice = append(ipSlice, target.IP)
+	}
+	return ipSlice
+}
+
+// 只保留存活的目标
+func aliveTargets(targets []Target, aliveSlice []string) []Target {
+	alive := make(map[string]bool, len(aliveSlice))
+	for _, ip := range aliveSlice {
+		alive[ip] = true
+	}
+
+	var kept []Target
+	for _, target := range targets {
+		if alive[target.IP] {
+			kept = append(kept, target)
+		}
+	}
+	return kept
+}
+
+// 将结果按时间戳保存为excel与json文件
+func saveOutputs(report *ScanReport, cfg *Config) error {
+	sortResults(report.Results)
+
+	timestamp := time.Now().Format("20060102_1504")
+	base := filepath.Join(cfg.OutputDir, "portResult-"+timestamp)
+
+	// 将结果保存到excel表中
+	start := time.Now()
+	if err := saveToExcel(report.Results, base+".xlsx"); err != nil {
+		return fmt.Errorf("%w: excel结果保存失败: %w", ErrExportFailed, err)
+	}
+	metricExportDuration.since(start, formatExcel)
+	start = time.Now()
+	if err := saveToJSON(report, base+".json"); err != nil {
+		return fmt.Errorf("%w: json结果保存失败: %w", ErrExportFailed, err)
+	}
+	metricExportDuration.since(start, formatJSON)
+	slog.Info("结果已保存: " + base + ".xlsx " + base + ".json")
+	return nil
+}
+
+func runDiscover(args []string) error {
+	fs := newFlagSet("discover", "仅进行主机存活探测，先ping探测，ping不通的ip再探测常用端口")
+	loadCfg := configFlags(fs, "discover")
+	outputInput := fs.String("o", "", "将存活ip保存到指定文件，每行一个ip")
+	fs.Parse(args)
+
+	cfg, err := loadCfg()
+	if err != nil {
+		return err
+	}
+
+	targets, err := loadTargets(cfg, nil)
+	if err != nil {
+		return err
+	}
+
+	aliveSlice := ipAliveCheck(targetIPs(targets), cfg)
+	if *outputInput == "" {
+		return nil
+	}
+
+	if err := ensureDir(*outputInput); err != nil {
+		return wrapError(ErrExportFailed, err)
+	}
+	if err := os.WriteFile(*outputInput, []byte(strings.Join(aliveSlice, "\n")+"\n"), 0644); err != nil {
+		return wrapError(ErrExportFailed, err)
+	}
+	return nil
+}
+
+func runDetect(args []string) error {
+	fs := newFlagSet("detect", "对指定的ip:port列表进行服务识别，跳过端口开放探测")
+	loadCfg := configFlags(fs, "detect")
+	targetInput := fs.String("t", "", "以逗号分割的ip:port列表，例如 10.1.1.2:80,10.1.1.2:22")
+	fileInput := fs.String("f", "", "指定ip:port列表文件，每行一个")
+	fs.Parse(args)
+
+	cfg, err := loadCfg()
+	if err != nil {
+		return err
+	}
+	if err := prepareProbes(cfg); err != nil {
+		return err
+	}
+
+	var entries []string
+	if *targetInput != "" {
+		entries = append(entries, strings.Split(*targetInput, ",")...)
+	}
+	if *fileInput != "" {
+		content, err := os.ReadFile(*fileInput)
+		if err != nil {
+			return fmt.Errorf("%w: 文件读取失败: %v", ErrInvalidTarget, err)
+		}
+		entries = append(entries, strings.Split(string(content), "\n")...)
+	}
+
+	startTime := time.Now()
+	portMap, err := parseHostPorts(entries)
+	if err != nil {
+		return err
+	}
+	if len(portMap) == 0 {
+		return fmt.Errorf("%w: 未指定ip:port列表 可通过-h查看用法", ErrInvalidTarget)
+	}
+
+	var results []ScanResult
+	var detectErr error
+	if cfg.Detector == detectorNone {
+		results = portMapResults(portMap)
+	} else {
+		// 未进行端口开放探测，服务识别不可用时没有可保存的结果
+		results, detectErr = detectServices(context.Background(), portMap, cfg)
+		if errors.Is(detectErr, ErrDetectorUnavailable) {
+			return detectErr
+		}
+	}
+
+	report := &ScanReport{
+		StartTime: startTime,
+		Targets:   strings.Join(entries, ","),
+		Results:   results,
+	}
+	if err := tagResults(report.Results, nil, cfg); err != nil {
+		detectErr = errors.Join(detectErr, err)
+	}
+	enrichResults(context.Background(), report.Results, cfg)
+	report.EndTime = time.Now()
+	if err := saveOutputs(report, cfg); err != nil {
+		return err
+	}
+	if err := recordRun("detect", report); err != nil {
+		return err
+	}
+	notifyScan(cfg, "detect", report, detectErr)
+	if detectErr != nil {
+		return &PartialError{Err: detectErr}
+	}
+	return nil
+}
+
+// 将ip:port列表转换为以ip为key的端口map，忽略空行
+func parseHostPorts(entries []string) (map[string][]string, error) {
+	portMap := make(map[string][]string)
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		host, port, err := net.SplitHostPort(entry)
+		if err != nil || net.ParseIP(host) == nil {
+			return nil, fmt.Errorf("%w: ip:port格式输入有误: %s", ErrInvalidTarget, entry)
+		}
+		if _, err := parsePortNumber(port); err != nil {
+			return nil, fmt.Errorf("%w: ip:port格式输入有误: %s", ErrInvalidTarget, entry)
+		}
+		portMap[host] = append(portMap[host], port)
+	}
+	return portMap, nil
+}
+
+func runReport(args []string) error {
+	fs := newFlagSet("report", "将scan或detect保存的结果转换为其他格式")
+	inputInput := fs.String("i", "", "输入的结果文件（json或xlsx），也可使用db:<编号>、db:latest读取-db数据库中的扫描记录")
+	outputInput := fs.String("o", "", "输出文件路径，未指定时在终端输出")
+	formatInput := fs.String("format", "", "输出格式：xlsx、csv、json、txt，默认根据输出文件扩展名判断")
+	rematchInput := fs.Bool("vuln-rematch", false, "使用当前的漏洞库重新关联结果中的漏洞，漏洞库更新后无需重新扫描")
+	tagInput := fs.String("tag", "", "只输出带有指定标签的结果，多个标签以逗号分割需同时满足，只写键时匹配该键的任意值，例如 bu=finance,env")
+	loadCfg := configFlags(fs, "report")
+	fs.Parse(args)
+
+	if *inputInput == "" {
+		return fmt.Errorf("%w: 未指定输入文件 可通过-h查看用法", ErrInvalidArgument)
+	}
+
+	cfg, err := loadCfg()
+	if err != nil {
+		return err
+	}
+	report, err := loadReport(*inputInput)
+	if err != nil {
+		return err
+	}
+	sortResults(report.Results)
+
+	if *rematchInput {
+		db, err := loadVulnDB()
+		if err != nil {
+			return err
+		}
+		correlateVulns(report.Results, db, parseConfidence(cfg.VulnConfidence))
+	}
+	report.Results = filterByTags(report.Results, *tagInput)
+
+	if *outputInput == "" {
+		for _, result := range report.Results {
+			fmt.Println(resultLine(result))
+		}
+		return nil
+	}
+
+	format := *formatInput
+	if format == "" {
+		format = formatFromPath(*outputInput)
+	}
+	if err := exportReport(report, format, *outputInput); err != nil {
+		return err
+	}
+	slog.Info("结果已保存: " + *outputInput)
+	return nil
+}
+
+func runDiff(args []string) error {
+	fs := newFlagSet("diff", "对比两次扫描保存的结果，输出新增/消失的主机、新开放/已关闭的端口以及服务版本变化")
+	oldInput := fs.String("old", "", "较早一次扫描的结果文件（json或xlsx），也可使用db:<编号>、db:previous读取-db数据库中的扫描记录")
+	newInput := fs.String("new", "", "较新一次扫描的结果文件（json或xlsx），也可使用db:<编号>、db:latest")
+	outputInput := fs.String("o", "", "将差异保存到指定文件")
+	formatInput := fs.String("format", "", "差异文件格式：txt、json、xlsx，默认根据输出文件扩展名判断")
+	tagInput := fs.String("tag", "", "只对比带有指定标签的结果，多个标签以逗号分割需同时满足，只写键时匹配该键的任意值")
+	loadCfg := configFlags(fs, "diff")
+	fs.Parse(args)
+
+	if *oldInput == "" || *newInput == "" {
+		return fmt.Errorf("%w: 需要同时指定-old与-new 可通过-h查看用法", ErrInvalidArgument)
+	}
+	if _, err := loadCfg(); err != nil {
+		return err
+	}
+
+	oldReport, err := loadReport(*oldInput)
+	if err != nil {
+		return err
+	}
+	newReport, err := loadReport(*newInput)
+	if err != nil {
+		return err
+	}
+
+	oldReport.Results = filterByTags(oldReport.Results, *tagInput)
+	newReport.Results = filterByTags(newReport.Results, *tagInput)
+
+	diff := diffReports(oldReport, newReport)
+	printDiff(diff)
+	if *outputInput == "" {
+		return nil
+	}
+
+	format := *formatInput
+	if format == "" {
+		format = formatFromPath(*outputInput)
+	}
+	if err := exportDiff(diff, format, *outputInput); err != nil {
+		return err
+	}
+	slog.Info("差异已保存: " + *outputInput)
+	return nil
+}
@@ -0,0 +1,565 @@
+package tools
+
+import (
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+配置来源及优先级（后者覆盖前者）
+1、内置默认值
+2、配置文件：默认读取 ~/.config/miao-portscan/config.yaml，可通过 -config 或环境变量 MIAO_PORTSCAN_CONFIG 指定
+3、环境变量：MIAO_PORTSCAN_<配置项大写>，例如 MIAO_PORTSCAN_THREADS=500
+4、命令行参数
+*/
+
+// 环境变量前缀
+const envPrefix = "MIAO_PORTSCAN_"
+
+// Config 扫描的全部配置项
+type Config struct {
+	// 扫描目标
+	Targets    string `yaml:"targets"`
+	TargetFile string `yaml:"target_file"`
+
+	// 端口
+	Ports        string                 `yaml:"ports"`
+	ExcludePorts string                 `yaml:"exclude_ports"`
+	PortDB       string                 `yaml:"port_db"`
+	ProfileFile  string                 `yaml:"profile_file"`
+	Profiles     map[string]PortProfile `yaml:"profiles,omitempty"`
+
+	// 标签
+	Tags     string             `yaml:"tags"`
+	TagMap   string             `yaml:"tag_map"`
+	TagRules map[string]tagList `yaml:"tag_rules,omitempty"`
+
+	// 并发、超时与速率
+	Threads int           `yaml:"threads"`
+	Timeout time.Duration `yaml:"timeout"`
+	Rate    int           `yaml:"rate"`
+
+	// 代理
+	Proxy        string        `yaml:"proxy"`
+	ProxyConfirm time.Duration `yaml:"proxy_confirm"`
+
+	// 源地址
+	SourceIP   string `yaml:"source_ip"`
+	Interface  string `yaml:"interface"`
+	SourcePort int    `yaml:"source_port"`
+
+	// 进度显示
+	Progress         bool          `yaml:"progress"`
+	ProgressInterval time.Duration `yaml:"progress_interval"`
+
+	// 服务识别
+	Detector    string        `yaml:"detector"`
+	NmapPath    string        `yaml:"nmap_path"`
+	NmapTimeout time.Duration `yaml:"nmap_timeout"`
+
+	// HTTP探测
+	HTTPProbe        bool          `yaml:"http_probe"`
+	HTTPTimeout      time.Duration `yaml:"http_timeout"`
+	HTTPMaxRedirects int           `yaml:"http_max_redirects"`
+
+	// 指纹识别
+	Fingerprint     bool   `yaml:"fingerprint"`
+	FingerprintDirs string `yaml:"fingerprint_dirs"`
+
+	// 未授权访问检查
+	UnauthCheck bool `yaml:"unauth_check"`
+
+	// 弱口令检测
+	Brute                bool          `yaml:"brute"`
+	BruteServices        string        `yaml:"brute_services"`
+	BruteUsers           string        `yaml:"brute_users"`
+	BrutePasswords       string        `yaml:"brute_passwords"`
+	BruteThreads         int           `yaml:"brute_threads"`
+	BruteTimeout         time.Duration `yaml:"brute_timeout"`
+	BruteDelay           time.Duration `yaml:"brute_delay"`
+	BruteLockoutAttempts int           `yaml:"brute_lockout_attempts"`
+	BruteLockoutWait     time.Duration `yaml:"brute_lockout_wait"`
+	BruteStopOnSuccess   bool          `yaml:"brute_stop_on_success"`
+	BruteShowPassword    bool          `yaml:"brute_show_password"`
+
+	// 漏洞关联
+	VulnMatch      bool   `yaml:"vuln_match"`
+	VulnDB         string `yaml:"vuln_db"`
+	VulnConfidence string `yaml:"vuln_confidence"`
+
+	// TLS探测
+	TLSInspect bool          `yaml:"tls_inspect"`
+	TLSCiphers bool          `yaml:"tls_ciphers"`
+	TLSTimeout time.Duration `yaml:"tls_timeout"`
+
+	// 输出
+	OutputDir  string `yaml:"output_dir"`
+	TextOutput string `yaml:"text_output"`
+	DB         string `yaml:"db"`
+
+	// 指标
+	Metrics string `yaml:"metrics"`
+
+	// 通知
+	Notify         string `yaml:"notify"`
+	NotifySeverity string `yaml:"notify_severity"`
+	NotifyFindings bool   `yaml:"notify_findings"`
+	NotifyTemplate string `yaml:"notify_template"`
+	NotifyRetries  int    `yaml:"notify_retries"`
+
+	// 日志
+	LogLevel string `yaml:"log_level"`
+	LogFile  string `yaml:"log_file"`
+	NoColor  bool   `yaml:"no_color"`
+}
+
+// 服务识别方式
+const (
+	detectorNmap   = "nmap"
+	detectorBanner = "banner"
+	detectorNone   = "none"
+)
+
+// 默认配置
+func defaultConfig() *Config {
+	return &Config{
+		Ports:       "top1000",
+		Threads:     200,
+		Timeout:     2 * time.Second,
+		Detector:    detectorNmap,
+		NmapTimeout: 5 * time.Minute,
+
+		ProxyConfirm: 500 * time.Millisecond,
+
+		Progress:         true,
+		ProgressInterval: 10 * time.Second,
+
+		HTTPProbe:        true,
+		HTTPTimeout:      5 * time.Second,
+		HTTPMaxRedirects: 3,
+
+		Fingerprint: true,
+
+		UnauthCheck: true,
+
+		BruteThreads:         10,
+		BruteTimeout:         10 * time.Second,
+		BruteLockoutAttempts: 3,
+		BruteLockoutWait:     30 * time.Minute,
+		BruteStopOnSuccess:   true,
+
+		VulnMatch:      true,
+		VulnConfidence: "low",
+
+		TLSInspect: true,
+		TLSTimeout: 5 * time.Second,
+
+		OutputDir:  "result",
+		TextOutput: "result.txt",
+
+		NotifySeverity: "high",
+		NotifyRetries:  3,
+
+		LogLevel: "info",
+	}
+}
+
+// 可由环境变量和命令行参数设置的配置项
+type configOption struct {
+	flag  string            // 命令行参数名
+	key   string            // 配置文件中的键名，同时用于生成环境变量名
+	cmds  string            // 提供该命令行参数的子命令，以空格分割
+	usage string            // 帮助信息
+	field func(*Config) any // 返回配置项字段的指针
+}
+
+var configOptions = []configOption{
+	{"ip", "targets", "scan discover coordinator monitor", "输入要扫描的目标ip，支持格式：<10.1.1.2> <10.1.1.1,10.1.1.2,10.1.1.3> <10.1.1.1-6> <10.1.1.0/24>",
+		func(c *Config) any { return &c.Targets }},
+	{"l", "target_file", "scan discover coordinator monitor", "指定目标文件进行批量扫描，支持-ip的全部格式、#注释、ip:端口（单独指定该行的端口），以及带标签的.csv/.json文件，- 表示从标准输入读取",
+		func(c *Config) any { return &c.TargetFile }},
+	{"p", "ports", "scan coordinator serve monitor", "指定要扫描的端口，可混合使用，合法格式举例:<80> <22,80,3306> <100-1000> <-1024> <60000-> <-> <top100> <top1000> <ssh,http,mysql> <T:80,U:53> <profile:web>",
+		func(c *Config) any { return &c.Ports }},
+	{"exclude-ports", "exclude_ports", "scan coordinator serve monitor", "指定要排除的端口，格式同-p参数",
+		func(c *Config) any { return &c.ExcludePorts }},
+	{"port-db", "port_db", "scan coordinator worker serve monitor", "指定nmap-services格式的端口库文件，topN按其中的开放频率取端口，没有频率字段时按行的先后；默认使用内置的固定顺序",
+		func(c *Config) any { return &c.PortDB }},
+	{"profiles", "profile_file", "scan coordinator serve monitor", "指定自定义端口配置文件（yaml或json格式），可通过-p profile:<名称>使用",
+		func(c *Config) any { return &c.ProfileFile }},
+	{"tags", "tags", "scan detect coordinator serve monitor", "为本次扫描的全部结果添加标签，多个以逗号分割，例如 bu=finance,env=prod",
+		func(c *Config) any { return &c.Tags }},
+	{"tag-map", "tag_map", "scan detect coordinator serve monitor", "ip段与标签的对应文件（yaml或json），例如 10.1.0.0/16: [bu=finance]，结果按所在网段自动添加标签",
+		func(c *Config) any { return &c.TagMap }},
+	{"thread", "threads", "scan discover worker serve monitor", "指定扫描线程",
+		func(c *Config) any { return &c.Threads }},
+	{"timeout", "timeout", "scan discover worker serve monitor", "端口连接超时时间，例如 2s、500ms",
+		func(c *Config) any { return &c.Timeout }},
+	{"rate", "rate", "scan worker serve monitor", "每秒最多发起的连接数，0为不限制",
+		func(c *Config) any { return &c.Rate }},
+	{"proxy", "proxy", "scan discover detect worker serve monitor", "经由代理进行端口扫描与探测，支持socks5://[user:pass@]host:port与http://[user:pass@]host:port（CONNECT），多个以逗号分割组成代理链",
+		func(c *Config) any { return &c.Proxy }},
+	{"proxy-confirm", "proxy_confirm", "scan discover worker serve monitor", "经由代理发现开放端口后，在该时间内连接未被代理断开才视为开放，0为不确认",
+		func(c *Config) any { return &c.ProxyConfirm }},
+	{"S", "source_ip", "scan discover detect worker serve monitor", "指定发起探测使用的源ip，需为本机地址",
+		func(c *Config) any { return &c.SourceIP }},
+	{"e", "interface", "scan discover detect worker serve monitor", "指定发起探测使用的网卡，linux下绑定该网卡（需要root权限），其他系统使用网卡的地址作为源ip",
+		func(c *Config) any { return &c.Interface }},
+	{"g", "source_port", "scan discover detect worker serve monitor", "指定tcp连接的源端口，例如53、88，0为随机",
+		func(c *Config) any { return &c.SourcePort }},
+	{"progress", "progress", "scan worker monitor", "端口扫描时在stderr显示进度，终端下为进度条，否则定期输出状态行；扫描中按回车或发送SIGUSR1可随时输出状态",
+		func(c *Config) any { return &c.Progress }},
+	{"progress-interval", "progress_interval", "scan worker monitor", "stderr不是终端时输出进度状态行的间隔",
+		func(c *Config) any { return &c.ProgressInterval }},
+	{"detector", "detector", "scan detect worker serve monitor", "服务识别方式：nmap、banner（读取服务banner识别，不依赖nmap，可经由代理）或 none（不进行服务识别）",
+		func(c *Config) any { return &c.Detector }},
+	{"nmap-path", "nmap_path", "scan detect worker serve monitor", "指定nmap程序路径，默认windows下使用lib/nmap/nmap.exe，其他系统从PATH中查找",
+		func(c *Config) any { return &c.NmapPath }},
+	{"nmap-timeout", "nmap_timeout", "scan detect worker serve monitor", "单个ip的nmap服务识别超时时间",
+		func(c *Config) any { return &c.NmapTimeout }},
+	{"http-probe", "http_probe", "scan detect worker serve monitor", "对开放端口进行HTTP/HTTPS探测，获取状态码、标题、响应头与favicon哈希",
+		func(c *Config) any { return &c.HTTPProbe }},
+	{"http-timeout", "http_timeout", "scan detect worker serve monitor", "单次HTTP请求超时时间",
+		func(c *Config) any { return &c.HTTPTimeout }},
+	{"http-max-redirects", "http_max_redirects", "scan detect worker serve monitor", "HTTP探测最多跟随的跳转次数",
+		func(c *Config) any { return &c.HTTPMaxRedirects }},
+	{"fingerprint", "fingerprint", "scan detect worker serve monitor", "根据HTTP探测结果进行web指纹识别，需要同时开启-http-probe",
+		func(c *Config) any { return &c.Fingerprint }},
+	{"fingerprint-dirs", "fingerprint_dirs", "scan detect worker serve monitor", "自定义指纹规则目录，多个目录以逗号分割，目录中的yaml或json规则文件会与内置规则合并",
+		func(c *Config) any { return &c.FingerprintDirs }},
+	{"unauth-check", "unauth_check", "scan detect worker serve monitor", "对Redis、Memcached、MongoDB、ZooKeeper、Elasticsearch、Docker、etcd、Kubelet进行只读的未授权访问检查",
+		func(c *Config) any { return &c.UnauthCheck }},
+	{"brute", "brute", "scan detect worker serve monitor", "对SSH、FTP、MySQL、MSSQL、PostgreSQL、Redis、SMB、RDP进行弱口令检测，仅限已授权的测试使用",
+		func(c *Config) any { return &c.Brute }},
+	{"brute-services", "brute_services", "scan detect worker serve monitor", "只对指定服务进行弱口令检测，以逗号分割，默认全部，例如 ssh,mysql,rdp",
+		func(c *Config) any { return &c.BruteServices }},
+	{"brute-users", "brute_users", "scan detect worker serve monitor", "用户名字典文件，每行一个，默认使用内置的各服务常用用户名",
+		func(c *Config) any { return &c.BruteUsers }},
+	{"brute-passwords", "brute_passwords", "scan detect worker serve monitor", "密码字典文件，每行一个，{user}会替换为用户名，默认使用内置字典",
+		func(c *Config) any { return &c.BrutePasswords }},
+	{"brute-threads", "brute_threads", "scan detect worker serve monitor", "每种服务同时进行弱口令检测的目标数",
+		func(c *Config) any { return &c.BruteThreads }},
+	{"brute-timeout", "brute_timeout", "scan detect worker serve monitor", "单次登录尝试的超时时间",
+		func(c *Config) any { return &c.BruteTimeout }},
+	{"brute-delay", "brute_delay", "scan detect worker serve monitor", "对同一目标两次登录尝试之间的间隔",
+		func(c *Config) any { return &c.BruteDelay }},
+	{"brute-lockout-attempts", "brute_lockout_attempts", "scan detect worker serve monitor", "MSSQL、SMB、RDP等存在账号锁定策略的服务，每个账号尝试该次数后等待-brute-lockout-wait，0为不等待",
+		func(c *Config) any { return &c.BruteLockoutAttempts }},
+	{"brute-lockout-wait", "brute_lockout_wait", "scan detect worker serve monitor", "达到-brute-lockout-attempts后的等待时间，应不小于目标的账号锁定计数重置时间",
+		func(c *Config) any { return &c.BruteLockoutWait }},
+	{"brute-stop-on-success", "brute_stop_on_success", "scan detect worker serve monitor", "每个目标发现一个可登录账号后即停止检测",
+		func(c *Config) any { return &c.BruteStopOnSuccess }},
+	{"brute-show-password", "brute_show_password", "scan detect worker serve monitor", "在输出与结果文件中显示明文密码，默认只显示用户名",
+		func(c *Config) any { return &c.BruteShowPassword }},
+	{"vuln-match", "vuln_match", "scan detect worker serve monitor", "根据识别到的产品版本与cpe离线关联漏洞库中的候选CVE",
+		func(c *Config) any { return &c.VulnMatch }},
+	{"vuln-db", "vuln_db", "scan detect report worker serve monitor", "额外的漏洞库文件或目录，多个以逗号分割，支持内置格式的yaml/json与NVD的json数据（可为.gz），同一编号的漏洞以后加载的为准",
+		func(c *Config) any { return &c.VulnDB }},
+	{"vuln-confidence", "vuln_confidence", "scan detect report worker serve monitor", "只输出不低于该置信度的漏洞：high、medium、low",
+		func(c *Config) any { return &c.VulnConfidence }},
+	{"tls-inspect", "tls_inspect", "scan detect worker serve monitor", "对开放端口进行TLS探测（含SMTP/IMAP/POP3/FTP/LDAP/PostgreSQL的STARTTLS），获取证书、协议版本与指纹",
+		func(c *Config) any { return &c.TLSInspect }},
+	{"tls-ciphers", "tls_ciphers", "scan detect worker serve monitor", "逐个枚举服务端支持的加密套件，会显著增加连接数",
+		func(c *Config) any { return &c.TLSCiphers }},
+	{"tls-timeout", "tls_timeout", "scan detect worker serve monitor", "单次TLS握手超时时间",
+		func(c *Config) any { return &c.TLSTimeout }},
+	{"output-dir", "output_dir", "scan detect coordinator", "excel结果保存目录",
+		func(c *Config) any { return &c.OutputDir }},
+	{"text-output", "text_output", "scan discover detect coordinator worker serve monitor", "文本结果文件路径",
+		func(c *Config) any { return &c.TextOutput }},
+	{"db", "db", "scan detect report diff coordinator serve history monitor", "将每次扫描的记录与结果保存到指定的数据库文件，用于history查询历史，report与diff可通过 db:<编号> 读取",
+		func(c *Config) any { return &c.DB }},
+	{"metrics", "metrics", "scan serve monitor coordinator worker", "在指定地址（例如 127.0.0.1:9101）的/metrics输出Prometheus格式的运行指标，为空时不启用",
+		func(c *Config) any { return &c.Metrics }},
+	{"notify", "notify", "scan detect coordinator serve notify", "扫描结束时发送通知，多个以逗号分割：webhook:<url>、slack:<url>（兼容Mattermost）、dingtalk:<url>、wecom:<url>、feishu:<url>，钉钉与飞书加签时在地址后附加&secret=<密钥>",
+		func(c *Config) any { return &c.Notify }},
+	{"notify-severity", "notify_severity", "scan detect coordinator serve notify", "通知中列出的问题等级下限：critical、high、medium、low",
+		func(c *Config) any { return &c.NotifySeverity }},
+	{"notify-findings", "notify_findings", "scan detect coordinator serve", "每个达到-notify-severity等级的问题单独发送一条通知，最多20条",
+		func(c *Config) any { return &c.NotifyFindings }},
+	{"notify-template", "notify_template", "scan detect coordinator serve monitor notify", "通知消息模板文件（text/template），可定义summary、finding、alert模板覆盖默认内容",
+		func(c *Config) any { return &c.NotifyTemplate }},
+	{"notify-retries", "notify_retries", "scan detect coordinator serve monitor notify", "通知发送失败时的重试次数，限流时的等待不计入",
+		func(c *Config) any { return &c.NotifyRetries }},
+	{"log-level", "log_level", "scan discover detect report diff coordinator worker serve monitor", "日志级别：trace、debug、info、warn、error，也可使用-v、-vv、-q",
+		func(c *Config) any { return &c.LogLevel }},
+	{"log-file", "log_file", "scan discover detect report diff coordinator worker serve monitor", "将日志以json格式追加写入指定文件，至少记录info级别",
+		func(c *Config) any { return &c.LogFile }},
+	{"no-color", "no_color", "scan discover detect report diff coordinator worker serve monitor", "禁用彩色输出，stdout或stderr不是终端时自动禁用",
+		func(c *Config) any { return &c.NoColor }},
+}
+
+// 配置项对应的环境变量名
+func (o configOption) env() string {
+	return envPrefix + strings.ToUpper(o.key)
+}
+
+// 判断配置项是否作为指定子命令的命令行参数
+func (o configOption) appliesTo(cmd string) bool {
+	for _, c := range strings.Fields(o.cmds) {
+		if c == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+// 在参数集合上注册子命令对应的配置项，参数值写入cli，默认值取自defaults
+func registerConfigFlags(fs *flag.FlagSet, cmd string, cli *Config, defaults *Config) {
+	for _, opt := range configOptions {
+		if !opt.appliesTo(cmd) {
+			continue
+		}
+		usage := fmt.Sprintf("%s (环境变量 %s)", opt.usage, opt.env())
+		switch p := opt.field(cli).(type) {
+		case *string:
+			fs.StringVar(p, opt.flag, *opt.field(defaults).(*string), usage)
+		case *int:
+			fs.IntVar(p, opt.flag, *opt.field(defaults).(*int), usage)
+		case *time.Duration:
+			fs.DurationVar(p, opt.flag, *opt.field(defaults).(*time.Duration), usage)
+		case *bool:
+			fs.BoolVar(p, opt.flag, *opt.field(defaults).(*bool), usage)
+		}
+	}
+}
+
+// 默认配置文件路径
+func userConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "miao-portscan", "config.yaml")
+}
+
+// 按优先级合并配置：默认值 < 配置文件 < 环境变量 < 命令行参数
+// path为-config参数的值，cli为命令行参数解析结果，只有显式指定的参数才会覆盖
+func loadConfig(path string, fs *flag.FlagSet, cli *Config) (*Config, error) {
+	cfg := defaultConfig()
+
+	// 未通过参数指定时依次尝试环境变量和默认路径，默认路径的文件不存在时忽略
+	explicit := path != ""
+	if !explicit {
+		path = os.Getenv(envPrefix + "CONFIG")
+		explicit = path != ""
+	}
+	if !explicit {
+		path = userConfigPath()
+	}
+
+	if path != "" {
+		content, err := os.ReadFile(path)
+		switch {
+		case err == nil:
+			if err := yaml.Unmarshal(content, cfg); err != nil {
+				return nil, fmt.Errorf("配置文件 %s 解析失败: %v", path, err)
+			}
+		case explicit || !os.IsNotExist(err):
+			return nil, fmt.Errorf("配置文件读取失败: %v", err)
+		}
+	}
+
+	for _, opt := range configOptions {
+		value, ok := os.LookupEnv(opt.env())
+		if !ok {
+			continue
+		}
+		if err := setConfigValue(opt.field(cfg), value); err != nil {
+			return nil, fmt.Errorf("环境变量 %s 的值不合法: %v", opt.env(), err)
+		}
+	}
+
+	if fs != nil {
+		set := make(map[string]bool)
+		fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		for _, opt := range configOptions {
+			if !set[opt.flag] {
+				continue
+			}
+			switch p := opt.field(cfg).(type) {
+			case *string:
+				*p = *opt.field(cli).(*string)
+			case *int:
+				*p = *opt.field(cli).(*int)
+			case *time.Duration:
+				*p = *opt.field(cli).(*time.Duration)
+			case *bool:
+				*p = *opt.field(cli).(*bool)
+			}
+		}
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// 将字符串形式的值写入配置项
+func setConfigValue(field any, value string) error {
+	switch p := field.(type) {
+	case *string:
+		*p = value
+	case *int:
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*p = v
+	case *time.Duration:
+		v, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*p = v
+	case *bool:
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*p = v
+	}
+	return nil
+}
+
+// 校验配置项取值
+func (c *Config) validate() error {
+	if c.Threads <= 0 {
+		return fmt.Errorf("线程数必须大于0: %d", c.Threads)
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("连接超时时间必须大于0: %s", c.Timeout)
+	}
+	if c.Rate < 0 {
+		return fmt.Errorf("速率限制不能为负数: %d", c.Rate)
+	}
+	if c.ProgressInterval <= 0 {
+		return fmt.Errorf("进度输出间隔必须大于0: %s", c.ProgressInterval)
+	}
+	if c.HTTPTimeout <= 0 {
+		return fmt.Errorf("HTTP请求超时时间必须大于0: %s", c.HTTPTimeout)
+	}
+	if c.HTTPMaxRedirects < 0 {
+		return fmt.Errorf("HTTP跳转次数不能为负数: %d", c.HTTPMaxRedirects)
+	}
+	if c.TLSTimeout <= 0 {
+		return fmt.Errorf("TLS握手超时时间必须大于0: %s", c.TLSTimeout)
+	}
+	if c.BruteThreads <= 0 {
+		return fmt.Errorf("弱口令检测并发数必须大于0: %d", c.BruteThreads)
+	}
+	if c.BruteTimeout <= 0 {
+		return fmt.Errorf("弱口令检测超时时间必须大于0: %s", c.BruteTimeout)
+	}
+	if c.BruteDelay < 0 || c.BruteLockoutWait < 0 || c.BruteLockoutAttempts < 0 {
+		return fmt.Errorf("弱口令检测的间隔、等待时间与尝试次数不能为负数")
+	}
+	for _, name := range strings.Split(c.BruteServices, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" && !isBruteService(name) {
+			return fmt.Errorf("不支持弱口令检测的服务: %s", name)
+		}
+	}
+	if parseConfidence(c.VulnConfidence) == "" {
+		return fmt.Errorf("漏洞置信度只能为high、medium或low: %s", c.VulnConfidence)
+	}
+	if _, ok := logLevels[strings.ToLower(c.LogLevel)]; !ok {
+		return fmt.Errorf("不支持的日志级别: %s", c.LogLevel)
+	}
+	if c.ProxyConfirm < 0 {
+		return fmt.Errorf("代理确认时间不能为负数: %s", c.ProxyConfirm)
+	}
+	if _, err := parseProxyChain(c.Proxy); err != nil {
+		return err
+	}
+	if err := checkSourceAddress(c.SourceIP, c.Interface, c.SourcePort); err != nil {
+		return err
+	}
+	if c.Detector != detectorNmap && c.Detector != detectorBanner && c.Detector != detectorNone {
+		return fmt.Errorf("不支持的服务识别方式: %s", c.Detector)
+	}
+	if _, err := parseNotifiers(c.Notify); err != nil {
+		return err
+	}
+	if parseSeverity(c.NotifySeverity) == "" {
+		return fmt.Errorf("通知的问题等级只能为critical、high、medium或low: %s", c.NotifySeverity)
+	}
+	if c.NotifyRetries < 0 {
+		return fmt.Errorf("通知重试次数不能为负数: %d", c.NotifyRetries)
+	}
+	return nil
+}
+
+// 以yaml格式输出生效的配置
+func dumpConfig(c *Config) error {
+	// 代理的密码与通知地址的密钥不输出
+	redacted := *c
+	redacted.Proxy = redactURLList(c.Proxy)
+	redacted.Notify = redactURLList(c.Notify)
+	content, err := yaml.Marshal(&redacted)
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(content))
+	return nil
+}
+
+// 输出配置时隐藏的地址参数：钉钉与飞书加签的密钥、钉钉与企业微信机器人的令牌
+var redactedQueryKeys = []string{"secret", "access_token", "key"}
+
+// 令牌位于地址路径最后一段的通知类型：Slack与Mattermost的 /services/T…/B…/令牌、飞书的 /bot/v2/hook/令牌
+var pathTokenKinds = map[string]bool{"slack": true, "feishu": true}
+
+// 隐藏逗号分割的地址列表中的密码与密钥
+func redactURLList(spec string) string {
+	if spec == "" {
+		return spec
+	}
+	items := strings.Split(spec, ",")
+	for i, item := range items {
+		items[i] = redactURL(strings.TrimSpace(item))
+	}
+	return strings.Join(items, ",")
+}
+
+// 隐藏地址中的密码、密钥参数与路径中的令牌，通知地址可带类型前缀，例如 dingtalk:https://...
+func redactURL(item string) string {
+	prefix, rawURL := "", item
+	kind, rest, ok := strings.Cut(item, ":")
+	if ok && notifierKinds[kind] {
+		prefix, rawURL = kind+":", rest
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return item
+	}
+
+	changed := false
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+		changed = true
+	}
+	if path := strings.TrimSuffix(u.Path, "/"); pathTokenKinds[kind] && strings.Count(path, "/") > 1 {
+		u.Path = path[:strings.LastIndex(path, "/")+1] + "xxxxx"
+		u.RawPath = ""
+		changed = true
+	}
+	query := u.Query()
+	for _, key := range redactedQueryKeys {
+		if query.Has(key) {
+			query.Set(key, "xxxxx")
+			changed = true
+		}
+	}
+	if !changed {
+		return item
+	}
+	u.RawQuery = query.Encode()
+	return prefix + u.String()
+}
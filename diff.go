@@ -0,0 +1,240 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/xuri/excelize/v2"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ResultDiff 两次扫描结果的差异
+type ResultDiff struct {
+	NewHosts  []string        `json:"new_hosts"`  // 新出现的主机
+	GoneHosts []string        `json:"gone_hosts"` // 消失的主机
+	Opened    []ScanResult    `json:"opened"`     // 新开放的端口
+	Closed    []ScanResult    `json:"closed"`     // 已关闭的端口
+	Changed   []ServiceChange `json:"changed"`    // 服务、产品或版本发生变化的端口
+}
+
+// ServiceChange 同一端口前后两次识别到的服务
+type ServiceChange struct {
+	Old ScanResult `json:"old"`
+	New ScanResult `json:"new"`
+}
+
+// 差异类型，用于文本与excel输出
+const (
+	changeNewHost  = "新增主机"
+	changeGoneHost = "消失主机"
+	changeOpened   = "新开放端口"
+	changeClosed   = "已关闭端口"
+	changeService  = "服务变化"
+)
+
+// 以ip:port/协议作为结果的唯一标识，同一端口的tcp与udp结果互不覆盖
+func resultKey(result ScanResult) string {
+	return fmt.Sprintf("%s:%d/%s", result.IP, result.Port, resultProtocol(result))
+}
+
+// 结果的协议，未记录协议的旧结果视为tcp
+func resultProtocol(result ScanResult) string {
+	if result.Protocol == "" {
+		return "tcp"
+	}
+	return result.Protocol
+}
+
+// 对比两次扫描结果
+func diffReports(oldReport *ScanReport, newReport *ScanReport) *ResultDiff {
+	oldMap, oldHosts := indexResults(oldReport.Results)
+	newMap, newHosts := indexResults(newReport.Results)
+
+	diff := &ResultDiff{}
+	for host := range newHosts {
+		if !oldHosts[host] {
+			diff.NewHosts = append(diff.NewHosts, host)
+		}
+	}
+	for host := range oldHosts {
+		if !newHosts[host] {
+			diff.GoneHosts = append(diff.GoneHosts, host)
+		}
+	}
+
+	for key, result := range newMap {
+		old, ok := oldMap[key]
+		if !ok {
+			diff.Opened = append(diff.Opened, result)
+			continue
+		}
+		if old.Service != result.Service || old.Product != result.Product || old.Version != result.Version {
+			diff.Changed = append(diff.Changed, ServiceChange{Old: old, New: result})
+		}
+	}
+	for key, result := range oldMap {
+		if _, ok := newMap[key]; !ok {
+			diff.Closed = append(diff.Closed, result)
+		}
+	}
+
+	sort.Strings(diff.NewHosts)
+	sort.Strings(diff.GoneHosts)
+	sortResults(diff.Opened)
+	sortResults(diff.Closed)
+	sort.SliceStable(diff.Changed, func(i, j int) bool {
+		return resultKey(diff.Changed[i].New) < resultKey(diff.Changed[j].New)
+	})
+	return diff
+}
+
+// 以ip:port/协议为key建立索引，只统计开放状态的端口，同时返回出现过的主机
+func indexResults(results []ScanResult) (map[string]ScanResult, map[string]bool) {
+	resultMap := make(map[string]ScanResult)
+	hosts := make(map[string]bool)
+	for _, result := range results {
+		if result.Status != "" && result.Status != "open" {
+			continue
+		}
+		resultMap[resultKey(result)] = result
+		hosts[result.IP] = true
+	}
+	return resultMap, hosts
+}
+
+// Empty 判断两次结果是否完全一致
+func (d *ResultDiff) Empty() bool {
+	return len(d.NewHosts) == 0 && len(d.GoneHosts) == 0 && len(d.Opened) == 0 && len(d.Closed) == 0 && len(d.Changed) == 0
+}
+
+// 服务、产品与版本的文字描述，例如 http nginx 1.18.0
+func serviceText(result ScanResult) string {
+	return strings.Join(strings.Fields(result.Service+" "+result.Product+" "+result.Version), " ")
+}
+
+func printDiff(diff *ResultDiff) {
+	if diff.Empty() {
+		fmt.Println("两次扫描结果一致")
+		return
+	}
+
+	color.Green("%s --------------------", changeNewHost)
+	for _, host := range diff.NewHosts {
+		fmt.Printf("+ %s\n", host)
+	}
+	color.Green("%s --------------------", changeGoneHost)
+	for _, host := range diff.GoneHosts {
+		fmt.Printf("- %s\n", host)
+	}
+	color.Green("%s --------------------", changeOpened)
+	for _, result := range diff.Opened {
+		fmt.Printf("+ %s %s\n", resultKey(result), serviceText(result))
+	}
+	color.Green("%s --------------------", changeClosed)
+	for _, result := range diff.Closed {
+		fmt.Printf("- %s %s\n", resultKey(result), serviceText(result))
+	}
+	color.Green("%s --------------------", changeService)
+	for _, change := range diff.Changed {
+		fmt.Printf("* %s %s -> %s\n", resultKey(change.New), serviceText(change.Old), serviceText(change.New))
+	}
+}
+
+// 按指定格式导出差异，支持txt、json与xlsx
+func exportDiff(diff *ResultDiff, format string, filename string) error {
+	if format != formatJSON && format != formatExcel && format != formatText {
+		return fmt.Errorf("%w: 不支持的导出格式: %s", ErrInvalidArgument, format)
+	}
+	if err := writeDiff(diff, format, filename); err != nil {
+		return wrapError(ErrExportFailed, err)
+	}
+	return nil
+}
+
+func writeDiff(diff *ResultDiff, format string, filename string) error {
+	if err := ensureDir(filename); err != nil {
+		return err
+	}
+
+	switch format {
+	case formatJSON:
+		content, err := json.MarshalIndent(diff, "", "  ")
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filename, content, 0644)
+	case formatExcel:
+		return saveDiffToExcel(diff, filename)
+	case formatText:
+		var builder strings.Builder
+		for _, row := range diffRows(diff) {
+			var fields []string
+			for _, value := range row {
+				if text := fmt.Sprint(value); text != "" {
+					fields = append(fields, text)
+				}
+			}
+			fmt.Fprintln(&builder, strings.Join(fields, " "))
+		}
+		return os.WriteFile(filename, []byte(builder.String()), 0644)
+	}
+	return fmt.Errorf("不支持的导出格式: %s", format)
+}
+
+// 将差异展开为表格行：变化类型、IP、端口、协议、原服务、现服务
+func diffRows(diff *ResultDiff) [][]interface{} {
+	var rows [][]interface{}
+	for _, host := range diff.NewHosts {
+		rows = append(rows, []interface{}{changeNewHost, host, "", "", "", ""})
+	}
+	for _, host := range diff.GoneHosts {
+		rows = append(rows, []interface{}{changeGoneHost, host, "", "", "", ""})
+	}
+	for _, result := range diff.Opened {
+		rows = append(rows, []interface{}{changeOpened, result.IP, result.Port, resultProtocol(result), "", serviceText(result)})
+	}
+	for _, result := range diff.Closed {
+		rows = append(rows, []interface{}{changeClosed, result.IP, result.Port, resultProtocol(result), serviceText(result), ""})
+	}
+	for _, change := range diff.Changed {
+		rows = append(rows, []interface{}{changeService, change.New.IP, change.New.Port, resultProtocol(change.New), serviceText(change.Old), serviceText(change.New)})
+	}
+	return rows
+}
+
+// 差异导出到excel，不同的变化类型使用不同的底色突出显示
+func saveDiffToExcel(diff *ResultDiff, filename string) error {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	const sheet = "结果差异"
+	rows := diffRows(diff)
+	index, err := writeExcelSheet(f, sheet, []string{"变化类型", "IP", "端口", "协议", "原服务", "现服务"}, []float64{14, 18, 10, 8, 30, 30}, rows)
+	if err != nil {
+		return err
+	}
+	f.SetActiveSheet(index)
+	f.DeleteSheet("Sheet1")
+
+	fills := map[string]string{
+		changeNewHost:  "#C6EFCE",
+		changeGoneHost: "#FFC7CE",
+		changeOpened:   "#C6EFCE",
+		changeClosed:   "#FFC7CE",
+		changeService:  "#FFEB9C",
+	}
+	styles := make(map[string]int)
+	for change, fill := range fills {
+		styles[change], _ = f.NewStyle(&excelize.Style{
+			Fill:      excelize.Fill{Type: "pattern", Color: []string{fill}, Pattern: 1},
+			Alignment: &excelize.Alignment{Horizontal: "center", Vertical: "center"},
+		})
+	}
+	for i, row := range rows {
+		f.SetCellStyle(sheet, fmt.Sprintf("A%d", i+2), fmt.Sprintf("F%d", i+2), styles[row[0].(string)])
+	}
+
+	return f.SaveAs(filename)
+}
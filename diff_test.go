@@ -0,0 +1,92 @@
+package tools
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDiffReports(t *testing.T) {
+	ssh := ScanResult{IP: "10.0.0.1", Port: 22, Protocol: "tcp", Status: "open", Service: "ssh", Product: "OpenSSH", Version: "7.4"}
+	sshNew := ssh
+	sshNew.Version = "8.0"
+	web := ScanResult{IP: "10.0.0.1", Port: 80, Protocol: "tcp", Status: "open", Service: "http"}
+	dns := ScanResult{IP: "10.0.0.1", Port: 53, Protocol: "udp", Status: "open", Service: "domain"}
+	dnsTCP := ScanResult{IP: "10.0.0.1", Port: 53, Status: "open", Service: "domain"}
+	filtered := ScanResult{IP: "10.0.0.1", Port: 3306, Protocol: "tcp", Status: "filtered"}
+	gone := ScanResult{IP: "10.0.0.2", Port: 445, Protocol: "tcp", Status: "open", Service: "microsoft-ds"}
+	added := ScanResult{IP: "10.0.0.3", Port: 6379, Protocol: "tcp", Status: "open", Service: "redis"}
+
+	keys := func(results []ScanResult) string {
+		var list []string
+		for _, result := range results {
+			list = append(list, resultKey(result))
+		}
+		return fmt.Sprint(list)
+	}
+	changes := func(changed []ServiceChange) string {
+		var list []string
+		for _, change := range changed {
+			list = append(list, resultKey(change.New)+" "+serviceText(change.Old)+" -> "+serviceText(change.New))
+		}
+		return fmt.Sprint(list)
+	}
+
+	cases := []struct {
+		name    string
+		old     []ScanResult
+		new     []ScanResult
+		hosts   string // 新增主机 / 消失主机
+		opened  string
+		closed  string
+		changed string
+	}{
+		{"结果一致", []ScanResult{ssh, web}, []ScanResult{web, ssh}, "[] / []", "[]", "[]", "[]"},
+		{"新开放端口", []ScanResult{ssh}, []ScanResult{ssh, web}, "[] / []", "[10.0.0.1:80/tcp]", "[]", "[]"},
+		{"已关闭端口", []ScanResult{ssh, web}, []ScanResult{ssh}, "[] / []", "[]", "[10.0.0.1:80/tcp]", "[]"},
+		{"版本变化", []ScanResult{ssh}, []ScanResult{sshNew}, "[] / []", "[]", "[]", "[10.0.0.1:22/tcp ssh OpenSSH 7.4 -> ssh OpenSSH 8.0]"},
+		{"新增与消失主机", []ScanResult{ssh, gone}, []ScanResult{ssh, added}, "[10.0.0.3] / [10.0.0.2]", "[10.0.0.3:6379/tcp]", "[10.0.0.2:445/tcp]", "[]"},
+		{"tcp与udp分别对比", []ScanResult{dnsTCP}, []ScanResult{dns, dnsTCP}, "[] / []", "[10.0.0.1:53/udp]", "[]", "[]"},
+		{"非开放状态不计入", []ScanResult{ssh}, []ScanResult{ssh, filtered}, "[] / []", "[]", "[]", "[]"},
+		{"端口变为非开放视为关闭", []ScanResult{ssh, web}, []ScanResult{ssh, {IP: "10.0.0.1", Port: 80, Protocol: "tcp", Status: "closed"}}, "[] / []", "[]", "[10.0.0.1:80/tcp]", "[]"},
+		{"只剩非开放端口的主机视为消失", []ScanResult{gone}, []ScanResult{{IP: "10.0.0.2", Port: 445, Protocol: "tcp", Status: "filtered"}}, "[] / [10.0.0.2]", "[]", "[10.0.0.2:445/tcp]", "[]"},
+	}
+	for _, tc := range cases {
+		diff := diffReports(&ScanReport{Results: tc.old}, &ScanReport{Results: tc.new})
+		got := []string{
+			fmt.Sprint(diff.NewHosts) + " / " + fmt.Sprint(diff.GoneHosts),
+			keys(diff.Opened),
+			keys(diff.Closed),
+			changes(diff.Changed),
+		}
+		want := []string{tc.hosts, tc.opened, tc.closed, tc.changed}
+		for i, label := range []string{"主机变化", changeOpened, changeClosed, changeService} {
+			if got[i] != want[i] {
+				t.Errorf("%s: %s为 %s，期望 %s", tc.name, label, got[i], want[i])
+			}
+		}
+		if wantEmpty := tc.hosts == "[] / []" && tc.opened == "[]" && tc.closed == "[]" && tc.changed == "[]"; diff.Empty() != wantEmpty {
+			t.Errorf("%s: Empty() = %v", tc.name, diff.Empty())
+		}
+	}
+}
+
+// 同一端口只有产品变化（版本为空）时同样视为服务变化
+func TestDiffReportsProductChange(t *testing.T) {
+	oldReport := &ScanReport{Results: []ScanResult{
+		{IP: "10.0.0.1", Port: 80, Protocol: "tcp", Status: "open", Service: "http", Product: "nginx"},
+		{IP: "10.0.0.1", Port: 443, Protocol: "tcp", Status: "open", Service: "https", Product: "nginx", Version: "1.18.0"},
+	}}
+	newReport := &ScanReport{Results: []ScanResult{
+		{IP: "10.0.0.1", Port: 80, Protocol: "tcp", Status: "open", Service: "http", Product: "Apache httpd"},
+		{IP: "10.0.0.1", Port: 443, Protocol: "tcp", Status: "open", Service: "https", Product: "nginx", Version: "1.18.0"},
+	}}
+
+	diff := diffReports(oldReport, newReport)
+	if len(diff.Changed) != 1 {
+		t.Fatalf("服务变化为 %+v，期望只有80端口", diff.Changed)
+	}
+	change := diff.Changed[0]
+	if got, want := serviceText(change.Old)+" -> "+serviceText(change.New), "http nginx -> http Apache httpd"; got != want {
+		t.Fatalf("服务变化描述为 %q，期望 %q", got, want)
+	}
+}
@@ -0,0 +1,568 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+分布式扫描
+1、coordinator 解析目标与端口，按 ip×端口 的数量（-chunk-size）切分为分片，通过HTTP提供给worker领取，全部完成后合并为一份报告
+2、worker 从coordinator领取分片，进行端口扫描、服务识别与各项探测后提交结果；
+   扫描参数（线程、超时、速率、代理、源地址、服务识别与各项探测开关）取自worker自身的配置，目标、端口与标签取自coordinator
+3、worker扫描期间定期发送心跳，超过-lease-timeout未收到心跳的分片重新分配给其他worker，同一分片只保留最先提交的结果
+4、可通过-token指定共享口令，coordinator只接受携带相同口令的请求
+5、接口均为POST+json：/api/lease 领取分片（200 分片，204 暂无可领取的分片，410 任务已完成），
+   /api/heartbeat 心跳（409 分片已分配给其他worker），/api/result 提交结果
+*/
+
+const (
+	apiLease     = "/api/lease"
+	apiHeartbeat = "/api/heartbeat"
+	apiResult    = "/api/result"
+)
+
+// 暂无可领取的分片时worker重新领取的间隔，任务完成后coordinator保持响应该时间的两倍，使等待中的worker收到结束通知
+const leasePollInterval = 2 * time.Second
+
+// 单次提交结果的最大长度
+const maxResultSize = 512 << 20
+
+// 分片状态
+const (
+	chunkPending = iota
+	chunkLeased
+	chunkDone
+)
+
+// scanChunk 分配给worker的一个分片，分片中的ip扫描相同的端口
+type scanChunk struct {
+	ID    int       `json:"id"`
+	IPs   []string  `json:"ips"`
+	Ports *PortSpec `json:"ports"`
+}
+
+type leaseRequest struct {
+	Worker string `json:"worker"`
+}
+
+type leaseResponse struct {
+	Chunk        scanChunk     `json:"chunk"`
+	LeaseTimeout time.Duration `json:"lease_timeout"`
+}
+
+type heartbeatRequest struct {
+	Worker  string `json:"worker"`
+	ChunkID int    `json:"chunk_id"`
+}
+
+type resultRequest struct {
+	Worker  string       `json:"worker"`
+	ChunkID int          `json:"chunk_id"`
+	Results []ScanResult `json:"results"`
+	Error   string       `json:"error,omitempty"`
+}
+
+// 按 ip×端口 的数量切分目标，端口数不少于size时每个分片只包含一个ip的部分端口，UDP端口随该ip的第一个分片扫描
+func splitChunks(targets []Target, size int) []scanChunk {
+	var chunks []scanChunk
+	add := func(ips []string, spec *PortSpec) {
+		chunks = append(chunks, scanChunk{ID: len(chunks), IPs: ips, Ports: spec})
+	}
+
+	// 目标文件中未单独指定端口的目标共用同一端口规格
+	var specs []*PortSpec
+	groups := make(map[*PortSpec][]string)
+	for _, target := range targets {
+		if _, ok := groups[target.Ports]; !ok {
+			specs = append(specs, target.Ports)
+		}
+		groups[target.Ports] = append(groups[target.Ports], target.IP)
+	}
+
+	for _, spec := range specs {
+		ips := groups[spec]
+		count := len(spec.TCP) + len(spec.UDP)
+		if count >= size {
+			for _, ip := range ips {
+				for start := 0; start == 0 || start < len(spec.TCP); start += size {
+					part := &PortSpec{TCP: spec.TCP[start:min(start+size, len(spec.TCP))]}
+					if start == 0 {
+						part.UDP = spec.UDP
+					}
+					add([]string{ip}, part)
+				}
+			}
+			continue
+		}
+
+		perChunk := size / count
+		for start := 0; start < len(ips); start += perChunk {
+			add(ips[start:min(start+perChunk, len(ips))], spec)
+		}
+	}
+	return chunks
+}
+
+// 分片的分配情况
+type chunkState struct {
+	chunk    scanChunk
+	state    int
+	worker   string
+	deadline time.Time
+}
+
+// coordinator 记录分片的分配情况并汇总结果
+type coordinator struct {
+	mu           sync.Mutex
+	chunks       []chunkState
+	done         int
+	results      []ScanResult
+	errs         []error
+	workers      map[string]bool
+	leaseTimeout time.Duration
+	token        string
+	finished     chan struct{}
+}
+
+func newCoordinator(chunks []scanChunk, leaseTimeout time.Duration, token string) *coordinator {
+	c := &coordinator{
+		workers:      make(map[string]bool),
+		leaseTimeout: leaseTimeout,
+		token:        token,
+		finished:     make(chan struct{}),
+	}
+	for _, chunk := range chunks {
+		c.chunks = append(c.chunks, chunkState{chunk: chunk})
+	}
+	if len(chunks) == 0 {
+		close(c.finished)
+	}
+	return c
+}
+
+func (c *coordinator) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(apiLease, c.authorize(c.handleLease))
+	mux.HandleFunc(apiHeartbeat, c.authorize(c.handleHeartbeat))
+	mux.HandleFunc(apiResult, c.authorize(c.handleResult))
+	return mux
+}
+
+// 校验请求方法与口令
+func (c *coordinator) authorize(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "只支持POST请求", http.StatusMethodNotAllowed)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if c.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(c.token)) != 1 {
+			slog.Warn("拒绝口令错误的请求", "remote", r.RemoteAddr, "path", r.URL.Path)
+			http.Error(w, "口令错误", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (c *coordinator) handleLease(w http.ResponseWriter, r *http.Request) {
+	var req leaseRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.workers[req.Worker] {
+		c.workers[req.Worker] = true
+		slog.Info(fmt.Sprintf("worker %s 已连接（%s）", req.Worker, r.RemoteAddr))
+	}
+
+	if c.done == len(c.chunks) {
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+	c.reapExpired()
+	for i := range c.chunks {
+		s := &c.chunks[i]
+		if s.state != chunkPending {
+			continue
+		}
+		s.state, s.worker, s.deadline = chunkLeased, req.Worker, time.Now().Add(c.leaseTimeout)
+		slog.Debug("分配分片", "chunk", s.chunk.ID, "worker", req.Worker)
+		writeJSON(w, leaseResponse{Chunk: s.chunk, LeaseTimeout: c.leaseTimeout})
+		return
+	}
+
+	// 剩余分片均在扫描中，稍后再试，分配出去的分片超时后会重新分配
+	w.Header().Set("Retry-After", strconv.Itoa(int(leasePollInterval/time.Second)))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *coordinator) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	var req heartbeatRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if req.ChunkID < 0 || req.ChunkID >= len(c.chunks) {
+		http.Error(w, "分片不存在", http.StatusBadRequest)
+		return
+	}
+	s := &c.chunks[req.ChunkID]
+	if s.state != chunkLeased || s.worker != req.Worker {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	s.deadline = time.Now().Add(c.leaseTimeout)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (c *coordinator) handleResult(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxResultSize)
+	var req resultRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if req.ChunkID < 0 || req.ChunkID >= len(c.chunks) {
+		http.Error(w, "分片不存在", http.StatusBadRequest)
+		return
+	}
+	s := &c.chunks[req.ChunkID]
+	w.WriteHeader(http.StatusOK)
+
+	// 超时后重新分配的分片可能被多个worker完成，只保留最先提交的结果
+	if s.state == chunkDone {
+		slog.Debug("忽略重复提交的分片结果", "chunk", req.ChunkID, "worker", req.Worker)
+		return
+	}
+	s.state, s.worker = chunkDone, req.Worker
+	c.done++
+	c.results = append(c.results, req.Results...)
+	if req.Error != "" {
+		c.errs = append(c.errs, fmt.Errorf("分片%d（worker %s）: %s", req.ChunkID, req.Worker, req.Error))
+	}
+
+	slog.Info(fmt.Sprintf("分片 %d/%d 完成，worker %s，结果%d条", c.done, len(c.chunks), req.Worker, len(req.Results)))
+	if c.done == len(c.chunks) {
+		close(c.finished)
+	}
+}
+
+// 将超过租期未收到心跳的分片重新标记为待分配，调用时需持有锁
+func (c *coordinator) reapExpired() {
+	now := time.Now()
+	for i := range c.chunks {
+		s := &c.chunks[i]
+		if s.state == chunkLeased && now.After(s.deadline) {
+			slog.Warn(fmt.Sprintf("worker %s 超过%s未发送心跳，分片%d重新分配", s.worker, c.leaseTimeout, s.chunk.ID))
+			s.state, s.worker = chunkPending, ""
+		}
+	}
+}
+
+// 定期回收超时的分片，使其他worker在轮询时能够领取
+func (c *coordinator) reapLoop(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			c.mu.Lock()
+			c.reapExpired()
+			c.mu.Unlock()
+		}
+	}
+}
+
+func decodeRequest(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		http.Error(w, "请求格式错误: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func runCoordinator(args []string) error {
+	fs := newFlagSet("coordinator", "分布式扫描的协调端，将目标与端口切分为分片分发给worker，汇总全部结果后导出excel与json结果")
+	loadCfg := configFlags(fs, "coordinator")
+	listenInput := fs.String("listen", ":9900", "监听地址，worker通过该地址领取分片")
+	chunkSizeInput := fs.Int("chunk-size", 5000, "每个分片包含的 ip×端口 数量")
+	leaseTimeoutInput := fs.Duration("lease-timeout", time.Minute, "超过该时间未收到worker心跳时将分片重新分配给其他worker")
+	tokenInput := fs.String("token", "", "worker需要携带的共享口令，为空时不校验")
+	fs.Parse(args)
+
+	cfg, err := loadCfg()
+	if err != nil {
+		return err
+	}
+	if *chunkSizeInput <= 0 {
+		return fmt.Errorf("%w: 分片大小必须大于0: %d", ErrInvalidArgument, *chunkSizeInput)
+	}
+	if *leaseTimeoutInput <= 0 {
+		return fmt.Errorf("%w: 分片租期必须大于0: %s", ErrInvalidArgument, *leaseTimeoutInput)
+	}
+
+	startTime := time.Now()
+
+	portSpec, err := resolvePorts(cfg)
+	if err != nil {
+		return err
+	}
+	targets, err := loadTargets(cfg, portSpec)
+	if err != nil {
+		return err
+	}
+	if _, err := loadTagRules(); err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", *listenInput)
+	if err != nil {
+		return fmt.Errorf("监听%s失败: %w", *listenInput, err)
+	}
+
+	chunks := splitChunks(targets, *chunkSizeInput)
+	c := newCoordinator(chunks, *leaseTimeoutInput, *tokenInput)
+	server := &http.Server{Handler: c.handler()}
+	go server.Serve(listener)
+
+	stop := make(chan struct{})
+	go c.reapLoop(stop)
+
+	logSection("分布式扫描")
+	slog.Info(fmt.Sprintf("共%d个分片，在%s等待worker领取", len(chunks), listener.Addr()))
+	<-c.finished
+	close(stop)
+
+	// 保持响应片刻，使等待中的worker收到任务结束的通知
+	time.Sleep(2 * leasePollInterval)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	server.Shutdown(ctx)
+	cancel()
+
+	c.mu.Lock()
+	results, scanErr := c.results, errors.Join(c.errs...)
+	c.mu.Unlock()
+
+	if err := tagResults(results, targets, cfg); err != nil {
+		scanErr = errors.Join(scanErr, err)
+	}
+	report := &ScanReport{
+		StartTime: startTime,
+		Targets:   targetDescription(cfg),
+		Ports:     cfg.Ports,
+		Results:   results,
+	}
+	report.EndTime = time.Now()
+
+	if err := saveOutputs(report, cfg); err != nil {
+		return err
+	}
+	if err := recordRun("coordinator", report); err != nil {
+		return err
+	}
+	notifyScan(cfg, "coordinator", report, scanErr)
+
+	slog.Info(fmt.Sprintf("运行完毕，花费时间: %s", time.Since(startTime)))
+	if scanErr != nil {
+		return &PartialError{Err: scanErr}
+	}
+	return nil
+}
+
+// 与coordinator通信的客户端
+type workerClient struct {
+	base   string
+	name   string
+	token  string
+	client *http.Client
+}
+
+// 发送json请求，状态码为200且reply不为nil时解析响应
+func (w *workerClient) post(path string, body any, reply any) (*http.Response, error) {
+	content, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, w.base+path, bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.token != "" {
+		req.Header.Set("Authorization", "Bearer "+w.token)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		return resp, fmt.Errorf("%w: coordinator拒绝了请求，口令错误", ErrInvalidArgument)
+	case http.StatusBadRequest:
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return resp, fmt.Errorf("coordinator返回错误: %s", strings.TrimSpace(string(message)))
+	case http.StatusOK:
+		if reply != nil {
+			if err := json.NewDecoder(resp.Body).Decode(reply); err != nil {
+				return resp, fmt.Errorf("coordinator响应解析失败: %w", err)
+			}
+		}
+	}
+	return resp, nil
+}
+
+// 扫描期间定期发送心跳，直到stop关闭
+func (w *workerClient) heartbeat(chunkID int, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			resp, err := w.post(apiHeartbeat, heartbeatRequest{Worker: w.name, ChunkID: chunkID}, nil)
+			switch {
+			case err != nil:
+				slog.Warn(fmt.Sprintf("分片%d心跳发送失败: %v", chunkID, err))
+			case resp.StatusCode == http.StatusConflict:
+				slog.Warn(fmt.Sprintf("分片%d已重新分配给其他worker，本次结果可能被忽略", chunkID))
+			}
+		}
+	}
+}
+
+// 扫描一个分片并提交结果
+func (w *workerClient) runChunk(lease *leaseResponse, cfg *Config) error {
+	chunk := lease.Chunk
+	slog.Info(fmt.Sprintf("领取分片%d：%d个ip，%d个端口", chunk.ID, len(chunk.IPs), len(chunk.Ports.TCP)+len(chunk.Ports.UDP)))
+
+	interval := max(lease.LeaseTimeout/3, time.Second)
+	stop := make(chan struct{})
+	go w.heartbeat(chunk.ID, interval, stop)
+
+	targets := make([]Target, 0, len(chunk.IPs))
+	for _, ip := range chunk.IPs {
+		targets = append(targets, Target{IP: ip, Ports: chunk.Ports})
+	}
+	results, scanErr := scanTargets(context.Background(), targets, cfg)
+	close(stop)
+
+	req := resultRequest{Worker: w.name, ChunkID: chunk.ID, Results: results}
+	if scanErr != nil {
+		req.Error = scanErr.Error()
+	}
+	if _, err := w.post(apiResult, req, nil); err != nil {
+		return fmt.Errorf("分片%d结果提交失败: %w", chunk.ID, err)
+	}
+	return nil
+}
+
+func runWorker(args []string) error {
+	fs := newFlagSet("worker", "分布式扫描的工作端，从coordinator领取分片进行扫描并提交结果，coordinator的任务完成后退出")
+	loadCfg := configFlags(fs, "worker")
+	coordinatorInput := fs.String("coordinator", "", "coordinator地址，例如 http://10.1.1.10:9900")
+	nameInput := fs.String("name", "", "worker名称，默认为 主机名-进程号")
+	tokenInput := fs.String("token", "", "与coordinator相同的共享口令")
+	retryInput := fs.Duration("retry", time.Minute, "无法连接coordinator时持续重试的时间")
+	fs.Parse(args)
+
+	cfg, err := loadCfg()
+	if err != nil {
+		return err
+	}
+	if *coordinatorInput == "" {
+		return fmt.Errorf("%w: 未指定coordinator地址 可通过-h查看用法", ErrInvalidArgument)
+	}
+	base := strings.TrimSuffix(*coordinatorInput, "/")
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+
+	name := *nameInput
+	if name == "" {
+		hostname, _ := os.Hostname()
+		name = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+
+	// 端口库用于按排名排列端口
+	if _, err := defaultPortDB(); err != nil {
+		return err
+	}
+	if err := prepareProbes(cfg); err != nil {
+		return err
+	}
+
+	client := &workerClient{base: base, name: name, token: *tokenInput, client: &http.Client{Timeout: 30 * time.Second}}
+	slog.Info(fmt.Sprintf("worker %s 连接coordinator %s", name, base))
+	return client.run(cfg, *retryInput)
+}
+
+// 循环领取并扫描分片，直到coordinator的任务完成，retry为无法连接coordinator时持续重试的时间
+func (w *workerClient) run(cfg *Config, retry time.Duration) error {
+	completed := 0
+	lastContact := time.Now()
+	for {
+		var lease leaseResponse
+		resp, err := w.post(apiLease, leaseRequest{Worker: w.name}, &lease)
+		if errors.Is(err, ErrInvalidArgument) {
+			return err
+		}
+		if err != nil {
+			if time.Since(lastContact) > retry {
+				return fmt.Errorf("无法连接coordinator: %w", err)
+			}
+			slog.Warn(fmt.Sprintf("连接coordinator失败，稍后重试: %v", err))
+			time.Sleep(leasePollInterval)
+			continue
+		}
+		lastContact = time.Now()
+
+		switch resp.StatusCode {
+		case http.StatusGone:
+			slog.Info(fmt.Sprintf("扫描任务已完成，本worker共完成%d个分片", completed))
+			return nil
+		case http.StatusNoContent:
+			time.Sleep(leasePollInterval)
+		case http.StatusOK:
+			if err := w.runChunk(&lease, cfg); err != nil {
+				// 未提交的分片会在租期结束后重新分配
+				slog.Error(err.Error())
+				continue
+			}
+			completed++
+		default:
+			return fmt.Errorf("coordinator返回了未知的状态: %s", resp.Status)
+		}
+	}
+}
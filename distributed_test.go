@@ -0,0 +1,271 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func portRange(from int, to int) []int {
+	var ports []int
+	for port := from; port <= to; port++ {
+		ports = append(ports, port)
+	}
+	return ports
+}
+
+func TestSplitChunks(t *testing.T) {
+	shared := &PortSpec{TCP: portRange(1, 10)}
+	mixed := &PortSpec{TCP: portRange(1, 7), UDP: []int{53, 161}}
+	web := &PortSpec{TCP: []int{80, 443}}
+	ssh := &PortSpec{TCP: []int{22}}
+
+	cases := []struct {
+		name    string
+		targets []Target
+		size    int
+		want    []string // 每个分片的 ip 列表、TCP端口、UDP端口
+	}{
+		{
+			name: "端口少于分片大小时多个ip共用分片",
+			targets: []Target{{IP: "10.0.0.1", Ports: shared}, {IP: "10.0.0.2", Ports: shared}, {IP: "10.0.0.3", Ports: shared},
+				{IP: "10.0.0.4", Ports: shared}, {IP: "10.0.0.5", Ports: shared}},
+			size: 25,
+			want: []string{
+				"[10.0.0.1 10.0.0.2] [1 2 3 4 5 6 7 8 9 10] []",
+				"[10.0.0.3 10.0.0.4] [1 2 3 4 5 6 7 8 9 10] []",
+				"[10.0.0.5] [1 2 3 4 5 6 7 8 9 10] []",
+			},
+		},
+		{
+			name:    "端口数等于分片大小",
+			targets: []Target{{IP: "10.0.0.1", Ports: &PortSpec{TCP: portRange(1, 4)}}},
+			size:    4,
+			want:    []string{"[10.0.0.1] [1 2 3 4] []"},
+		},
+		{
+			name:    "端口数超过分片大小时按端口切分，UDP随第一个分片",
+			targets: []Target{{IP: "10.0.0.1", Ports: mixed}, {IP: "10.0.0.2", Ports: mixed}},
+			size:    3,
+			want: []string{
+				"[10.0.0.1] [1 2 3] [53 161]",
+				"[10.0.0.1] [4 5 6] []",
+				"[10.0.0.1] [7] []",
+				"[10.0.0.2] [1 2 3] [53 161]",
+				"[10.0.0.2] [4 5 6] []",
+				"[10.0.0.2] [7] []",
+			},
+		},
+		{
+			name:    "只有UDP端口",
+			targets: []Target{{IP: "10.0.0.1", Ports: &PortSpec{UDP: []int{53, 123, 161, 500}}}},
+			size:    2,
+			want:    []string{"[10.0.0.1] [] [53 123 161 500]"},
+		},
+		{
+			name:    "不同端口规格分别切分",
+			targets: []Target{{IP: "10.0.0.1", Ports: web}, {IP: "10.0.0.2", Ports: ssh}, {IP: "10.0.0.3", Ports: web}},
+			size:    10,
+			want:    []string{"[10.0.0.1 10.0.0.3] [80 443] []", "[10.0.0.2] [22] []"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			chunks := splitChunks(tc.targets, tc.size)
+			var got []string
+			for i, chunk := range chunks {
+				if chunk.ID != i {
+					t.Errorf("第%d个分片的ID为%d", i, chunk.ID)
+				}
+				got = append(got, fmt.Sprintf("%v %v %v", chunk.IPs, chunk.Ports.TCP, chunk.Ports.UDP))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+				t.Fatalf("分片为\n%v\n期望\n%v", got, tc.want)
+			}
+		})
+	}
+}
+
+// 只进行端口扫描的配置，worker不依赖nmap与各项探测
+func newTestWorkerConfig(t *testing.T) *Config {
+	oldOutput := textOutputPath
+	setTextOutput(filepath.Join(t.TempDir(), "result.txt"))
+	t.Cleanup(func() { textOutputPath = oldOutput })
+
+	cfg := defaultConfig()
+	cfg.Detector = detectorNone
+	cfg.Progress = false
+	cfg.Threads = 10
+	cfg.Timeout = time.Second
+	cfg.HTTPProbe, cfg.UnauthCheck, cfg.TLSInspect, cfg.VulnMatch, cfg.Brute = false, false, false, false, false
+	return cfg
+}
+
+func newTestCoordinator(t *testing.T, chunks []scanChunk, leaseTimeout time.Duration, token string) (*coordinator, *httptest.Server) {
+	c := newCoordinator(chunks, leaseTimeout, token)
+	server := httptest.NewServer(c.handler())
+	t.Cleanup(server.Close)
+	return c, server
+}
+
+func newTestWorker(server *httptest.Server, name string, token string) *workerClient {
+	return &workerClient{base: server.URL, name: name, token: token, client: server.Client()}
+}
+
+// 多个worker在本机领取分片，coordinator合并全部分片的结果
+func TestCoordinatorWorkers(t *testing.T) {
+	var openPorts []int
+	for i := 0; i < 3; i++ {
+		openPorts = append(openPorts, fakeTCPServer(t, func(conn net.Conn) {}))
+	}
+	closedPort := closedTarget(t)
+	_, closedText, _ := net.SplitHostPort(closedPort)
+	closed, _ := strconv.Atoi(closedText)
+
+	spec := &PortSpec{TCP: append(append([]int(nil), openPorts...), closed)}
+	sort.Ints(spec.TCP)
+	chunks := splitChunks([]Target{{IP: "127.0.0.1", Ports: spec}}, 1)
+	if len(chunks) != 4 {
+		t.Fatalf("分片数为%d，期望4", len(chunks))
+	}
+
+	c, server := newTestCoordinator(t, chunks, time.Minute, "secret")
+	cfg := newTestWorkerConfig(t)
+
+	const workers = 3
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		w := newTestWorker(server, fmt.Sprintf("worker-%d", i), "secret")
+		go func() { errs <- w.run(cfg, time.Second) }()
+	}
+	for i := 0; i < workers; i++ {
+		select {
+		case err := <-errs:
+			if err != nil {
+				t.Fatalf("worker退出: %v", err)
+			}
+		case <-time.After(20 * time.Second):
+			t.Fatal("worker未在任务完成后退出")
+		}
+	}
+
+	select {
+	case <-c.finished:
+	default:
+		t.Fatal("全部worker已退出，任务未完成")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.workers) != workers {
+		t.Errorf("连接过的worker为%d个，期望%d个", len(c.workers), workers)
+	}
+	var got []int
+	for _, result := range c.results {
+		got = append(got, result.Port)
+	}
+	sort.Ints(got)
+	sort.Ints(openPorts)
+	if fmt.Sprint(got) != fmt.Sprint(openPorts) {
+		t.Fatalf("合并后的开放端口为%v，期望%v", got, openPorts)
+	}
+}
+
+// worker超过租期未发送心跳时分片重新分配，之后迟到的结果被忽略
+func TestCoordinatorLeaseExpiry(t *testing.T) {
+	chunks := splitChunks([]Target{{IP: "10.0.0.1", Ports: &PortSpec{TCP: []int{80}}}}, 10)
+	c, server := newTestCoordinator(t, chunks, 100*time.Millisecond, "")
+	stale := newTestWorker(server, "stale", "")
+	fresh := newTestWorker(server, "fresh", "")
+
+	lease := func(w *workerClient) (int, int) {
+		t.Helper()
+		var reply leaseResponse
+		resp, err := w.post(apiLease, leaseRequest{Worker: w.name}, &reply)
+		if err != nil {
+			t.Fatalf("%s领取分片失败: %v", w.name, err)
+		}
+		return resp.StatusCode, reply.Chunk.ID
+	}
+	heartbeat := func(w *workerClient) int {
+		t.Helper()
+		resp, err := w.post(apiHeartbeat, heartbeatRequest{Worker: w.name, ChunkID: 0}, nil)
+		if err != nil {
+			t.Fatalf("%s发送心跳失败: %v", w.name, err)
+		}
+		return resp.StatusCode
+	}
+	submit := func(w *workerClient) {
+		t.Helper()
+		result := ScanResult{IP: "10.0.0.1", Port: 80, Protocol: "tcp", Status: "open", Service: w.name}
+		if _, err := w.post(apiResult, resultRequest{Worker: w.name, ChunkID: 0, Results: []ScanResult{result}}, nil); err != nil {
+			t.Fatalf("%s提交结果失败: %v", w.name, err)
+		}
+	}
+
+	if status, id := lease(stale); status != http.StatusOK || id != 0 {
+		t.Fatalf("stale领取分片: %d %d", status, id)
+	}
+	if status, _ := lease(fresh); status != http.StatusNoContent {
+		t.Fatalf("分片已分配时fresh领取分片的状态为%d，期望204", status)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if status, id := lease(fresh); status != http.StatusOK || id != 0 {
+		t.Fatalf("租期结束后fresh领取分片: %d %d，期望重新分配分片0", status, id)
+	}
+	if status := heartbeat(stale); status != http.StatusConflict {
+		t.Fatalf("分片重新分配后stale的心跳状态为%d，期望409", status)
+	}
+	if status := heartbeat(fresh); status != http.StatusOK {
+		t.Fatalf("fresh的心跳状态为%d，期望200", status)
+	}
+
+	submit(fresh)
+	submit(stale)
+	submit(fresh)
+
+	if status, _ := lease(stale); status != http.StatusGone {
+		t.Fatalf("任务完成后领取分片的状态为%d，期望410", status)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.done != 1 || len(c.results) != 1 || c.results[0].Service != "fresh" || c.chunks[0].worker != "fresh" {
+		t.Fatalf("重复提交未被忽略: done=%d results=%+v", c.done, c.results)
+	}
+}
+
+func TestCoordinatorToken(t *testing.T) {
+	chunks := splitChunks([]Target{{IP: "10.0.0.1", Ports: &PortSpec{TCP: []int{80}}}}, 10)
+	c, server := newTestCoordinator(t, chunks, time.Minute, "secret")
+
+	for _, token := range []string{"", "wrong"} {
+		resp, err := newTestWorker(server, "w", token).post(apiLease, leaseRequest{Worker: "w"}, nil)
+		if !errors.Is(err, ErrInvalidArgument) || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("口令为%q时: %v，期望401", token, err)
+		}
+	}
+	c.mu.Lock()
+	if c.chunks[0].state != chunkPending || len(c.workers) != 0 {
+		t.Fatal("口令错误的请求领取了分片")
+	}
+	c.mu.Unlock()
+
+	resp, err := http.Get(server.URL + apiLease)
+	if err != nil || resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("GET请求: %v %v，期望405", resp, err)
+	}
+	resp.Body.Close()
+
+	var lease leaseResponse
+	if resp, err := newTestWorker(server, "w", "secret").post(apiLease, leaseRequest{Worker: "w"}, &lease); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("口令正确时领取分片失败: %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+)
+
+/*
+错误类型与退出码
+1、各阶段返回包装后的错误，可通过errors.Is判断错误类别
+2、进程退出码：0 运行成功；1 运行失败，未能完成扫描或未能保存结果；
+   2 输入有误，目标、端口、参数或配置不合法，未开始扫描；
+   3 部分失败，结果已保存，但部分阶段出错（例如服务识别不可用时只保存了开放端口）
+*/
+
+const (
+	ExitOK      = 0
+	ExitFailure = 1
+	ExitUsage   = 2
+	ExitPartial = 3
+)
+
+var (
+	ErrInvalidTarget       = errors.New("目标参数有误")
+	ErrInvalidPortSpec     = errors.New("端口参数有误")
+	ErrInvalidArgument     = errors.New("参数有误")
+	ErrDetectorUnavailable = errors.New("服务识别不可用")
+	ErrExportFailed        = errors.New("结果导出失败")
+)
+
+// PartialError 表示扫描已完成并保存了结果，但部分阶段出错
+type PartialError struct {
+	Err error
+}
+
+func (e *PartialError) Error() string {
+	return "部分探测失败: " + e.Err.Error()
+}
+
+func (e *PartialError) Unwrap() error {
+	return e.Err
+}
+
+// 为错误附加类别，errors.Is可同时匹配类别与原始错误
+func wrapError(kind error, err error) error {
+	return fmt.Errorf("%w: %w", kind, err)
+}
+
+// 根据错误类别确定进程退出码
+func exitCode(err error) int {
+	var partial *PartialError
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, ErrInvalidTarget), errors.Is(err, ErrInvalidPortSpec), errors.Is(err, ErrInvalidArgument):
+		return ExitUsage
+	case errors.As(err, &partial):
+		return ExitPartial
+	default:
+		return ExitFailure
+	}
+}
@@ -0,0 +1,190 @@
+package tools
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ScanReport 保存到json文件中的完整扫描结果，report与diff命令均以此为输入
+type ScanReport struct {
+	StartTime time.Time    `json:"start_time"`
+	EndTime   time.Time    `json:"end_time"`
+	Targets   string       `json:"targets"`
+	Ports     string       `json:"ports"`
+	Results   []ScanResult `json:"results"`
+}
+
+// 支持的导出格式
+const (
+	formatJSON  = "json"
+	formatExcel = "xlsx"
+	formatCSV   = "csv"
+	formatText  = "txt"
+)
+
+// 根据文件扩展名判断导出格式
+func formatFromPath(path string) string {
+	return strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+}
+
+// 按指定格式导出扫描结果
+func exportReport(report *ScanReport, format string, filename string) error {
+	var err error
+	start := time.Now()
+	switch format {
+	case formatJSON:
+		err = saveToJSON(report, filename)
+	case formatExcel:
+		err = saveToExcel(report.Results, filename)
+	case formatCSV:
+		err = saveToCSV(report.Results, filename)
+	case formatText:
+		err = saveToText(report.Results, filename)
+	default:
+		return fmt.Errorf("%w: 不支持的导出格式: %s", ErrInvalidArgument, format)
+	}
+	metricExportDuration.since(start, format)
+	if err != nil {
+		return wrapError(ErrExportFailed, err)
+	}
+	return nil
+}
+
+// 创建文件所在目录（如果不存在）
+func ensureDir(filename string) error {
+	dir := filepath.Dir(filename)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("创建%s目录失败: %v", dir, err)
+	}
+	return nil
+}
+
+func saveToJSON(report *ScanReport, filename string) error {
+	if err := ensureDir(filename); err != nil {
+		return err
+	}
+
+	content, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, content, 0644)
+}
+
+// 读取结果文件，支持json与本工具导出的xlsx，json同时兼容只包含结果数组的文件，db:开头时读取数据库中的扫描记录
+func loadReport(filename string) (*ScanReport, error) {
+	if ref, ok := strings.CutPrefix(filename, dbRefPrefix); ok {
+		return loadStoredReport(ref)
+	}
+	if formatFromPath(filename) == formatExcel {
+		results, err := loadFromExcel(filename)
+		if err != nil {
+			return nil, fmt.Errorf("%w: 结果文件 %s 解析失败: %v", ErrInvalidArgument, filename, err)
+		}
+		return &ScanReport{Results: results}, nil
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("%w: 结果文件读取失败: %v", ErrInvalidArgument, err)
+	}
+
+	var report ScanReport
+	if err := json.Unmarshal(content, &report); err == nil {
+		return &report, nil
+	}
+
+	var results []ScanResult
+	if err := json.Unmarshal(content, &results); err != nil {
+		return nil, fmt.Errorf("%w: 结果文件 %s 解析失败: %v", ErrInvalidArgument, filename, err)
+	}
+	return &ScanReport{Results: results}, nil
+}
+
+func saveToCSV(results []ScanResult, filename string) error {
+	if err := ensureDir(filename); err != nil {
+		return err
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// 写入BOM，避免excel打开时中文乱码
+	file.WriteString("\xEF\xBB\xBF")
+
+	writer := csv.NewWriter(file)
+	// 与excel使用相同的列
+	headers := make([]string, 0, len(excelColumns))
+	for _, column := range excelColumns {
+		headers = append(headers, column.header)
+	}
+	writer.Write(headers)
+	for _, result := range results {
+		record := make([]string, 0, len(excelColumns))
+		for _, column := range excelColumns {
+			record = append(record, fmt.Sprint(column.value(result)))
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func saveToText(results []ScanResult, filename string) error {
+	if err := ensureDir(filename); err != nil {
+		return err
+	}
+
+	var builder strings.Builder
+	for _, result := range results {
+		fmt.Fprintln(&builder, resultLine(result))
+	}
+	return os.WriteFile(filename, []byte(builder.String()), 0644)
+}
+
+// 单条结果的文本形式
+func resultLine(result ScanResult) string {
+	line := fmt.Sprintf("%s:%d %s %s", result.IP, result.Port, result.Status, result.Service)
+	if result.Product != "" {
+		line += " " + result.Product
+	}
+	line += " " + result.Version
+	if len(result.Products) > 0 {
+		line += fmt.Sprintf(" [%s]", strings.Join(result.Products, ","))
+	}
+	if result.HTTP != nil {
+		line += fmt.Sprintf(" %s [%d] [%s]", result.HTTP.URL, result.HTTP.StatusCode, result.HTTP.Title)
+	}
+	if result.TLS != nil {
+		line += fmt.Sprintf(" [%s] [%s]", strings.Join(result.TLS.Versions, ","), result.TLS.Subject)
+	}
+	for _, finding := range result.Findings {
+		line += fmt.Sprintf(" [%s] %s", finding.Severity, finding.Title)
+	}
+	if len(result.Vulns) > 0 {
+		line += fmt.Sprintf(" [%s]", truncateList(vulnItems(result.Vulns), 5))
+	}
+	if len(result.Tags) > 0 {
+		line += fmt.Sprintf(" {%s}", strings.Join(result.Tags, ","))
+	}
+	return line
+}
+
+// 按ip和端口排序，保证导出结果稳定
+func sortResults(results []ScanResult) {
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].IP != results[j].IP {
+			return results[i].IP < results[j].IP
+		}
+		return results[i].Port < results[j].Port
+	})
+}
@@ -0,0 +1,387 @@
+package tools
+
+import (
+	"embed"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 内置的web指纹规则
+//
+//go:embed data/fingerprints/*.yaml
+var embeddedFingerprints embed.FS
+
+// FingerprintRule 一条web指纹规则，命中后将Name记录到结果的Products字段
+type FingerprintRule struct {
+	Name      string                `yaml:"name" json:"name"`
+	Path      string                `yaml:"path" json:"path"`           // 请求路径，默认使用HTTP探测得到的首页
+	Condition string                `yaml:"condition" json:"condition"` // 多个匹配条件之间的关系：and 或 or，默认or
+	Matchers  []FingerprintMatcher  `yaml:"matchers" json:"matchers"`
+	Version   *FingerprintExtractor `yaml:"version" json:"version"` // 可选，从响应中提取版本号
+}
+
+// FingerprintMatcher 匹配条件，Part可选 header、body、title、favicon、status
+type FingerprintMatcher struct {
+	Part   string   `yaml:"part" json:"part"`
+	Name   string   `yaml:"name" json:"name"`     // part为header时指定响应头名称，为空时匹配全部响应头
+	Words  []string `yaml:"words" json:"words"`   // 包含任意一个关键字即命中，不区分大小写
+	Regex  string   `yaml:"regex" json:"regex"`   // 正则表达式
+	Hashes []int32  `yaml:"hashes" json:"hashes"` // part为favicon时使用的mmh3哈希
+	Status []int    `yaml:"status" json:"status"` // part为status时使用的状态码
+
+	re *regexp.Regexp
+}
+
+// FingerprintExtractor 版本提取规则，使用正则表达式的第一个分组作为版本号
+type FingerprintExtractor struct {
+	Part  string `yaml:"part" json:"part"`
+	Name  string `yaml:"name" json:"name"`
+	Regex string `yaml:"regex" json:"regex"`
+
+	re *regexp.Regexp
+}
+
+// 规则文件结构，yaml解析器同样可以解析json格式的文件
+type fingerprintFile struct {
+	Rules []FingerprintRule `yaml:"rules" json:"rules"`
+}
+
+// 指纹匹配使用的页面内容
+type webPage struct {
+	status  int
+	headers http.Header
+	body    []byte
+	title   string
+	favicon int32
+}
+
+var (
+	fingerprintDirs  []string
+	fingerprintsOnce sync.Once
+	fingerprints     []FingerprintRule
+	fingerprintsErr  error
+)
+
+// 设置自定义指纹规则目录，多个目录以逗号分割，需要在首次使用指纹规则之前调用
+func setFingerprintDirs(dirs string) {
+	fingerprintDirs = nil
+	for _, dir := range strings.Split(dirs, ",") {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			fingerprintDirs = append(fingerprintDirs, dir)
+		}
+	}
+}
+
+// 用户默认的指纹规则目录
+func userFingerprintDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "miao-portscan", "fingerprints")
+}
+
+// 获取全部指纹规则，依次加载内置规则、用户默认目录和-fingerprint-dirs指定目录中的规则，同名规则后者覆盖前者
+func loadFingerprints() ([]FingerprintRule, error) {
+	fingerprintsOnce.Do(func() {
+		var rules []FingerprintRule
+		index := make(map[string]int)
+		add := func(file []FingerprintRule) {
+			for _, rule := range file {
+				key := strings.ToLower(rule.Name) + "\x00" + rule.Path
+				if i, ok := index[key]; ok {
+					rules[i] = rule
+					continue
+				}
+				index[key] = len(rules)
+				rules = append(rules, rule)
+			}
+		}
+
+		entries, _ := fs.Glob(embeddedFingerprints, "data/fingerprints/*.yaml")
+		for _, name := range entries {
+			content, _ := embeddedFingerprints.ReadFile(name)
+			file, err := parseFingerprints(content)
+			if err != nil {
+				fingerprintsErr = fmt.Errorf("内置指纹规则 %s 解析失败: %v", name, err)
+				return
+			}
+			add(file)
+		}
+
+		// 用户默认目录不存在时直接跳过
+		dirs := fingerprintDirs
+		if dir := userFingerprintDir(); dir != "" {
+			if _, err := os.Stat(dir); err == nil {
+				dirs = append([]string{dir}, dirs...)
+			}
+		}
+		for _, dir := range dirs {
+			files, err := os.ReadDir(dir)
+			if err != nil {
+				fingerprintsErr = fmt.Errorf("指纹规则目录读取失败: %v", err)
+				return
+			}
+			for _, entry := range files {
+				ext := strings.ToLower(filepath.Ext(entry.Name()))
+				if entry.IsDir() || (ext != ".yaml" && ext != ".yml" && ext != ".json") {
+					continue
+				}
+				path := filepath.Join(dir, entry.Name())
+				content, err := os.ReadFile(path)
+				if err != nil {
+					fingerprintsErr = fmt.Errorf("指纹规则文件读取失败: %v", err)
+					return
+				}
+				file, err := parseFingerprints(content)
+				if err != nil {
+					fingerprintsErr = fmt.Errorf("指纹规则文件 %s 解析失败: %v", path, err)
+					return
+				}
+				add(file)
+			}
+		}
+		fingerprints = rules
+	})
+	return fingerprints, fingerprintsErr
+}
+
+// 解析规则文件并编译其中的正则表达式
+func parseFingerprints(content []byte) ([]FingerprintRule, error) {
+	var file fingerprintFile
+	if err := yaml.Unmarshal(content, &file); err != nil {
+		return nil, err
+	}
+
+	for i := range file.Rules {
+		rule := &file.Rules[i]
+		rule.Name = strings.TrimSpace(rule.Name)
+		if rule.Name == "" || len(rule.Matchers) == 0 {
+			return nil, fmt.Errorf("第%d条规则缺少名称或匹配条件", i+1)
+		}
+		if rule.Path == "" {
+			rule.Path = "/"
+		}
+		if !strings.HasPrefix(rule.Path, "/") {
+			return nil, fmt.Errorf("规则 %s 的路径必须以/开头: %s", rule.Name, rule.Path)
+		}
+		rule.Condition = strings.ToLower(rule.Condition)
+		if rule.Condition == "" {
+			rule.Condition = "or"
+		}
+		if rule.Condition != "and" && rule.Condition != "or" {
+			return nil, fmt.Errorf("规则 %s 的condition只能为and或or: %s", rule.Name, rule.Condition)
+		}
+
+		for j := range rule.Matchers {
+			matcher := &rule.Matchers[j]
+			matcher.Part = strings.ToLower(matcher.Part)
+			switch matcher.Part {
+			case "header", "body", "title":
+				if len(matcher.Words) == 0 && matcher.Regex == "" {
+					return nil, fmt.Errorf("规则 %s 的%s匹配条件缺少words或regex", rule.Name, matcher.Part)
+				}
+			case "favicon":
+				if len(matcher.Hashes) == 0 {
+					return nil, fmt.Errorf("规则 %s 的favicon匹配条件缺少hashes", rule.Name)
+				}
+			case "status":
+				if len(matcher.Status) == 0 {
+					return nil, fmt.Errorf("规则 %s 的status匹配条件缺少status", rule.Name)
+				}
+			default:
+				return nil, fmt.Errorf("规则 %s 包含不支持的匹配位置: %s", rule.Name, matcher.Part)
+			}
+			if matcher.Regex != "" {
+				re, err := regexp.Compile(matcher.Regex)
+				if err != nil {
+					return nil, fmt.Errorf("规则 %s 的正则表达式错误: %v", rule.Name, err)
+				}
+				matcher.re = re
+			}
+		}
+
+		if rule.Version != nil {
+			rule.Version.Part = strings.ToLower(rule.Version.Part)
+			re, err := regexp.Compile(rule.Version.Regex)
+			if err != nil || re.NumSubexp() < 1 {
+				return nil, fmt.Errorf("规则 %s 的版本提取正则表达式错误，需要包含一个分组: %s", rule.Name, rule.Version.Regex)
+			}
+			rule.Version.re = re
+		}
+	}
+	return file.Rules, nil
+}
+
+// 对已完成HTTP探测的端口进行web指纹识别，结果写入每个ScanResult的Products字段
+func fingerprintResults(results []ScanResult, cfg *Config) {
+	rules, err := loadFingerprints()
+	if err != nil {
+		slog.Error(err.Error())
+		return
+	}
+
+	logSection("指纹识别")
+
+	client := newHTTPClient(cfg)
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, cfg.Threads)
+	for i := range results {
+		if results[i].HTTP == nil || results[i].HTTP.base == "" {
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(result *ScanResult) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			result.Products = matchFingerprints(client, result.HTTP, rules)
+			if len(result.Products) == 0 {
+				return
+			}
+
+			line := fmt.Sprintf("%s [%s]", result.HTTP.URL, strings.Join(result.Products, ","))
+			fmt.Println(line) // 原子性输出日志
+			fileWrite(line)
+		}(&results[i])
+	}
+	wg.Wait()
+}
+
+// 依次匹配全部规则，需要请求其他路径的规则只请求一次该路径
+func matchFingerprints(client *http.Client, info *HTTPInfo, rules []FingerprintRule) []string {
+	pages := map[string]*webPage{
+		"/": {status: info.StatusCode, headers: info.headers, body: info.body, title: info.Title, favicon: info.FaviconHash},
+	}
+
+	var products []string
+	seen := make(map[string]bool)
+	for _, rule := range rules {
+		page, ok := pages[rule.Path]
+		if !ok {
+			page = fetchPage(client, info.base+rule.Path)
+			pages[rule.Path] = page
+		}
+		if page == nil || seen[rule.Name] || !rule.match(page) {
+			continue
+		}
+
+		seen[rule.Name] = true
+		product := rule.Name
+		if version := rule.Version.extract(page); version != "" {
+			product += " " + version
+		}
+		products = append(products, product)
+	}
+	return products
+}
+
+// 请求指定地址用于指纹匹配，请求失败时返回nil
+func fetchPage(client *http.Client, target string) *webPage {
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return nil
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	body = decodeBody(body, resp.Header.Get("Content-Type"))
+	return &webPage{status: resp.StatusCode, headers: resp.Header, body: body, title: extractTitle(body)}
+}
+
+// 按condition组合全部匹配条件
+func (r *FingerprintRule) match(page *webPage) bool {
+	for _, matcher := range r.Matchers {
+		matched := matcher.match(page)
+		if r.Condition == "and" && !matched {
+			return false
+		}
+		if r.Condition == "or" && matched {
+			return true
+		}
+	}
+	return r.Condition == "and"
+}
+
+func (m *FingerprintMatcher) match(page *webPage) bool {
+	switch m.Part {
+	case "favicon":
+		for _, hash := range m.Hashes {
+			if page.favicon != 0 && page.favicon == hash {
+				return true
+			}
+		}
+		return false
+	case "status":
+		for _, status := range m.Status {
+			if page.status == status {
+				return true
+			}
+		}
+		return false
+	}
+
+	text := pagePart(page, m.Part, m.Name)
+	lower := strings.ToLower(text)
+	for _, word := range m.Words {
+		if strings.Contains(lower, strings.ToLower(word)) {
+			return true
+		}
+	}
+	return m.re != nil && m.re.MatchString(text)
+}
+
+// 提取版本号，规则未定义版本提取或未匹配时返回空字符串
+func (e *FingerprintExtractor) extract(page *webPage) string {
+	if e == nil {
+		return ""
+	}
+	match := e.re.FindStringSubmatch(pagePart(page, e.Part, e.Name))
+	if match == nil {
+		return ""
+	}
+	return strings.TrimSpace(match[1])
+}
+
+// 获取页面指定位置的文本，header未指定名称时返回全部响应头
+func pagePart(page *webPage, part string, name string) string {
+	switch part {
+	case "header":
+		if name != "" {
+			return strings.Join(page.headers.Values(name), "\n")
+		}
+		var builder strings.Builder
+		for key, values := range page.headers {
+			for _, value := range values {
+				builder.WriteString(key + ": " + value + "\n")
+			}
+		}
+		return builder.String()
+	case "body":
+		return string(page.body)
+	case "title":
+		return page.title
+	case "status":
+		return strconv.Itoa(page.status)
+	}
+	return ""
+}
@@ -0,0 +1,285 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/net/html/charset"
+	"html"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// HTTPInfo 端口的HTTP探测结果
+type HTTPInfo struct {
+	URL           string   `json:"url"`
+	StatusCode    int      `json:"status_code"`
+	Title         string   `json:"title"`
+	Server        string   `json:"server"`
+	PoweredBy     string   `json:"powered_by"`
+	ContentLength int64    `json:"content_length"`
+	FaviconHash   int32    `json:"favicon_hash"`
+	Redirects     []string `json:"redirects,omitempty"`
+
+	// 以下字段只在内存中保留，供指纹识别使用
+	base    string      // 探测使用的地址，例如 http://10.1.1.2:8080
+	headers http.Header // 最终响应的响应头
+	body    []byte      // 最终响应的响应体（已转换为utf-8）
+}
+
+// 响应体最大读取长度
+const maxBodySize = 1 << 20
+
+var (
+	titlePattern   = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	faviconPattern = regexp.MustCompile(`(?is)<link[^>]+rel=["']?[^"'>]*icon[^"'>]*["']?[^>]*>`)
+	hrefPattern    = regexp.MustCompile(`(?is)href=["']?([^"' >]+)`)
+)
+
+// 常见的https端口，优先使用https探测
+var httpsPorts = map[int]bool{443: true, 4443: true, 7443: true, 8443: true, 9443: true, 10443: true, 12443: true}
+
+// 对开放的TCP端口进行HTTP探测，结果写入每个ScanResult的HTTP字段
+func httpProbeResults(results []ScanResult, cfg *Config) {
+	logSection("HTTP探测")
+
+	client := newHTTPClient(cfg)
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, cfg.Threads)
+	for i := range results {
+		if results[i].Status != "open" || results[i].Protocol == "udp" {
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(result *ScanResult) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			info := probeHTTP(client, result.IP, result.Port)
+			if info == nil {
+				return
+			}
+			result.HTTP = info
+
+			line := fmt.Sprintf("%s [%d] [%s] [%s]", info.URL, info.StatusCode, info.Title, info.Server)
+			fmt.Println(line) // 原子性输出日志
+			fileWrite(line)
+		}(&results[i])
+	}
+	wg.Wait()
+}
+
+// 探测请求使用的User-Agent
+const userAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36"
+
+// 创建探测使用的http客户端，忽略证书校验并限制跳转次数
+func newHTTPClient(cfg *Config) *http.Client {
+	return &http.Client{
+		Timeout: cfg.HTTPTimeout,
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+			// 连接经由-proxy指定的代理
+			DialContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+				ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+				defer cancel()
+				return dialContext(ctx, network, addr)
+			},
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > cfg.HTTPMaxRedirects {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+	}
+}
+
+// 依次尝试http与https，返回第一个成功的结果，均失败时返回nil
+func probeHTTP(client *http.Client, ip string, port int) *HTTPInfo {
+	schemes := []string{"http", "https"}
+	if httpsPorts[port] {
+		schemes = []string{"https", "http"}
+	}
+
+	host := net.JoinHostPort(ip, strconv.Itoa(port))
+	for _, scheme := range schemes {
+		info, err := fetchHTTP(client, scheme+"://"+host+"/")
+		if err != nil {
+			slog.Debug("HTTP请求失败", "url", scheme+"://"+host+"/", "error", err)
+			continue
+		}
+		// 向https端口发送http请求时，部分服务器会返回400，此时继续尝试https
+		if scheme == "http" && info.StatusCode == http.StatusBadRequest && strings.Contains(strings.ToLower(info.Title), "https") {
+			if httpsInfo, err := fetchHTTP(client, "https://"+host+"/"); err == nil {
+				return httpsInfo
+			}
+		}
+		return info
+	}
+	return nil
+}
+
+// 请求指定地址并解析响应
+func fetchHTTP(client *http.Client, target string) (*HTTPInfo, error) {
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+
+	info := &HTTPInfo{
+		URL:           target,
+		StatusCode:    resp.StatusCode,
+		Server:        resp.Header.Get("Server"),
+		PoweredBy:     resp.Header.Get("X-Powered-By"),
+		ContentLength: resp.ContentLength,
+		base:          strings.TrimSuffix(target, "/"),
+		headers:       resp.Header,
+	}
+	info.body = decodeBody(body, resp.Header.Get("Content-Type"))
+	info.Title = extractTitle(info.body)
+	if info.ContentLength < 0 {
+		info.ContentLength = int64(len(body))
+	}
+
+	// 记录跳转链，最终地址作为结果的URL
+	if resp.Request != nil && resp.Request.URL.String() != target {
+		for r := resp.Request; r.Response != nil; r = r.Response.Request {
+			info.Redirects = append([]string{r.URL.String()}, info.Redirects...)
+		}
+		info.URL = resp.Request.URL.String()
+	}
+
+	info.FaviconHash = fetchFaviconHash(client, resp.Request.URL, body)
+	return info, nil
+}
+
+// 按响应头或页面中声明的编码将响应体转换为utf-8
+func decodeBody(body []byte, contentType string) []byte {
+	if reader, err := charset.NewReader(bytes.NewReader(body), contentType); err == nil {
+		if decoded, err := io.ReadAll(reader); err == nil {
+			return decoded
+		}
+	}
+	return body
+}
+
+// 提取页面标题
+func extractTitle(body []byte) string {
+	match := titlePattern.FindSubmatch(body)
+	if match == nil {
+		return ""
+	}
+	title := html.UnescapeString(string(match[1]))
+	return strings.Join(strings.Fields(title), " ")
+}
+
+// 获取favicon并计算与shodan一致的mmh3哈希，页面未声明图标时使用/favicon.ico
+func fetchFaviconHash(client *http.Client, base *url.URL, body []byte) int32 {
+	iconURL := base.ResolveReference(&url.URL{Path: "/favicon.ico"})
+	if link := faviconPattern.Find(body); link != nil {
+		if href := hrefPattern.FindSubmatch(link); href != nil {
+			if ref, err := url.Parse(html.UnescapeString(string(href[1]))); err == nil {
+				iconURL = base.ResolveReference(ref)
+			}
+		}
+	}
+
+	resp, err := client.Get(iconURL.String())
+	if err != nil {
+		return 0
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0
+	}
+	icon, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	if err != nil || len(icon) == 0 {
+		return 0
+	}
+	return faviconHash(icon)
+}
+
+// shodan的favicon哈希：对base64编码（每76个字符换行）后的内容计算mmh3
+func faviconHash(icon []byte) int32 {
+	encoded := base64.StdEncoding.EncodeToString(icon)
+
+	var builder strings.Builder
+	for i := 0; i < len(encoded); i += 76 {
+		end := i + 76
+		if end > len(encoded) {
+			end = len(encoded)
+		}
+		builder.WriteString(encoded[i:end])
+		builder.WriteByte('\n')
+	}
+	return int32(murmur3([]byte(builder.String()), 0))
+}
+
+// 32位murmur3哈希
+func murmur3(data []byte, seed uint32) uint32 {
+	const (
+		c1 = 0xcc9e2d51
+		c2 = 0x1b873593
+	)
+
+	h := seed
+	nblocks := len(data) / 4
+	for i := 0; i < nblocks; i++ {
+		k := uint32(data[i*4]) | uint32(data[i*4+1])<<8 | uint32(data[i*4+2])<<16 | uint32(data[i*4+3])<<24
+		k *= c1
+		k = k<<15 | k>>17
+		k *= c2
+
+		h ^= k
+		h = h<<13 | h>>19
+		h = h*5 + 0xe6546b64
+	}
+
+	var k uint32
+	tail := data[nblocks*4:]
+	switch len(tail) {
+	case 3:
+		k ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(tail[0])
+		k *= c1
+		k = k<<15 | k>>17
+		k *= c2
+		h ^= k
+	}
+
+	h ^= uint32(len(data))
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
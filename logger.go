@@ -0,0 +1,227 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"github.com/fatih/color"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+日志输出
+1、扫描结果（开放端口、服务、探测结果等）输出到stdout，便于重定向与管道处理
+2、阶段提示、警告、错误与调试信息通过log/slog输出到stderr：-q只输出警告与错误，-v输出调试信息，-vv额外输出每次连接的信息
+3、-log-file将日志以json格式追加写入文件，不受-q影响
+4、-no-color、NO_COLOR环境变量或输出不是终端时不使用颜色
+*/
+
+// 比Debug更详细的日志级别，记录每次连接等信息
+const levelTrace = slog.LevelDebug - 4
+
+// 阶段标题的日志属性，终端下以绿色单独成段显示
+const logKeySection = "section"
+
+var logLevels = map[string]slog.Level{
+	"trace": levelTrace,
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// 当前打开的json日志文件
+var logFile *os.File
+
+// 当前所处的扫描阶段，供serve模式查询任务进度
+var currentSection atomic.Value
+
+// 设置日志级别、颜色与json日志文件
+func setupLogging(cfg *Config) error {
+	level := logLevels[strings.ToLower(cfg.LogLevel)]
+
+	// fatih/color在stdout不是终端时已自动禁用颜色，这里只处理-no-color
+	if cfg.NoColor {
+		color.NoColor = true
+	}
+	colorize := !cfg.NoColor && isTerminal(os.Stderr) && os.Getenv("NO_COLOR") == ""
+
+	consoleLevel = level
+	var handler slog.Handler = &consoleHandler{level: level, colorize: colorize}
+	if cfg.LogFile != "" {
+		if err := ensureDir(cfg.LogFile); err != nil {
+			return err
+		}
+		file, err := os.OpenFile(cfg.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("日志文件打开失败: %v", err)
+		}
+		if logFile != nil {
+			logFile.Close()
+		}
+		logFile = file
+
+		jsonHandler := slog.NewJSONHandler(file, &slog.HandlerOptions{
+			Level: min(level, slog.LevelInfo),
+			ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+				if attr.Key == slog.LevelKey && attr.Value.Any() == levelTrace {
+					attr.Value = slog.StringValue("TRACE")
+				}
+				return attr
+			},
+		})
+		handler = multiHandler{handler, jsonHandler}
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// 输出阶段标题，同时写入文本结果文件
+func logSection(title string) {
+	currentSection.Store(title)
+	title += " --------------------"
+	slog.Info(title, logKeySection, true)
+	fileWrite(title)
+}
+
+// 终端日志的级别，-log-file的json日志不受-q影响，不能据此判断终端是否输出
+var consoleLevel = slog.LevelInfo
+
+// 判断日志级别是否会在终端输出，横幅与进度条等只在终端显示的内容据此判断
+func consoleEnabled(level slog.Level) bool {
+	return level >= consoleLevel
+}
+
+// 向stderr输出一行，扫描进度显示中时由进度条负责避让
+var stderrMutex sync.Mutex
+
+func writeStderr(line string) {
+	if p := activeProgress.Load(); p != nil {
+		p.printErr(line)
+		return
+	}
+	stderrMutex.Lock()
+	defer stderrMutex.Unlock()
+	fmt.Fprintln(os.Stderr, line)
+}
+
+// 终端日志格式：级别前缀 + 消息 + key=value
+type consoleHandler struct {
+	level    slog.Level
+	colorize bool
+	attrs    []slog.Attr
+	group    string
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *consoleHandler) Handle(_ context.Context, r slog.Record) error {
+	var builder strings.Builder
+	section := false
+	appendAttr := func(attr slog.Attr) bool {
+		if attr.Key == logKeySection {
+			section = true
+			return true
+		}
+		value := attr.Value.Resolve().String()
+		if value == "" || strings.ContainsAny(value, " \t\"=") {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(&builder, " %s=%s", attr.Key, value)
+		return true
+	}
+	for _, attr := range h.attrs {
+		appendAttr(attr)
+	}
+	r.Attrs(func(attr slog.Attr) bool {
+		if h.group != "" {
+			attr.Key = h.group + "." + attr.Key
+		}
+		return appendAttr(attr)
+	})
+
+	var line string
+	switch {
+	case section:
+		line = "\n" + h.paint(color.FgGreen, r.Message)
+	case r.Level >= slog.LevelError:
+		line = h.paint(color.FgRed, "[错误] "+r.Message)
+	case r.Level >= slog.LevelWarn:
+		line = h.paint(color.FgYellow, "[警告] "+r.Message)
+	case r.Level < slog.LevelInfo:
+		line = h.paint(color.FgHiBlack, "[调试] "+r.Message)
+	default:
+		line = r.Message
+	}
+	writeStderr(line + builder.String())
+	return nil
+}
+
+func (h *consoleHandler) paint(attr color.Attribute, text string) string {
+	if !h.colorize {
+		return text
+	}
+	c := color.New(attr)
+	c.EnableColor()
+	return c.Sprint(text)
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append(append([]slog.Attr{}, h.attrs...), attrs...)
+	return &clone
+}
+
+func (h *consoleHandler) WithGroup(name string) slog.Handler {
+	clone := *h
+	if clone.group != "" {
+		name = clone.group + "." + name
+	}
+	clone.group = name
+	return &clone
+}
+
+// 同时输出到多个handler
+type multiHandler []slog.Handler
+
+func (m multiHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range m {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m multiHandler) Handle(ctx context.Context, r slog.Record) error {
+	for _, h := range m {
+		if h.Enabled(ctx, r.Level) {
+			if err := h.Handle(ctx, r.Clone()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m multiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(multiHandler, 0, len(m))
+	for _, h := range m {
+		handlers = append(handlers, h.WithAttrs(attrs))
+	}
+	return handlers
+}
+
+func (m multiHandler) WithGroup(name string) slog.Handler {
+	handlers := make(multiHandler, 0, len(m))
+	for _, h := range m {
+		handlers = append(handlers, h.WithGroup(name))
+	}
+	return handlers
+}
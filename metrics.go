@@ -0,0 +1,255 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+/*
+运行指标
+1、-metrics 指定监听地址（例如 127.0.0.1:9101）后在 /metrics 以Prometheus文本格式输出扫描引擎的指标，
+   主要用于serve、monitor、coordinator、worker等长时间运行的模式
+2、端口扫描：发起的连接数、按open/closed/filtered/error统计的结果、连接失败的原因、连接耗时、工作协程数
+3、服务识别：nmap每次调用的耗时与失败次数；导出：各格式结果文件的导出耗时
+4、不依赖Prometheus的客户端库，指标在进程内累计，进程重启后清零
+*/
+
+// 直方图的默认分桶
+var (
+	latencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	nmapBuckets    = []float64{1, 5, 10, 30, 60, 120, 300, 600, 1200}
+	exportBuckets  = []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60}
+)
+
+// 指标类型
+const (
+	metricCounter   = "counter"
+	metricGauge     = "gauge"
+	metricHistogram = "histogram"
+)
+
+// metricFamily 同名指标，按标签值区分各个序列
+type metricFamily struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	collect func() float64 // 输出时计算的gauge
+
+	mu     sync.Mutex
+	series map[string]*metricSeries
+}
+
+// 一组标签值对应的序列
+type metricSeries struct {
+	labelValues []string
+	value       float64
+	counts      []uint64 // 直方图各分桶的计数（不累加）
+	sum         float64
+	count       uint64
+}
+
+// 全部已注册的指标，按注册顺序输出
+var metricFamilies []*metricFamily
+
+func newMetric(name string, kind string, help string, labels ...string) *metricFamily {
+	family := &metricFamily{name: name, help: help, kind: kind, labels: labels, series: make(map[string]*metricSeries)}
+	metricFamilies = append(metricFamilies, family)
+	return family
+}
+
+func newHistogram(name string, help string, buckets []float64, labels ...string) *metricFamily {
+	family := newMetric(name, metricHistogram, help, labels...)
+	family.buckets = buckets
+	return family
+}
+
+// 扫描引擎的指标
+var (
+	metricProbesSent   = newMetric("miao_probes_sent_total", metricCounter, "端口扫描发起的连接数")
+	metricProbeResults = newMetric("miao_probe_results_total", metricCounter,
+		"端口扫描的结果，result为open、closed（拒绝或复位）、filtered（超时或不可达）、error（其他错误）", "result")
+	metricDialErrors = newMetric("miao_dial_errors_total", metricCounter,
+		"端口扫描连接失败的原因：refused、reset、timeout、unreachable、proxy_refused、proxy_dropped、proxy、self_connect、other", "type")
+	metricConnectLatency = newHistogram("miao_connect_latency_seconds", "端口扫描单次连接的耗时", latencyBuckets, "result")
+	metricWorkersActive  = newMetric("miao_scan_workers_active", metricGauge, "端口扫描工作池中正在连接的协程数")
+	metricNmapDuration   = newHistogram("miao_nmap_duration_seconds", "单次调用nmap进行服务识别的耗时", nmapBuckets)
+	metricNmapFailures   = newMetric("miao_nmap_failures_total", metricCounter, "nmap服务识别失败的次数，reason为unavailable、timeout、error", "reason")
+	metricExportDuration = newHistogram("miao_export_duration_seconds", "结果文件的导出耗时", exportBuckets, "format")
+	metricGoroutines     = newMetric("miao_goroutines", metricGauge, "当前的协程总数")
+)
+
+func init() {
+	metricGoroutines.collect = func() float64 { return float64(runtime.NumGoroutine()) }
+}
+
+// 查找或创建标签值对应的序列，调用时需持有锁
+func (f *metricFamily) get(labelValues []string) *metricSeries {
+	key := strings.Join(labelValues, "\xff")
+	series, ok := f.series[key]
+	if !ok {
+		series = &metricSeries{labelValues: labelValues}
+		if f.kind == metricHistogram {
+			series.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = series
+	}
+	return series
+}
+
+// 计数器与gauge增加v，gauge可为负数
+func (f *metricFamily) add(v float64, labelValues ...string) {
+	f.mu.Lock()
+	f.get(labelValues).value += v
+	f.mu.Unlock()
+}
+
+// 直方图记录一次观测值
+func (f *metricFamily) observe(v float64, labelValues ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	series := f.get(labelValues)
+	if i := sort.SearchFloat64s(f.buckets, v); i < len(f.buckets) {
+		series.counts[i]++
+	}
+	series.sum += v
+	series.count++
+}
+
+// 直方图记录从start开始的耗时
+func (f *metricFamily) since(start time.Time, labelValues ...string) {
+	f.observe(time.Since(start).Seconds(), labelValues...)
+}
+
+// 标签的文本形式，extra为直方图的le标签
+func (f *metricFamily) labelText(labelValues []string, extra string) string {
+	var pairs []string
+	for i, name := range f.labels {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", name, labelValues[i]))
+	}
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// 以Prometheus文本格式输出
+func (f *metricFamily) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+	if f.collect != nil {
+		fmt.Fprintf(w, "%s %s\n", f.name, formatFloat(f.collect()))
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	// 没有标签的计数器在未发生时同样输出0
+	if len(keys) == 0 && len(f.labels) == 0 {
+		f.get(nil)
+		keys = append(keys, "")
+	}
+
+	for _, key := range keys {
+		series := f.series[key]
+		if f.kind != metricHistogram {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, f.labelText(series.labelValues, ""), formatFloat(series.value))
+			continue
+		}
+		var cumulative uint64
+		for i, bound := range f.buckets {
+			cumulative += series.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, f.labelText(series.labelValues, fmt.Sprintf("le=%q", formatFloat(bound))), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, f.labelText(series.labelValues, `le="+Inf"`), series.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, f.labelText(series.labelValues, ""), formatFloat(series.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, f.labelText(series.labelValues, ""), series.count)
+	}
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, family := range metricFamilies {
+		family.write(w)
+	}
+}
+
+// 在指定地址启动指标服务，地址为空时不启动
+func startMetricsServer(addr string) error {
+	if addr == "" {
+		return nil
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("指标服务监听%s失败: %w", addr, err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", metricsHandler)
+	go http.Serve(listener, mux)
+	slog.Info(fmt.Sprintf("指标服务已启动：http://%s/metrics", listener.Addr()))
+	return nil
+}
+
+// 端口扫描连接失败的原因与对应的结果
+func classifyDialError(err error) (result string, errType string) {
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return "open", ""
+	case errors.Is(err, errProxyRefused):
+		return "closed", "proxy_refused"
+	case errors.Is(err, errProxyDropped):
+		return "closed", "proxy_dropped"
+	case errors.Is(err, errSelfConnect):
+		return "closed", "self_connect"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "closed", "refused"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "closed", "reset"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "filtered", "timeout"
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return "filtered", "unreachable"
+	case proxyEnabled():
+		return "error", "proxy"
+	}
+	return "error", "other"
+}
+
+// 记录一次端口扫描连接的结果
+func recordProbe(start time.Time, err error) {
+	result, errType := classifyDialError(err)
+	metricProbeResults.add(1, result)
+	metricConnectLatency.since(start, result)
+	if errType != "" {
+		metricDialErrors.add(1, errType)
+	}
+}
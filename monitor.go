@@ -0,0 +1,426 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+/*
+持续监控（monitor子命令）
+1、按-schedule指定的计划反复扫描-ip或-l指定的范围，目标文件每次扫描前重新读取
+2、计划为5段cron表达式（分 时 日 月 周），支持 * 、数字、a-b、a,b 以及步长a-b/n（a-b可为*），周日为0或7；
+   也可使用 @hourly、@daily、@weekly、@monthly 或 @every 30m
+3、每次扫描与上一次对比，按-alert将变化发送到各告警输出；首次扫描只建立基线不告警
+4、上一次的结果保存在-state文件中，重启后继续与其对比；部分ip服务识别失败时仍对比开放端口，
+   这些ip的服务信息与UDP端口沿用基线，避免误报服务变化；启动时nmap不可用则直接退出
+5、指定-db时每次扫描同时记录到数据库
+*/
+
+// schedule 计算下一次执行时间
+type schedule interface {
+	next(after time.Time) time.Time
+}
+
+// 固定间隔执行
+type everySchedule struct {
+	interval time.Duration
+}
+
+func (s everySchedule) next(after time.Time) time.Time {
+	return after.Add(s.interval)
+}
+
+// cron表达式，各字段为允许取值的位图
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool // 日或周以*开头（含*/n），与cron一致：均不以*开头时满足其一即可
+}
+
+// cron字段的取值范围
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"分", 0, 59},
+	{"时", 0, 23},
+	{"日", 1, 31},
+	{"月", 1, 12},
+	{"周", 0, 7},
+}
+
+// 解析执行计划
+func parseSchedule(spec string) (schedule, error) {
+	spec = strings.TrimSpace(spec)
+	switch spec {
+	case "@hourly":
+		spec = "0 * * * *"
+	case "@daily", "@midnight":
+		spec = "0 0 * * *"
+	case "@weekly":
+		spec = "0 0 * * 0"
+	case "@monthly":
+		spec = "0 0 1 * *"
+	}
+	if value, ok := strings.CutPrefix(spec, "@every "); ok {
+		interval, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || interval < time.Minute {
+			return nil, fmt.Errorf("执行间隔不合法: %s，至少为1m", value)
+		}
+		return everySchedule{interval: interval}, nil
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("执行计划不合法: %q，应为 分 时 日 月 周 5个字段或@every <间隔>", spec)
+	}
+	var bits [5]uint64
+	for i, field := range fields {
+		var err error
+		if bits[i], err = parseCronField(field, cronFields[i].min, cronFields[i].max); err != nil {
+			return nil, fmt.Errorf("执行计划的%s字段不合法: %v", cronFields[i].name, err)
+		}
+	}
+	// 周日可写为0或7
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+
+	s := &cronSchedule{minute: bits[0], hour: bits[1], dom: bits[2], month: bits[3], dow: bits[4],
+		domAny: strings.HasPrefix(fields[2], "*"), dowAny: strings.HasPrefix(fields[4], "*")}
+	if s.next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("执行计划 %q 不会被触发", spec)
+	}
+	return s, nil
+}
+
+// 解析cron的一个字段
+func parseCronField(field string, min int, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangeText, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step <= 0 {
+				return 0, fmt.Errorf("步长不合法: %s", part)
+			}
+		}
+
+		start, end := min, max
+		if rangeText != "*" {
+			startText, endText, isRange := strings.Cut(rangeText, "-")
+			var err error
+			if start, err = strconv.Atoi(startText); err != nil {
+				return 0, fmt.Errorf("取值不合法: %s", part)
+			}
+			end = start
+			if isRange {
+				if end, err = strconv.Atoi(endText); err != nil {
+					return 0, fmt.Errorf("取值不合法: %s", part)
+				}
+			} else if hasStep {
+				end = max
+			}
+		}
+		if start < min || end > max || start > end {
+			return 0, fmt.Errorf("取值超出范围%d-%d: %s", min, max, part)
+		}
+		for value := start; value <= end; value += step {
+			bits |= 1 << value
+		}
+	}
+	return bits, nil
+}
+
+func (s *cronSchedule) matchDay(t time.Time) bool {
+	domMatch := s.dom&(1<<t.Day()) != 0
+	dowMatch := s.dow&(1<<int(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// 下一次执行时间，5年内不会触发时返回零值
+func (s *cronSchedule) next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// monitorState 持久化的监控状态
+type monitorState struct {
+	Runs   int         `json:"runs"`
+	Report *ScanReport `json:"report"` // 上一次完整扫描的结果，作为对比基线
+}
+
+// 读取监控状态，文件不存在时返回空状态
+func loadMonitorState(path string) (*monitorState, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &monitorState{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("监控状态文件读取失败: %v", err)
+	}
+	var state monitorState
+	if err := json.Unmarshal(content, &state); err != nil {
+		return nil, fmt.Errorf("%w: 监控状态文件 %s 解析失败: %v", ErrInvalidArgument, path, err)
+	}
+	return &state, nil
+}
+
+// 保存监控状态，先写临时文件再替换，避免中断时损坏
+func saveMonitorState(path string, state *monitorState) error {
+	if err := ensureDir(path); err != nil {
+		return err
+	}
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// monitor 监控任务
+type monitor struct {
+	cfg    *Config
+	state  *monitorState
+	path   string
+	sinks  []alertSink
+	closed bool
+}
+
+// 执行一次扫描并与基线对比，ctx取消时返回ctx的错误
+func (m *monitor) runOnce(ctx context.Context) error {
+	run := m.state.Runs + 1
+	slog.Info(fmt.Sprintf("开始第%d次监控扫描", run))
+	startTime := time.Now()
+
+	// 目标文件与端口配置每次重新读取，修改范围无需重启
+	portSpec, err := resolvePorts(m.cfg)
+	if err != nil {
+		return err
+	}
+	targets, err := loadTargets(m.cfg, portSpec)
+	if err != nil {
+		return err
+	}
+
+	results, scanErr := scanTargets(ctx, targets, m.cfg)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	report := &ScanReport{
+		StartTime: startTime,
+		EndTime:   time.Now(),
+		Targets:   targetDescription(m.cfg),
+		Ports:     m.cfg.Ports,
+		Results:   results,
+	}
+	sortResults(report.Results)
+	if err := recordRun("monitor", report); err != nil {
+		slog.Error(err.Error())
+	}
+	if scanErr != nil {
+		var baseline []ScanResult
+		if m.state.Report != nil {
+			baseline = m.state.Report.Results
+		}
+		failed, ok := detectFailedHosts(scanErr, report.Results, baseline)
+		if !ok {
+			slog.Error(fmt.Sprintf("第%d次监控扫描部分失败，不与上一次对比: %v", run, scanErr))
+			return nil
+		}
+		slog.Warn(fmt.Sprintf("第%d次监控扫描中%d个ip服务识别失败，这些ip只对比开放端口: %v", run, len(failed), scanErr))
+		if m.state.Report != nil {
+			keepBaselineServices(m.state.Report, report, failed)
+		}
+	}
+
+	if m.state.Report == nil {
+		slog.Info(fmt.Sprintf("首次扫描，已建立基线：%d个开放端口", len(report.Results)))
+	} else {
+		diff := diffReports(m.state.Report, report)
+		// 基线中未识别出服务的端口（例如当时服务识别失败），本次识别出服务不视为服务变化
+		diff.Changed = slices.DeleteFunc(diff.Changed, func(change ServiceChange) bool {
+			return change.Old.Service == "" && change.Old.Product == "" && change.Old.Version == ""
+		})
+		alerts := diffAlerts(diff, run, m.closed)
+		slog.Info(fmt.Sprintf("第%d次监控扫描完成，%d个开放端口，%d条告警", run, len(report.Results), len(alerts)))
+		m.sendAlerts(alerts)
+	}
+
+	m.state.Runs, m.state.Report = run, report
+	if err := saveMonitorState(m.path, m.state); err != nil {
+		slog.Error(fmt.Sprintf("监控状态保存失败: %v", err))
+	}
+	return nil
+}
+
+// 服务识别失败的ip，nmap不可用时本次与基线中的全部ip均视为失败；包含其他错误时ok为false
+func detectFailedHosts(err error, results []ScanResult, baseline []ScanResult) (failed map[string]bool, ok bool) {
+	failed = make(map[string]bool)
+	if errors.Is(err, ErrDetectorUnavailable) {
+		for _, result := range results {
+			failed[result.IP] = true
+		}
+		for _, result := range baseline {
+			failed[result.IP] = true
+		}
+		return failed, true
+	}
+
+	errs := []error{err}
+	if joined, isJoined := err.(interface{ Unwrap() []error }); isJoined {
+		errs = joined.Unwrap()
+	}
+	for _, err := range errs {
+		var hostErr *hostError
+		if !errors.As(err, &hostErr) {
+			return nil, false
+		}
+		failed[hostErr.IP] = true
+	}
+	return failed, true
+}
+
+// 服务识别失败的ip只有开放端口，仍开放的端口沿用基线中的服务信息；
+// UDP端口只能由nmap探测，识别失败时无法得知其状态，同样沿用基线
+func keepBaselineServices(baseline *ScanReport, report *ScanReport, failed map[string]bool) {
+	oldMap, _ := indexResults(baseline.Results)
+	seen := make(map[string]bool)
+	for i, result := range report.Results {
+		key := resultKey(result)
+		seen[key] = true
+		if old, ok := oldMap[key]; ok && failed[result.IP] {
+			report.Results[i] = old
+		}
+	}
+	for key, old := range oldMap {
+		if failed[old.IP] && resultProtocol(old) == "udp" && !seen[key] {
+			report.Results = append(report.Results, old)
+		}
+	}
+	sortResults(report.Results)
+}
+
+// 将告警发送到各告警输出，失败时只记录错误
+func (m *monitor) sendAlerts(alerts []monitorAlert) {
+	if len(alerts) == 0 {
+		return
+	}
+	for _, sink := range m.sinks {
+		if err := sink.send(alerts); err != nil {
+			slog.Error(fmt.Sprintf("告警发送到%s失败: %v", sink.name(), err))
+		}
+	}
+}
+
+func runMonitor(args []string) error {
+	fs := newFlagSet("monitor", "按计划反复扫描指定范围，与上一次结果对比，新开放端口或服务变化时发出告警")
+	loadCfg := configFlags(fs, "monitor")
+	scheduleInput := fs.String("schedule", "@every 1h", "执行计划，cron表达式（分 时 日 月 周），例如 \"0 */6 * * *\"，或 @hourly、@daily、@every 30m")
+	alertInput := fs.String("alert", "stdout", "告警输出，多个以逗号分割：stdout、file:<路径>、webhook:<url>、syslog、syslog:udp://host:514")
+	alertClosedInput := fs.Bool("alert-closed", false, "端口关闭与主机消失时同样告警")
+	stateInput := fs.String("state", "monitor-state.json", "保存上一次扫描结果的状态文件，重启后继续与其对比")
+	immediateInput := fs.Bool("immediate", true, "启动后立即扫描一次，否则等到计划的执行时间")
+	fs.Parse(args)
+
+	cfg, err := loadCfg()
+	if err != nil {
+		return err
+	}
+	sched, err := parseSchedule(*scheduleInput)
+	if err != nil {
+		return wrapError(ErrInvalidArgument, err)
+	}
+	sinks, err := parseAlertSinks(*alertInput)
+	if err != nil {
+		return err
+	}
+	if len(sinks) == 0 {
+		return fmt.Errorf("%w: 未指定告警输出", ErrInvalidArgument)
+	}
+
+	// 启动前校验范围、端口与探测配置，之后每次扫描重新读取
+	portSpec, err := resolvePorts(cfg)
+	if err != nil {
+		return err
+	}
+	if _, err := loadTargets(cfg, portSpec); err != nil {
+		return err
+	}
+	if err := prepareProbes(cfg); err != nil {
+		return err
+	}
+	if err := checkDetector(cfg); err != nil {
+		return err
+	}
+
+	state, err := loadMonitorState(*stateInput)
+	if err != nil {
+		return err
+	}
+	if state.Report != nil {
+		slog.Info(fmt.Sprintf("已读取监控状态%s，与第%d次扫描的结果继续对比", *stateInput, state.Runs))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	m := &monitor{cfg: cfg, state: state, path: *stateInput, sinks: sinks, closed: *alertClosedInput}
+	next := time.Now()
+	if !*immediateInput {
+		next = sched.next(next)
+	}
+	for {
+		if wait := time.Until(next); wait > 0 {
+			slog.Info(fmt.Sprintf("下一次扫描时间: %s", next.Format("2006-01-02 15:04:05")))
+			select {
+			case <-ctx.Done():
+				slog.Info("监控已停止")
+				return nil
+			case <-time.After(wait):
+			}
+		}
+
+		if err := m.runOnce(ctx); err != nil {
+			if ctx.Err() != nil {
+				slog.Info("监控已停止，本次扫描结果未保存")
+				return nil
+			}
+			// 目标文件或端口配置被改错时等待下一次扫描，不退出
+			slog.Error(fmt.Sprintf("监控扫描失败: %v", err))
+		}
+		next = sched.next(time.Now())
+	}
+}
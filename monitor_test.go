@@ -0,0 +1,127 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	at := func(value string) time.Time {
+		parsed, err := time.Parse("2006-01-02 15:04:05", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	// 2026-10-19 为周一
+	cases := []struct {
+		name  string
+		spec  string
+		after string
+		want  string
+	}{
+		{"分钟步长", "*/15 * * * *", "2026-10-19 10:07:30", "2026-10-19 10:15:00"},
+		{"小时步长", "0 */6 * * *", "2026-10-19 10:07:30", "2026-10-19 12:00:00"},
+		{"区间步长", "10-30/10 * * * *", "2026-10-19 10:07:30", "2026-10-19 10:10:00"},
+		{"区间步长跨小时", "10-30/10 * * * *", "2026-10-19 10:31:00", "2026-10-19 11:10:00"},
+		{"恰好在执行时间时取下一次", "5 * * * *", "2026-10-19 10:05:00", "2026-10-19 11:05:00"},
+		{"工作日跨周末", "30 9 * * 1-5", "2026-10-16 10:00:00", "2026-10-19 09:30:00"},
+		{"周日写为7", "0 0 * * 7", "2026-10-19 10:07:30", "2026-10-25 00:00:00"},
+		{"周日写为0", "0 0 * * 0", "2026-10-19 10:07:30", "2026-10-25 00:00:00"},
+		{"周步长", "0 0 * * */2", "2026-10-19 10:07:30", "2026-10-20 00:00:00"},
+		{"日与周均指定时满足其一", "0 0 1,15 * 1", "2026-10-27 00:00:00", "2026-11-01 00:00:00"},
+		{"日与周均指定时满足其一周先到", "0 0 13 * 5", "2026-10-19 10:07:30", "2026-10-23 00:00:00"},
+		{"日以*开头时需同时满足", "0 0 */2 * 1", "2026-10-19 10:07:30", "2026-11-09 00:00:00"},
+		{"周以*开头时需同时满足", "0 0 1-7 * */7", "2026-10-19 10:07:30", "2026-11-01 00:00:00"},
+		{"月末跳过较短的月份", "0 0 31 * *", "2026-11-01 00:00:00", "2026-12-31 00:00:00"},
+		{"月份步长", "0 12 1 */3 *", "2026-10-19 10:07:30", "2027-01-01 12:00:00"},
+		{"闰日", "0 0 29 2 *", "2026-10-19 10:07:30", "2028-02-29 00:00:00"},
+		{"每周", "@weekly", "2026-10-19 10:07:30", "2026-10-25 00:00:00"},
+		{"固定间隔", "@every 90m", "2026-10-19 10:07:30", "2026-10-19 11:37:30"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			sched, err := parseSchedule(tc.spec)
+			if err != nil {
+				t.Fatalf("解析 %q 失败: %v", tc.spec, err)
+			}
+			if got := sched.next(at(tc.after)); !got.Equal(at(tc.want)) {
+				t.Fatalf("%q 在 %s 之后的执行时间为 %s，期望 %s", tc.spec, tc.after, got.Format(time.DateTime), tc.want)
+			}
+		})
+	}
+
+	for _, spec := range []string{"60 * * * *", "* * * *", "*/0 * * * *", "5-1 * * * *", "a * * * *", "0 0 30 2 *", "@every 30s"} {
+		if _, err := parseSchedule(spec); err == nil {
+			t.Errorf("%q 应解析失败", spec)
+		}
+	}
+}
+
+func TestDetectFailedHosts(t *testing.T) {
+	results := []ScanResult{{IP: "10.0.0.1", Port: 22}, {IP: "10.0.0.2", Port: 80}}
+	baseline := []ScanResult{{IP: "10.0.0.3", Port: 443}}
+	timeout := errors.New("context deadline exceeded")
+
+	cases := []struct {
+		name string
+		err  error
+		want string // 失败的ip，ok为false时为空
+		ok   bool
+	}{
+		{"单个ip失败", &hostError{IP: "10.0.0.1", Err: timeout}, "map[10.0.0.1:true]", true},
+		{"多个ip失败", errors.Join(&hostError{IP: "10.0.0.1", Err: timeout}, &hostError{IP: "10.0.0.2", Err: timeout}),
+			"map[10.0.0.1:true 10.0.0.2:true]", true},
+		{"nmap不可用", errors.Join(&hostError{IP: "10.0.0.1", Err: wrapError(ErrDetectorUnavailable, timeout)}),
+			"map[10.0.0.1:true 10.0.0.2:true 10.0.0.3:true]", true},
+		{"其他错误", errors.Join(&hostError{IP: "10.0.0.1", Err: timeout}, errors.New("其他错误")), "map[]", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			failed, ok := detectFailedHosts(tc.err, results, baseline)
+			if ok != tc.ok || (ok && fmt.Sprint(failed) != tc.want) {
+				t.Fatalf("失败的ip为 %v %v，期望 %s %v", failed, ok, tc.want, tc.ok)
+			}
+		})
+	}
+}
+
+// 服务识别失败的ip仍对比开放端口，服务信息与UDP端口沿用基线，不产生服务变化
+func TestKeepBaselineServices(t *testing.T) {
+	baseline := &ScanReport{Results: []ScanResult{
+		{IP: "10.0.0.1", Port: 22, Protocol: "tcp", Status: "open", Service: "ssh", Product: "OpenSSH", Version: "9.6"},
+		{IP: "10.0.0.1", Port: 53, Protocol: "udp", Status: "open", Service: "domain"},
+		{IP: "10.0.0.1", Port: 3306, Protocol: "tcp", Status: "open", Service: "mysql"},
+		{IP: "10.0.0.2", Port: 80, Protocol: "tcp", Status: "open", Service: "http", Product: "nginx"},
+	}}
+	report := &ScanReport{Results: []ScanResult{
+		{IP: "10.0.0.1", Port: 22, Protocol: "tcp", Status: "open"},
+		{IP: "10.0.0.1", Port: 8080, Protocol: "tcp", Status: "open"},
+		{IP: "10.0.0.2", Port: 80, Protocol: "tcp", Status: "open", Service: "http", Product: "Apache httpd"},
+	}}
+	keepBaselineServices(baseline, report, map[string]bool{"10.0.0.1": true})
+
+	var got []string
+	for _, result := range report.Results {
+		got = append(got, resultKey(result)+" "+result.Service)
+	}
+	want := "[10.0.0.1:22/tcp ssh 10.0.0.1:53/udp domain 10.0.0.1:8080/tcp  10.0.0.2:80/tcp http]"
+	if fmt.Sprint(got) != want {
+		t.Fatalf("合并后的结果为 %v，期望 %s", got, want)
+	}
+
+	diff := diffReports(baseline, report)
+	if len(diff.Opened) != 1 || resultKey(diff.Opened[0]) != "10.0.0.1:8080/tcp" {
+		t.Errorf("新开放端口为 %v，期望 10.0.0.1:8080/tcp", diff.Opened)
+	}
+	if len(diff.Closed) != 1 || resultKey(diff.Closed[0]) != "10.0.0.1:3306/tcp" {
+		t.Errorf("已关闭端口为 %v，期望 10.0.0.1:3306/tcp", diff.Closed)
+	}
+	for _, change := range diff.Changed {
+		if change.New.IP == "10.0.0.1" {
+			t.Errorf("识别失败的ip产生了服务变化: %+v", change)
+		}
+	}
+}
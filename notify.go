@@ -0,0 +1,572 @@
+package tools
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+/*
+扫描通知
+1、-notify 指定通知地址，多个以逗号分割，扫描结束时发送汇总（目标、耗时、开放端口数与达到-notify-severity等级的问题）：
+   webhook:<url>    通用webhook，POST json格式的汇总与全部结果
+   slack:<url>      Slack与Mattermost的incoming webhook
+   dingtalk:<url>   钉钉机器人，加签时在地址后附加 &secret=SEC...
+   wecom:<url>      企业微信机器人
+   feishu:<url>     飞书机器人，加签时在地址后附加 &secret=...
+2、-notify-findings 开启时每个达到-notify-severity等级的问题（未授权访问、弱口令、漏洞）单独发送一条通知，
+   最多发送汇总中列出的20个，其余只在汇总中计数，避免触发机器人的频率限制（钉钉与企业微信约每分钟20条）
+3、-notify-template 指定text/template模板文件，可用 {{define "summary"}}、{{define "finding"}}、{{define "alert"}} 覆盖汇总、问题与monitor告警的消息内容
+4、发送失败（网络错误、5xx或机器人返回错误码）时按1s、2s、4s…间隔重试-notify-retries次，最终失败只记录错误，不影响扫描结果；
+   限流（429或机器人的限流错误码）时按Retry-After或10s等待后重发，不计入重试次数，单条消息累计最多等待1分钟
+5、monitor的-alert同样支持以上机器人地址；notify子命令发送一条测试通知，用于检查配置
+*/
+
+// 汇总中最多列出的问题数
+const maxSummaryFindings = 20
+
+// 单次发送的超时时间
+const notifyTimeout = 10 * time.Second
+
+// 第一次重试前的等待时间，之后每次加倍
+var notifyRetryWait = time.Second
+
+// 限流时未指定Retry-After的等待时间，以及单条消息因限流累计等待的上限
+var (
+	notifyRateLimitWait = 10 * time.Second
+	notifyRateLimitMax  = time.Minute
+)
+
+// 机器人应答中表示发送过快的错误码：钉钉130101、企业微信45009、飞书11232
+var botRateLimitCodes = map[int]bool{130101: true, 45009: true, 11232: true}
+
+// 默认消息模板
+const defaultNotifyTemplates = `
+{{- define "summary" -}}
+{{.Title}}
+目标: {{.Targets}}
+端口: {{.Ports}}
+时间: {{.StartTime.Format "2006-01-02 15:04:05"}}，耗时{{.Duration}}
+结果: {{.Hosts}}个主机，{{.Open}}个开放端口
+{{- if .Findings}}
+问题{{.FindingCount}}个（{{.Severity}}及以上）:
+{{- range .Findings}}
+- {{.}}
+{{- end}}
+{{- if gt .FindingCount (len .Findings)}}
+- 另有{{sub .FindingCount (len .Findings)}}个，详见结果文件
+{{- end}}
+{{- end}}
+{{- if .Error}}
+错误: {{.Error}}
+{{- end}}
+{{- end}}
+
+{{- define "finding" -}}
+[{{.Severity}}] {{.Title}}
+{{.IP}}:{{.Port}} {{.Service}}
+{{- if .Detail}}
+{{.Detail}}
+{{- end}}
+{{- end}}
+
+{{- define "alert" -}}
+{{.Title}}
+{{- range .Alerts}}
+- {{.Message}}
+{{- end}}
+{{- end}}
+`
+
+// notifier 一个通知地址
+type notifier struct {
+	kind   string // webhook、slack、dingtalk、wecom、feishu
+	url    string
+	secret string // 钉钉与飞书机器人的加签密钥
+}
+
+// 支持的通知类型
+var notifierKinds = map[string]bool{"webhook": true, "slack": true, "dingtalk": true, "wecom": true, "feishu": true}
+
+// 解析一个通知地址
+func parseNotifier(item string) (notifier, error) {
+	kind, rawURL, _ := strings.Cut(strings.TrimSpace(item), ":")
+	if !notifierKinds[kind] {
+		return notifier{}, fmt.Errorf("不支持的通知类型: %s，支持webhook、slack、dingtalk、wecom、feishu", item)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return notifier{}, fmt.Errorf("通知地址不合法: %s", item)
+	}
+
+	n := notifier{kind: kind}
+	if query := u.Query(); query.Has("secret") {
+		n.secret = query.Get("secret")
+		query.Del("secret")
+		u.RawQuery = query.Encode()
+	}
+	n.url = u.String()
+	return n, nil
+}
+
+// 解析-notify参数
+func parseNotifiers(spec string) ([]notifier, error) {
+	var notifiers []notifier
+	for _, item := range strings.Split(spec, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		n, err := parseNotifier(item)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, n)
+	}
+	return notifiers, nil
+}
+
+// 解析问题等级，支持中文与英文，无法识别时返回空字符串
+func parseSeverity(value string) string {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "critical", severityCritical:
+		return severityCritical
+	case "high", severityHigh, "":
+		return severityHigh
+	case "medium", severityMedium:
+		return severityMedium
+	case "low", severityLow:
+		return severityLow
+	}
+	return ""
+}
+
+var (
+	notifiers         []notifier
+	notifyTemplate    string
+	notifyRetries     int
+	notifyTemplates   *template.Template
+	notifyTemplateErr error
+	notifyTemplateMu  sync.Mutex
+	notifyClient      = &http.Client{Timeout: notifyTimeout}
+)
+
+// 设置通知地址、模板文件与重试次数，需要在开始扫描之前调用
+func setNotifiers(spec string, templatePath string, retries int) {
+	notifiers, _ = parseNotifiers(spec)
+	notifyRetries = retries
+	if templatePath != notifyTemplate {
+		notifyTemplate = templatePath
+		notifyTemplates, notifyTemplateErr = nil, nil
+	}
+}
+
+// 加载消息模板，自定义模板文件中的同名模板覆盖默认模板
+func loadNotifyTemplates() (*template.Template, error) {
+	notifyTemplateMu.Lock()
+	defer notifyTemplateMu.Unlock()
+	if notifyTemplates != nil || notifyTemplateErr != nil {
+		return notifyTemplates, notifyTemplateErr
+	}
+
+	funcs := template.FuncMap{"sub": func(a int, b int) int { return a - b }}
+	tmpl := template.Must(template.New("notify").Funcs(funcs).Parse(defaultNotifyTemplates))
+	if notifyTemplate != "" {
+		content, err := os.ReadFile(notifyTemplate)
+		if err != nil {
+			notifyTemplateErr = fmt.Errorf("%w: 通知模板读取失败: %v", ErrInvalidArgument, err)
+			return nil, notifyTemplateErr
+		}
+		if _, err := tmpl.Parse(string(content)); err != nil {
+			notifyTemplateErr = fmt.Errorf("%w: 通知模板 %s 解析失败: %v", ErrInvalidArgument, notifyTemplate, err)
+			return nil, notifyTemplateErr
+		}
+	}
+	notifyTemplates = tmpl
+	return notifyTemplates, nil
+}
+
+// 使用指定模板生成消息内容
+func renderNotify(name string, data any) (string, error) {
+	tmpl, err := loadNotifyTemplates()
+	if err != nil {
+		return "", err
+	}
+	var builder strings.Builder
+	if err := tmpl.ExecuteTemplate(&builder, name, data); err != nil {
+		return "", fmt.Errorf("通知模板%s执行失败: %v", name, err)
+	}
+	return strings.TrimSpace(builder.String()), nil
+}
+
+// notifyFinding 通知中的一个问题
+type notifyFinding struct {
+	IP       string `json:"ip"`
+	Port     int    `json:"port"`
+	Service  string `json:"service"`
+	Severity string `json:"severity"`
+	Title    string `json:"title"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+func (f notifyFinding) String() string {
+	return fmt.Sprintf("[%s] %s:%d %s %s", f.Severity, f.IP, f.Port, f.Service, f.Title)
+}
+
+// 问题等级是否不低于limit，无法识别的等级不计入
+func severityAtLeast(severity string, limit string) bool {
+	order, ok := severityOrder[severity]
+	return ok && order <= severityOrder[limit]
+}
+
+// 收集达到指定等级的问题与漏洞，按等级排序
+func collectFindings(results []ScanResult, severity string) []notifyFinding {
+	var findings []notifyFinding
+	for _, result := range results {
+		for _, finding := range result.Findings {
+			if severityAtLeast(finding.Severity, severity) {
+				findings = append(findings, notifyFinding{IP: result.IP, Port: result.Port, Service: result.Service,
+					Severity: finding.Severity, Title: finding.Title, Detail: finding.Detail})
+			}
+		}
+		for _, vuln := range result.Vulns {
+			if severityAtLeast(vuln.Severity, severity) {
+				findings = append(findings, notifyFinding{IP: result.IP, Port: result.Port, Service: result.Service,
+					Severity: vuln.Severity, Title: fmt.Sprintf("%s（CVSS %.1f，置信度%s）", vuln.ID, vuln.CVSS, vuln.Confidence),
+					Detail: vuln.Summary})
+			}
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		return severityOrder[findings[i].Severity] < severityOrder[findings[j].Severity]
+	})
+	return findings
+}
+
+// notifySummary 扫描结束的汇总，同时作为summary模板的数据
+type notifySummary struct {
+	Title        string          `json:"title"`
+	Command      string          `json:"command"`
+	Targets      string          `json:"targets"`
+	Ports        string          `json:"ports"`
+	StartTime    time.Time       `json:"start_time"`
+	EndTime      time.Time       `json:"end_time"`
+	Duration     time.Duration   `json:"-"`
+	Hosts        int             `json:"hosts"`
+	Open         int             `json:"open"`
+	Severity     string          `json:"severity"` // 列出的问题等级下限
+	FindingCount int             `json:"finding_count"`
+	Findings     []notifyFinding `json:"findings"`
+	Error        string          `json:"error,omitempty"`
+}
+
+// 生成扫描结束的汇总
+func newNotifySummary(command string, report *ScanReport, severity string, scanErr error) (*notifySummary, []notifyFinding) {
+	hosts := make(map[string]bool)
+	for _, result := range report.Results {
+		hosts[result.IP] = true
+	}
+	findings := collectFindings(report.Results, severity)
+
+	summary := &notifySummary{
+		Title:        "miao扫描完成（" + command + "）",
+		Command:      command,
+		Targets:      report.Targets,
+		Ports:        report.Ports,
+		StartTime:    report.StartTime,
+		EndTime:      report.EndTime,
+		Duration:     report.EndTime.Sub(report.StartTime).Round(time.Second),
+		Hosts:        len(hosts),
+		Open:         len(report.Results),
+		Severity:     severity,
+		FindingCount: len(findings),
+		Findings:     findings[:min(len(findings), maxSummaryFindings)],
+	}
+	if scanErr != nil {
+		summary.Title = "miao扫描部分失败（" + command + "）"
+		summary.Error = scanErr.Error()
+	}
+	return summary, findings
+}
+
+// notifyMessage 发送给各通知地址的一条消息
+type notifyMessage struct {
+	Event string // summary、finding、alert、test
+	Title string
+	Text  string
+	Data  map[string]any // 通用webhook附带的数据
+}
+
+// 扫描结束时发送通知，未配置通知地址时跳过
+func notifyScan(cfg *Config, command string, report *ScanReport, scanErr error) {
+	if len(notifiers) == 0 {
+		return
+	}
+	severity := parseSeverity(cfg.NotifySeverity)
+	summary, findings := newNotifySummary(command, report, severity, scanErr)
+
+	text, err := renderNotify("summary", summary)
+	if err != nil {
+		slog.Error(err.Error())
+		return
+	}
+	sendNotify(notifyMessage{Event: "summary", Title: summary.Title, Text: text,
+		Data: map[string]any{"summary": summary, "results": report.Results}})
+
+	if !cfg.NotifyFindings {
+		return
+	}
+	if len(findings) > maxSummaryFindings {
+		slog.Warn(fmt.Sprintf("问题共%d个，只逐条通知前%d个，其余见汇总与结果文件", len(findings), maxSummaryFindings))
+		findings = findings[:maxSummaryFindings]
+	}
+	for _, finding := range findings {
+		text, err := renderNotify("finding", finding)
+		if err != nil {
+			slog.Error(err.Error())
+			return
+		}
+		sendNotify(notifyMessage{Event: "finding", Title: "miao发现" + finding.Severity + "问题", Text: text,
+			Data: map[string]any{"finding": finding}})
+	}
+}
+
+// 将消息发送到全部通知地址，失败时记录错误
+func sendNotify(msg notifyMessage) {
+	for _, n := range notifiers {
+		if err := n.sendWithRetry(msg, notifyRetries); err != nil {
+			slog.Error(fmt.Sprintf("通知发送到%s失败: %v", n.kind, err))
+		}
+	}
+}
+
+// 发送失败时按指数退避重试，请求有误等无法通过重试解决的错误直接返回
+// 限流时等待后重发，不计入重试次数，累计等待超过notifyRateLimitMax后按普通失败重试
+func (n notifier) sendWithRetry(msg notifyMessage, retries int) error {
+	var limited time.Duration
+	attempt := 0
+	for {
+		err := n.send(msg)
+		if err == nil || errors.Is(err, errNotifyPermanent) {
+			return err
+		}
+
+		var rateLimit *notifyRateLimitError
+		if errors.As(err, &rateLimit) && limited < notifyRateLimitMax {
+			wait := notifyRateLimitWait
+			if rateLimit.retryAfter > 0 {
+				wait = rateLimit.retryAfter
+			}
+			wait = min(wait, notifyRateLimitMax-limited)
+			limited += wait
+			slog.Debug("通知地址限流，稍后重发", "kind", n.kind, "wait", wait, "error", err)
+			time.Sleep(wait)
+			continue
+		}
+
+		attempt++
+		if attempt > retries {
+			return err
+		}
+		wait := notifyRetryWait << (attempt - 1)
+		slog.Debug("通知发送失败，稍后重试", "kind", n.kind, "attempt", attempt, "wait", wait, "error", err)
+		time.Sleep(wait)
+	}
+}
+
+// 重试无法解决的发送错误，例如地址或密钥错误
+var errNotifyPermanent = errors.New("通知地址拒绝请求")
+
+// 通知地址限流，retryAfter为应答中Retry-After指定的等待时间，未指定时为0
+type notifyRateLimitError struct {
+	reason     string
+	retryAfter time.Duration
+}
+
+func (e *notifyRateLimitError) Error() string {
+	return "发送过快被限流: " + e.reason
+}
+
+// 发送一条消息
+func (n notifier) send(msg notifyMessage) error {
+	target := n.url
+	body := n.payload(msg)
+
+	// 加签：钉钉将签名附加到地址上，飞书放在请求体中
+	if n.secret != "" {
+		switch n.kind {
+		case "dingtalk":
+			timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
+			mac := hmac.New(sha256.New, []byte(n.secret))
+			mac.Write([]byte(timestamp + "\n" + n.secret))
+			sign := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+			target += "&timestamp=" + timestamp + "&sign=" + url.QueryEscape(sign)
+		case "feishu":
+			timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+			mac := hmac.New(sha256.New, []byte(timestamp+"\n"+n.secret))
+			body["timestamp"] = timestamp
+			body["sign"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+		}
+	}
+
+	content, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := notifyClient.Post(target, "application/json", bytes.NewReader(content))
+	if err != nil {
+		// 错误信息中的地址带有令牌与签名，会输出到日志，只保留失败原因
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return fmt.Errorf("请求失败: %w", urlErr.Err)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		seconds, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return &notifyRateLimitError{reason: "返回" + resp.Status, retryAfter: time.Duration(seconds) * time.Second}
+	case resp.StatusCode >= 500:
+		return fmt.Errorf("返回%s", resp.Status)
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("%w: 返回%s %s", errNotifyPermanent, resp.Status, bytes.TrimSpace(respBody))
+	}
+	return checkBotResponse(respBody)
+}
+
+// 机器人即使发送失败也返回200，需要检查应答中的错误码：钉钉与企业微信为errcode，飞书为code或StatusCode
+func checkBotResponse(body []byte) error {
+	var reply struct {
+		ErrCode       *int   `json:"errcode"`
+		ErrMsg        string `json:"errmsg"`
+		Code          *int   `json:"code"`
+		Msg           string `json:"msg"`
+		StatusCode    *int   `json:"StatusCode"`
+		StatusMessage string `json:"StatusMessage"`
+	}
+	if json.Unmarshal(body, &reply) != nil {
+		return nil
+	}
+	var code int
+	var message string
+	switch {
+	case reply.ErrCode != nil && *reply.ErrCode != 0:
+		code, message = *reply.ErrCode, reply.ErrMsg
+	case reply.Code != nil && *reply.Code != 0:
+		code, message = *reply.Code, reply.Msg
+	case reply.StatusCode != nil && *reply.StatusCode != 0:
+		code, message = *reply.StatusCode, reply.StatusMessage
+	default:
+		return nil
+	}
+	if botRateLimitCodes[code] {
+		return &notifyRateLimitError{reason: fmt.Sprintf("返回错误码%d: %s", code, message)}
+	}
+	return fmt.Errorf("返回错误码%d: %s", code, message)
+}
+
+// 各类通知地址的请求体
+func (n notifier) payload(msg notifyMessage) map[string]any {
+	switch n.kind {
+	case "slack":
+		return map[string]any{"text": msg.Text}
+	case "dingtalk":
+		// 钉钉markdown需要两个空格加换行才会换行
+		return map[string]any{"msgtype": "markdown", "markdown": map[string]any{
+			"title": msg.Title, "text": strings.ReplaceAll(msg.Text, "\n", "  \n")}}
+	case "wecom":
+		return map[string]any{"msgtype": "markdown", "markdown": map[string]any{"content": msg.Text}}
+	case "feishu":
+		return map[string]any{"msg_type": "text", "content": map[string]any{"text": msg.Text}}
+	}
+
+	body := map[string]any{"event": msg.Event, "title": msg.Title, "text": msg.Text}
+	for key, value := range msg.Data {
+		body[key] = value
+	}
+	return body
+}
+
+// 将monitor告警发送到机器人
+type notifierSink struct {
+	notifier notifier
+}
+
+func (s notifierSink) name() string {
+	return s.notifier.kind
+}
+
+func (s notifierSink) send(alerts []monitorAlert) error {
+	title := "miao监控告警"
+	text, err := renderNotify("alert", map[string]any{"Title": title, "Alerts": alerts})
+	if err != nil {
+		return err
+	}
+	return s.notifier.sendWithRetry(notifyMessage{Event: "alert", Title: title, Text: text,
+		Data: map[string]any{"alerts": alerts}}, notifyRetries)
+}
+
+func runNotify(args []string) error {
+	fs := newFlagSet("notify", "向-notify指定的地址发送一条测试通知，用于检查通知地址、加签密钥与消息模板")
+	loadCfg := configFlags(fs, "notify")
+	fs.Parse(args)
+
+	cfg, err := loadCfg()
+	if err != nil {
+		return err
+	}
+	if len(notifiers) == 0 {
+		return fmt.Errorf("%w: 未指定通知地址 可通过-h查看用法", ErrInvalidArgument)
+	}
+
+	// 使用示例数据渲染汇总模板
+	now := time.Now()
+	report := &ScanReport{
+		StartTime: now.Add(-time.Minute),
+		EndTime:   now,
+		Targets:   "10.1.1.0/24",
+		Ports:     cfg.Ports,
+		Results: []ScanResult{
+			{IP: "10.1.1.2", Port: 6379, Protocol: "tcp", Service: "redis", Status: "open",
+				Findings: []Finding{{Check: "redis", Severity: severityCritical, Title: "Redis未授权访问（测试通知）"}}},
+		},
+	}
+	summary, _ := newNotifySummary("notify", report, parseSeverity(cfg.NotifySeverity), nil)
+	summary.Title = "miao测试通知"
+	text, err := renderNotify("summary", summary)
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, n := range notifiers {
+		msg := notifyMessage{Event: "test", Title: summary.Title, Text: text, Data: map[string]any{"summary": summary}}
+		if err := n.sendWithRetry(msg, cfg.NotifyRetries); err != nil {
+			slog.Error(fmt.Sprintf("测试通知发送到%s失败: %v", n.kind, err))
+			failed++
+			continue
+		}
+		slog.Info(fmt.Sprintf("测试通知已发送到%s", n.kind))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d个通知地址发送失败", failed)
+	}
+	return nil
+}
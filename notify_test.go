@@ -0,0 +1,328 @@
+package tools
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 本地模拟的通知地址，记录收到的请求并按顺序返回预设的应答，预设应答用完后返回最后一个
+type notifyStub struct {
+	mu        sync.Mutex
+	requests  []notifyRequest
+	responses []stubResponse
+	server    *httptest.Server
+}
+
+type notifyRequest struct {
+	query url.Values
+	body  map[string]any
+}
+
+type stubResponse struct {
+	status int
+	body   string
+}
+
+func newNotifyStub(t *testing.T, responses ...stubResponse) *notifyStub {
+	t.Helper()
+	if len(responses) == 0 {
+		responses = []stubResponse{{http.StatusOK, `{"errcode":0,"errmsg":"ok"}`}}
+	}
+	stub := &notifyStub{responses: responses}
+	stub.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		content, _ := io.ReadAll(r.Body)
+		json.Unmarshal(content, &body)
+
+		stub.mu.Lock()
+		stub.requests = append(stub.requests, notifyRequest{query: r.URL.Query(), body: body})
+		resp := stub.responses[min(len(stub.requests), len(stub.responses))-1]
+		stub.mu.Unlock()
+
+		w.WriteHeader(resp.status)
+		io.WriteString(w, resp.body)
+	}))
+	t.Cleanup(stub.server.Close)
+
+	oldWait, oldLimitWait, oldLimitMax := notifyRetryWait, notifyRateLimitWait, notifyRateLimitMax
+	notifyRetryWait, notifyRateLimitWait, notifyRateLimitMax = time.Millisecond, time.Millisecond, 5*time.Millisecond
+	t.Cleanup(func() { notifyRetryWait, notifyRateLimitWait, notifyRateLimitMax = oldWait, oldLimitWait, oldLimitMax })
+	return stub
+}
+
+func (s *notifyStub) received() []notifyRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]notifyRequest(nil), s.requests...)
+}
+
+func (s *notifyStub) notifier(t *testing.T, kind string, query string) notifier {
+	t.Helper()
+	n, err := parseNotifier(kind + ":" + s.server.URL + "/hook" + query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// 读取json中的嵌套字段，例如 field(body, "markdown", "text")
+func field(body map[string]any, keys ...string) any {
+	var value any = body
+	for _, key := range keys {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+	return value
+}
+
+func TestNotifyPayload(t *testing.T) {
+	msg := notifyMessage{Event: "summary", Title: "miao扫描完成（scan）", Text: "第一行\n第二行",
+		Data: map[string]any{"summary": map[string]any{"open": 3}}}
+
+	cases := []struct {
+		kind string
+		want map[string]any // 字段路径（以.分割）与期望值
+		keys int            // 请求体顶层字段数
+	}{
+		{"webhook", map[string]any{"event": "summary", "title": msg.Title, "text": msg.Text, "summary.open": float64(3)}, 4},
+		{"slack", map[string]any{"text": msg.Text}, 1},
+		{"dingtalk", map[string]any{"msgtype": "markdown", "markdown.title": msg.Title, "markdown.text": "第一行  \n第二行"}, 2},
+		{"wecom", map[string]any{"msgtype": "markdown", "markdown.content": msg.Text}, 2},
+		{"feishu", map[string]any{"msg_type": "text", "content.text": msg.Text}, 2},
+	}
+	for _, tc := range cases {
+		t.Run(tc.kind, func(t *testing.T) {
+			stub := newNotifyStub(t)
+			if err := stub.notifier(t, tc.kind, "").send(msg); err != nil {
+				t.Fatalf("发送失败: %v", err)
+			}
+			requests := stub.received()
+			if len(requests) != 1 {
+				t.Fatalf("收到%d个请求，期望1个", len(requests))
+			}
+			body := requests[0].body
+			if len(body) != tc.keys {
+				t.Errorf("请求体为%v，期望%d个字段", body, tc.keys)
+			}
+			for path, want := range tc.want {
+				if got := field(body, strings.Split(path, ".")...); got != want {
+					t.Errorf("%s为%v，期望%v", path, got, want)
+				}
+			}
+		})
+	}
+}
+
+func hmacBase64(key string, message string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(message))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// 签名时间戳应接近当前时间
+func checkTimestamp(t *testing.T, timestamp string, unit time.Duration) {
+	t.Helper()
+	value, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		t.Fatalf("时间戳不合法: %q", timestamp)
+	}
+	if diff := time.Since(time.Unix(0, value*int64(unit))); diff < -time.Minute || diff > time.Minute {
+		t.Fatalf("时间戳%s与当前时间相差%s", timestamp, diff)
+	}
+}
+
+func TestNotifyDingTalkSign(t *testing.T) {
+	const secret = "SEC0123456789abcdef"
+	stub := newNotifyStub(t)
+	n := stub.notifier(t, "dingtalk", "?access_token=token123&secret="+secret)
+	if err := n.send(notifyMessage{Title: "测试", Text: "测试"}); err != nil {
+		t.Fatalf("发送失败: %v", err)
+	}
+
+	query := stub.received()[0].query
+	if query.Get("access_token") != "token123" || query.Has("secret") {
+		t.Fatalf("地址参数为%v，应保留access_token并去掉secret", query)
+	}
+	timestamp := query.Get("timestamp")
+	checkTimestamp(t, timestamp, time.Millisecond)
+	if want := hmacBase64(secret, timestamp+"\n"+secret); query.Get("sign") != want {
+		t.Fatalf("签名为%s，期望%s", query.Get("sign"), want)
+	}
+}
+
+func TestNotifyFeishuSign(t *testing.T) {
+	const secret = "feishu-secret"
+	stub := newNotifyStub(t, stubResponse{http.StatusOK, `{"StatusCode":0,"StatusMessage":"success","code":0,"msg":"success"}`})
+	n := stub.notifier(t, "feishu", "?secret="+secret)
+	if err := n.send(notifyMessage{Title: "测试", Text: "测试"}); err != nil {
+		t.Fatalf("发送失败: %v", err)
+	}
+
+	request := stub.received()[0]
+	if request.query.Has("secret") {
+		t.Fatal("地址中不应包含secret")
+	}
+	timestamp, _ := request.body["timestamp"].(string)
+	checkTimestamp(t, timestamp, time.Second)
+	// 飞书以 时间戳\n密钥 作为HMAC的密钥，对空内容签名
+	if want := hmacBase64(timestamp+"\n"+secret, ""); request.body["sign"] != want {
+		t.Fatalf("签名为%v，期望%s", request.body["sign"], want)
+	}
+}
+
+func TestNotifyRetry(t *testing.T) {
+	msg := notifyMessage{Event: "test", Title: "测试", Text: "测试"}
+	ok := stubResponse{http.StatusOK, `{}`}
+	unavailable := stubResponse{http.StatusServiceUnavailable, "busy"}
+	limited := stubResponse{http.StatusTooManyRequests, "slow down"}
+	badSign := stubResponse{http.StatusOK, `{"errcode":310000,"errmsg":"sign not match"}`}
+	tooFast := stubResponse{http.StatusOK, `{"errcode":130101,"errmsg":"send too fast, exceed 20 times per minute"}`}
+
+	cases := []struct {
+		name      string
+		responses []stubResponse
+		retries   int
+		requests  int
+		permanent bool
+		fail      bool
+	}{
+		{"5xx与429后成功", []stubResponse{unavailable, limited, ok}, 3, 3, false, false},
+		{"重试次数用完", []stubResponse{unavailable}, 2, 3, false, true},
+		{"4xx不重试", []stubResponse{{http.StatusForbidden, "forbidden"}, ok}, 3, 1, true, true},
+		{"404不重试", []stubResponse{{http.StatusNotFound, "not found"}, ok}, 3, 1, true, true},
+		{"机器人错误码视为失败", []stubResponse{badSign}, 1, 2, false, true},
+		{"机器人错误码后成功", []stubResponse{badSign, {http.StatusOK, `{"errcode":0,"errmsg":"ok"}`}}, 1, 2, false, false},
+		// 限流累计等待5ms，每次等待1ms
+		{"限流不计入重试次数", []stubResponse{limited, limited, limited, limited, ok}, 0, 5, false, false},
+		{"机器人限流错误码", []stubResponse{tooFast, tooFast, ok}, 0, 3, false, false},
+		{"限流超过等待上限后按失败重试", []stubResponse{limited}, 1, 7, false, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			stub := newNotifyStub(t, tc.responses...)
+			err := stub.notifier(t, "dingtalk", "").sendWithRetry(msg, tc.retries)
+			if (err != nil) != tc.fail || errors.Is(err, errNotifyPermanent) != tc.permanent {
+				t.Fatalf("错误为%v，期望失败=%v 不重试=%v", err, tc.fail, tc.permanent)
+			}
+			if got := len(stub.received()); got != tc.requests {
+				t.Fatalf("发送了%d次，期望%d次", got, tc.requests)
+			}
+		})
+	}
+}
+
+// 连接失败时错误信息中不应包含地址中的令牌与签名
+func TestNotifyErrorRedacted(t *testing.T) {
+	for _, item := range []string{
+		"dingtalk:http://" + closedTarget(t) + "/robot/send?access_token=token123&secret=SECxyz",
+		"slack:http://" + closedTarget(t) + "/services/T000/B000/token123",
+		"feishu:http://" + closedTarget(t) + "/open-apis/bot/v2/hook/token123?secret=SECxyz",
+	} {
+		n, err := parseNotifier(item)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = n.send(notifyMessage{Title: "测试", Text: "测试"})
+		if err == nil {
+			t.Fatalf("%s 发送成功，期望连接失败", n.kind)
+		}
+		for _, secret := range []string{"token123", "SECxyz", "sign=", "timestamp="} {
+			if strings.Contains(err.Error(), secret) {
+				t.Fatalf("%s 的错误信息包含 %s: %v", n.kind, secret, err)
+			}
+		}
+	}
+}
+
+func TestCheckBotResponse(t *testing.T) {
+	cases := []struct {
+		body string
+		fail bool
+	}{
+		{`{"errcode":0,"errmsg":"ok"}`, false},
+		{`{"errcode":310000,"errmsg":"sign not match"}`, true},
+		{`{"errcode":93000,"errmsg":"invalid webhook url"}`, true},
+		{`{"code":0,"msg":"success"}`, false},
+		{`{"code":19021,"msg":"sign match fail or timestamp is not within one hour from current time"}`, true},
+		{`{"StatusCode":0,"StatusMessage":"success"}`, false},
+		{`{"StatusCode":9499,"StatusMessage":"Bad Request"}`, true},
+		{`{"errcode":45009,"errmsg":"api freq out of limit"}`, true},
+		{`ok`, false},
+		{``, false},
+	}
+	for _, tc := range cases {
+		if err := checkBotResponse([]byte(tc.body)); (err != nil) != tc.fail {
+			t.Errorf("checkBotResponse(%s) = %v，期望失败=%v", tc.body, err, tc.fail)
+		}
+	}
+}
+
+// 问题较多时只逐条通知前maxSummaryFindings个
+func TestNotifyFindingsCap(t *testing.T) {
+	stub := newNotifyStub(t)
+	setNotifiers("slack:"+stub.server.URL+"/hook", "", 0)
+	t.Cleanup(func() { setNotifiers("", "", 0) })
+
+	report := &ScanReport{StartTime: time.Now(), EndTime: time.Now()}
+	for i := 0; i < maxSummaryFindings+5; i++ {
+		report.Results = append(report.Results, ScanResult{IP: fmt.Sprintf("10.1.1.%d", i+1), Port: 6379, Protocol: "tcp", Service: "redis", Status: "open",
+			Findings: []Finding{{Check: "redis", Severity: severityHigh, Title: "Redis未授权访问"}}})
+	}
+	cfg := defaultConfig()
+	cfg.NotifyFindings = true
+	notifyScan(cfg, "scan", report, nil)
+
+	if got := len(stub.received()); got != 1+maxSummaryFindings {
+		t.Fatalf("发送了%d条通知，期望汇总1条与问题%d条", got, maxSummaryFindings)
+	}
+}
+
+// 自定义模板覆盖summary，未覆盖的finding仍使用默认模板
+func TestNotifyTemplateOverride(t *testing.T) {
+	stub := newNotifyStub(t)
+	path := filepath.Join(t.TempDir(), "notify.tmpl")
+	content := `{{define "summary"}}自定义汇总 {{.Title}} 开放{{.Open}}个 问题{{.FindingCount}}个{{end}}`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	setNotifiers("slack:"+stub.server.URL+"/hook", path, 0)
+	t.Cleanup(func() { setNotifiers("", "", 0) })
+
+	report := &ScanReport{StartTime: time.Now(), EndTime: time.Now(), Results: []ScanResult{
+		{IP: "10.1.1.2", Port: 6379, Protocol: "tcp", Service: "redis", Status: "open",
+			Findings: []Finding{{Check: "redis", Severity: severityHigh, Title: "Redis未授权访问", Detail: "redis_version=7.0.11"}}},
+		{IP: "10.1.1.3", Port: 22, Protocol: "tcp", Service: "ssh", Status: "open"},
+	}}
+	cfg := defaultConfig()
+	cfg.NotifyFindings = true
+	notifyScan(cfg, "scan", report, nil)
+
+	requests := stub.received()
+	if len(requests) != 2 {
+		t.Fatalf("收到%d条通知，期望汇总与问题各1条", len(requests))
+	}
+	if got, want := requests[0].body["text"], "自定义汇总 miao扫描完成（scan） 开放2个 问题1个"; got != want {
+		t.Errorf("汇总为%q，期望%q", got, want)
+	}
+	if got, want := requests[1].body["text"], "[高危] Redis未授权访问\n10.1.1.2:6379 redis\nredis_version=7.0.11"; got != want {
+		t.Errorf("问题通知为%q，期望%q", got, want)
+	}
+}
@@ -0,0 +1,215 @@
+package tools
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 内置的端口排名，按行的先后排列，不含开放频率
+//
+//go:embed data/top-ports
+var embeddedServices []byte
+
+// 端口库中的单条记录
+type portEntry struct {
+	Service string
+	Port    int
+	Proto   string
+	Freq    float64
+}
+
+// PortDB 端口库，记录按排名从前到后排列：
+// 带有开放频率的文件（nmap自带的nmap-services）按频率从高到低排列，没有频率字段的文件（内置数据）按行的先后排列
+type PortDB struct {
+	entries []portEntry
+	byName  map[string][]portEntry
+	ordered bool // 文件中没有频率字段，排名即行的先后
+}
+
+var (
+	portDBPath string
+	portDBOnce sync.Once
+	portDB     *PortDB
+	portDBErr  error
+)
+
+// topN命名集合，例如top100、top1000、top20
+var topNPattern = regexp.MustCompile(`^top(\d+)$`)
+
+// 设置自定义的端口库文件，需要在首次使用端口库之前调用
+func setPortDBPath(path string) {
+	portDBPath = path
+}
+
+// 获取端口库，未指定文件时使用内置数据，只加载一次
+func defaultPortDB() (*PortDB, error) {
+	portDBOnce.Do(func() {
+		if portDBPath == "" {
+			portDB, portDBErr = parsePortDB(bytes.NewReader(embeddedServices))
+			return
+		}
+
+		file, err := os.Open(portDBPath)
+		if err != nil {
+			portDBErr = fmt.Errorf("端口库读取失败: %v", err)
+			return
+		}
+		defer file.Close()
+		portDB, portDBErr = parsePortDB(file)
+	})
+	return portDB, portDBErr
+}
+
+// 解析nmap-services格式的数据，每行格式为 <服务名> <端口>/<协议> [频率] [# 注释]
+func parsePortDB(r io.Reader) (*PortDB, error) {
+	db := &PortDB{byName: make(map[string][]portEntry), ordered: true}
+
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("端口库第%d行格式错误", lineNum)
+		}
+
+		portProto := strings.SplitN(fields[1], "/", 2)
+		if len(portProto) != 2 {
+			return nil, fmt.Errorf("端口库第%d行格式错误: %s", lineNum, fields[1])
+		}
+		port, err := strconv.Atoi(portProto[0])
+		if err != nil || port < minPort || port > maxPort {
+			return nil, fmt.Errorf("端口库第%d行端口非法: %s", lineNum, portProto[0])
+		}
+
+		// 出现频率字段时按频率排列，nmap-services中部分条目没有频率字段，视为0
+		var freq float64
+		if len(fields) > 2 {
+			freq, _ = strconv.ParseFloat(fields[2], 64)
+			db.ordered = false
+		}
+
+		db.entries = append(db.entries, portEntry{
+			Service: strings.ToLower(fields[0]),
+			Port:    port,
+			Proto:   strings.ToLower(portProto[1]),
+			Freq:    freq,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// 频率相同时保持文件中的顺序
+	if !db.ordered {
+		sort.SliceStable(db.entries, func(i, j int) bool {
+			return db.entries[i].Freq > db.entries[j].Freq
+		})
+	}
+	for _, entry := range db.entries {
+		db.byName[entry.Service] = append(db.byName[entry.Service], entry)
+	}
+	return db, nil
+}
+
+// 是否参与排名，nmap-services中频率为0的端口不计入topN
+func (db *PortDB) ranked(entry portEntry) bool {
+	return db.ordered || entry.Freq > 0
+}
+
+// Top 返回指定协议下排名最前的n个端口
+func (db *PortDB) Top(n int, proto string) []int {
+	var ports []int
+	for _, entry := range db.entries {
+		if len(ports) >= n {
+			break
+		}
+		if entry.Proto == proto && db.ranked(entry) {
+			ports = append(ports, entry.Port)
+		}
+	}
+	return ports
+}
+
+// Lookup 根据服务名查找端口，同名服务存在多个端口时取排名最前的一个
+func (db *PortDB) Lookup(name string, proto string) (int, bool) {
+	for _, entry := range db.byName[strings.ToLower(name)] {
+		if entry.Proto == proto {
+			return entry.Port, true
+		}
+	}
+	return 0, false
+}
+
+// Service 根据端口查找服务名，同一端口存在多个服务时取排名最前的一个
+func (db *PortDB) Service(port int, proto string) string {
+	for _, entry := range db.entries {
+		if entry.Port == port && entry.Proto == proto {
+			return entry.Service
+		}
+	}
+	return ""
+}
+
+// Rank 按端口库中的排名对端口重新排序，未参与排名的端口排在最后并保持原有顺序
+func (db *PortDB) Rank(ports []int, proto string) []int {
+	rank := make(map[int]int)
+	for _, entry := range db.entries {
+		if entry.Proto == proto && db.ranked(entry) {
+			if _, ok := rank[entry.Port]; !ok {
+				rank[entry.Port] = len(rank)
+			}
+		}
+	}
+
+	position := func(port int) int {
+		if i, ok := rank[port]; ok {
+			return i
+		}
+		return len(rank)
+	}
+	ranked := append([]int(nil), ports...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return position(ranked[i]) < position(ranked[j])
+	})
+	return ranked
+}
+
+// 命名端口集合，支持topN（如top100、top1000），proto为tcp或udp，未知名称返回nil
+// N超出端口库中该协议的端口数量时返回错误，不静默缩小扫描范围
+func namedPorts(name string, proto string) ([]string, error) {
+	match := topNPattern.FindStringSubmatch(name)
+	if match == nil {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(match[1])
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+
+	db, err := defaultPortDB()
+	if err != nil {
+		return nil, err
+	}
+	ports := db.Top(n, proto)
+	if len(ports) < n {
+		return nil, fmt.Errorf("%s超出端口库中%s端口的数量%d，如需更多端口请通过-port-db指定nmap自带的nmap-services文件", name, proto, len(ports))
+	}
+	return portStrings(ports), nil
+}
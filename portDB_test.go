@@ -0,0 +1,99 @@
+package tools
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestPortDBOrder(t *testing.T) {
+	// 没有频率字段时按行的先后排列
+	ordered, err := parsePortDB(strings.NewReader(`
+http	80/tcp
+telnet	23/tcp
+https	443/tcp
+domain	53/udp
+http-alt	8080/tcp	# 注释
+http	8000/tcp
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 带有频率字段时按频率排列，频率相同时保持文件中的顺序，频率为0的端口不参与排名
+	byFreq, err := parsePortDB(strings.NewReader(`
+ftp	21/tcp	0.100000
+ssh	22/tcp	0.200000
+http	80/tcp	0.300000
+https	443/tcp	0.100000
+http	8000/tcp	0.400000
+zero	9999/tcp	0.000000
+domain	53/udp	0.500000
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		got  any
+		want string
+	}{
+		{"按行排列的top3", ordered.Top(3, "tcp"), "[80 23 443]"},
+		{"按行排列的全部端口", ordered.Top(100, "tcp"), "[80 23 443 8080 8000]"},
+		{"按行排列的udp", ordered.Top(100, "udp"), "[53]"},
+		{"按行排列时同名服务取第一个", fmt.Sprint(ordered.Lookup("HTTP", "tcp")), "80 true"},
+		{"按行重新排序", ordered.Rank([]int{8000, 22, 443, 80, 21}, "tcp"), "[80 443 8000 22 21]"},
+		{"按频率排列的端口", byFreq.Top(100, "tcp"), "[8000 80 22 21 443]"},
+		{"按频率排列时同名服务取频率最高的", fmt.Sprint(byFreq.Lookup("http", "tcp")), "8000 true"},
+		{"按频率重新排序", byFreq.Rank([]int{9999, 21, 1234, 80, 443}, "tcp"), "[80 21 443 9999 1234]"},
+		{"按端口查找服务", byFreq.Service(443, "tcp"), "https"},
+		{"服务名不存在", fmt.Sprint(byFreq.Lookup("mysql", "tcp")), "0 false"},
+	}
+	for _, tc := range cases {
+		if got := fmt.Sprint(tc.got); got != tc.want {
+			t.Errorf("%s: %s，期望 %s", tc.name, got, tc.want)
+		}
+	}
+
+	for _, content := range []string{"http", "http 80", "http 0/tcp", "http abc/tcp"} {
+		if _, err := parsePortDB(strings.NewReader(content)); err == nil {
+			t.Errorf("%q 应解析失败", content)
+		}
+	}
+}
+
+func TestNamedPorts(t *testing.T) {
+	db, err := defaultPortDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcpCount, udpCount := len(db.Top(maxPort, "tcp")), len(db.Top(maxPort, "udp"))
+
+	cases := []struct {
+		name    string
+		proto   string
+		count   int
+		wantErr bool
+	}{
+		{"top100", "tcp", 100, false},
+		{"top1000", "tcp", 1000, false},
+		{fmt.Sprintf("top%d", tcpCount), "tcp", tcpCount, false},
+		{fmt.Sprintf("top%d", tcpCount+1), "tcp", 0, true},
+		{"top2000", "tcp", 0, true},
+		{fmt.Sprintf("top%d", udpCount), "udp", udpCount, false},
+		{"top100", "udp", 0, true},
+		{"top0", "tcp", 0, false},
+		{"web", "tcp", 0, false},
+	}
+	for _, tc := range cases {
+		ports, err := namedPorts(tc.name, tc.proto)
+		if (err != nil) != tc.wantErr || len(ports) != tc.count {
+			t.Errorf("namedPorts(%s, %s) 返回%d个端口 %v，期望%d个端口 错误=%v", tc.name, tc.proto, len(ports), err, tc.count, tc.wantErr)
+		}
+	}
+
+	top, _ := namedPorts("top5", "tcp")
+	if fmt.Sprint(top) != "[80 23 443 21 22]" {
+		t.Errorf("内置数据的top5为 %v", top)
+	}
+}
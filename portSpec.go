@@ -0,0 +1,280 @@
+package tools
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+端口规格语法，多个元素以逗号分割，可任意混用
+1、单个端口：80
+2、端口范围：8000-8100，开放式范围：-1024（1-1024）、60000-（60000-65535），单独的 - 表示全部端口
+3、命名集合：topN，取端口库中排名最前的N个端口，例如top100、top1000；内置数据为固定顺序，N超出其端口数量时报错
+4、服务名：ssh、http、mysql 等，未内置的服务名从端口库中查找
+5、协议前缀：T: 与 U:，作用于其后的所有元素，直到出现下一个前缀，例如 T:80,443,U:53,161
+6、端口配置：profile:web、profile:db 等，配置中的端口自带协议，不受协议前缀影响
+*/
+
+const (
+	minPort = 1
+	maxPort = 65535
+)
+
+// PortSpec 解析后的端口集合，按协议区分，均已去重并升序排列
+type PortSpec struct {
+	TCP []int
+	UDP []int
+}
+
+// 常用服务名与端口的对应关系，作为端口库中服务名的补充
+var servicePorts = map[string]int{
+	"ftp":           21,
+	"ssh":           22,
+	"telnet":        23,
+	"smtp":          25,
+	"dns":           53,
+	"http":          80,
+	"pop3":          110,
+	"rpc":           135,
+	"netbios":       139,
+	"imap":          143,
+	"snmp":          161,
+	"ldap":          389,
+	"https":         443,
+	"smb":           445,
+	"smtps":         465,
+	"imaps":         993,
+	"pop3s":         995,
+	"mssql":         1433,
+	"oracle":        1521,
+	"nfs":           2049,
+	"docker":        2375,
+	"etcd":          2379,
+	"mysql":         3306,
+	"rdp":           3389,
+	"postgresql":    5432,
+	"vnc":           5900,
+	"winrm":         5985,
+	"redis":         6379,
+	"weblogic":      7001,
+	"http-proxy":    8080,
+	"nacos":         8848,
+	"elasticsearch": 9200,
+	"kubelet":       10250,
+	"memcached":     11211,
+	"mongodb":       27017,
+}
+
+// 解析端口规格字符串，任一元素不合法时返回错误
+func parsePortSpec(spec string) (*PortSpec, error) {
+	return parsePortSpecVisited(spec, nil)
+}
+
+// 解析端口规格字符串，visited为引用链上正在解析的端口配置
+func parsePortSpecVisited(spec string, visited map[string]bool) (*PortSpec, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, fmt.Errorf("端口规格为空")
+	}
+
+	tcp := make(map[int]bool)
+	udp := make(map[int]bool)
+
+	// 当前元素所属协议，默认为TCP
+	current, proto := tcp, "tcp"
+
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+
+		// 处理协议前缀，前缀后可直接跟端口，也可单独出现
+		switch {
+		case strings.HasPrefix(strings.ToUpper(item), "T:"):
+			current, proto = tcp, "tcp"
+			item = strings.TrimSpace(item[2:])
+		case strings.HasPrefix(strings.ToUpper(item), "U:"):
+			current, proto = udp, "udp"
+			item = strings.TrimSpace(item[2:])
+		}
+		if item == "" {
+			continue
+		}
+
+		// 端口配置按其自身的协议合并
+		if strings.HasPrefix(strings.ToLower(item), profilePrefix) {
+			profileSpec, err := resolveProfile(item[len(profilePrefix):], visited)
+			if err != nil {
+				return nil, err
+			}
+			for _, p := range profileSpec.TCP {
+				tcp[p] = true
+			}
+			for _, p := range profileSpec.UDP {
+				udp[p] = true
+			}
+			continue
+		}
+
+		ports, err := expandPortItem(item, proto)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range ports {
+			current[p] = true
+		}
+	}
+
+	result := &PortSpec{TCP: sortedPorts(tcp), UDP: sortedPorts(udp)}
+	if result.Empty() {
+		return nil, fmt.Errorf("端口规格 %q 未包含任何端口", spec)
+	}
+	return result, nil
+}
+
+// 将单个元素展开为具体端口，proto为元素所属协议
+func expandPortItem(item string, proto string) ([]int, error) {
+	lower := strings.ToLower(item)
+
+	// 命名集合
+	named, err := namedPorts(lower, proto)
+	if err != nil {
+		return nil, err
+	}
+	if named != nil {
+		var ports []int
+		for _, p := range named {
+			intPort, _ := strconv.Atoi(p)
+			ports = append(ports, intPort)
+		}
+		return ports, nil
+	}
+
+	// 服务名
+	if p, ok := servicePorts[lower]; ok {
+		return []int{p}, nil
+	}
+	if db, err := defaultPortDB(); err == nil {
+		if p, ok := db.Lookup(lower, proto); ok {
+			return []int{p}, nil
+		}
+	}
+
+	// 端口范围，包括开放式范围
+	if strings.Contains(item, "-") {
+		bounds := strings.SplitN(item, "-", 2)
+		start, end := minPort, maxPort
+		var err error
+		if bounds[0] != "" {
+			if start, err = parsePortNumber(bounds[0]); err != nil {
+				return nil, err
+			}
+		}
+		if bounds[1] != "" {
+			if end, err = parsePortNumber(bounds[1]); err != nil {
+				return nil, err
+			}
+		}
+		if start > end {
+			return nil, fmt.Errorf("端口范围 %q 起始值大于结束值", item)
+		}
+
+		ports := make([]int, 0, end-start+1)
+		for i := start; i <= end; i++ {
+			ports = append(ports, i)
+		}
+		return ports, nil
+	}
+
+	p, err := parsePortNumber(item)
+	if err != nil {
+		return nil, err
+	}
+	return []int{p}, nil
+}
+
+// 校验单个端口号，范围为1-65535
+func parsePortNumber(s string) (int, error) {
+	p, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("无法识别的端口或服务名 %q", s)
+	}
+	if p < minPort || p > maxPort {
+		return 0, fmt.Errorf("端口 %d 超出范围 %d-%d", p, minPort, maxPort)
+	}
+	return p, nil
+}
+
+func sortedPorts(set map[int]bool) []int {
+	ports := make([]int, 0, len(set))
+	for p := range set {
+		ports = append(ports, p)
+	}
+	sort.Ints(ports)
+	return ports
+}
+
+// Exclude 从当前集合中剔除另一个集合中的端口，按协议分别处理
+func (s *PortSpec) Exclude(other *PortSpec) {
+	if other == nil {
+		return
+	}
+	s.TCP = excludePorts(s.TCP, other.TCP)
+	s.UDP = excludePorts(s.UDP, other.UDP)
+}
+
+// Union 返回两个集合的并集，任一方为空时视为没有端口
+func (s *PortSpec) Union(other *PortSpec) *PortSpec {
+	tcp := make(map[int]bool)
+	udp := make(map[int]bool)
+	for _, spec := range []*PortSpec{s, other} {
+		if spec == nil {
+			continue
+		}
+		for _, p := range spec.TCP {
+			tcp[p] = true
+		}
+		for _, p := range spec.UDP {
+			udp[p] = true
+		}
+	}
+	return &PortSpec{TCP: sortedPorts(tcp), UDP: sortedPorts(udp)}
+}
+
+func excludePorts(ports []int, excluded []int) []int {
+	skip := make(map[int]bool, len(excluded))
+	for _, p := range excluded {
+		skip[p] = true
+	}
+
+	var kept []int
+	for _, p := range ports {
+		if !skip[p] {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+// Empty 判断集合中是否没有任何端口
+func (s *PortSpec) Empty() bool {
+	return len(s.TCP) == 0 && len(s.UDP) == 0
+}
+
+// TCPStrings 返回字符串形式的TCP端口，供openPort使用
+func (s *PortSpec) TCPStrings() []string {
+	return portStrings(s.TCP)
+}
+
+// UDPStrings 返回字符串形式的UDP端口
+func (s *PortSpec) UDPStrings() []string {
+	return portStrings(s.UDP)
+}
+
+func portStrings(ports []int) []string {
+	portSlice := make([]string, 0, len(ports))
+	for _, p := range ports {
+		portSlice = append(portSlice, strconv.Itoa(p))
+	}
+	return portSlice
+}
@@ -0,0 +1,84 @@
+package tools
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParsePortSpec(t *testing.T) {
+	cases := []struct {
+		name string
+		spec string
+		tcp  string
+		udp  string
+	}{
+		{"单个端口", "80", "[80]", "[]"},
+		{"重复端口去重并排序", "443, 22,80,22", "[22 80 443]", "[]"},
+		{"端口范围", "8000-8003", "[8000 8001 8002 8003]", "[]"},
+		{"省略起始值", "-3", "[1 2 3]", "[]"},
+		{"省略结束值", "65533-", "[65533 65534 65535]", "[]"},
+		{"服务名", "ssh,HTTP,mysql", "[22 80 3306]", "[]"},
+		{"命名集合", "top5", "[21 22 23 80 443]", "[]"},
+		{"协议前缀作用于其后的元素", "T:80,443,U:53,161", "[80 443]", "[53 161]"},
+		{"小写前缀与单独出现的前缀", "u:,dns,t:22", "[22]", "[53]"},
+		{"同一端口分属两种协议", "53,U:53", "[53]", "[53]"},
+		{"混合使用", "22,8080-8081,U:snmp,T:redis,-2", "[1 2 22 6379 8080 8081]", "[161]"},
+		{"空元素忽略", "80,,", "[80]", "[]"},
+	}
+	for _, tc := range cases {
+		spec, err := parsePortSpec(tc.spec)
+		if err != nil {
+			t.Errorf("%s: %q 解析失败: %v", tc.name, tc.spec, err)
+			continue
+		}
+		if got := fmt.Sprint(spec.TCP); got != tc.tcp {
+			t.Errorf("%s: %q 的tcp端口为 %s，期望 %s", tc.name, tc.spec, got, tc.tcp)
+		}
+		if got := fmt.Sprint(spec.UDP); got != tc.udp {
+			t.Errorf("%s: %q 的udp端口为 %s，期望 %s", tc.name, tc.spec, got, tc.udp)
+		}
+	}
+
+	for _, spec := range []string{"", " ", "0", "65536", "100-10", "1-70000", "nosuchservice", "T:,U:", "top99999"} {
+		if _, err := parsePortSpec(spec); err == nil {
+			t.Errorf("%q 应解析失败", spec)
+		}
+	}
+}
+
+func TestExcludePorts(t *testing.T) {
+	cases := []struct {
+		name    string
+		ports   string
+		exclude string
+		tcp     string
+		udp     string
+		wantErr bool
+	}{
+		{"不排除", "22,80", "", "[22 80]", "[]", false},
+		{"排除范围", "1-10", "3-8", "[1 2 9 10]", "[]", false},
+		{"按协议分别排除", "53,U:53,161", "U:53", "[53]", "[161]", false},
+		{"排除服务名", "top5", "ssh,telnet", "[21 80 443]", "[]", false},
+		{"排除不存在的端口", "80", "8080", "[80]", "[]", false},
+		{"全部排除", "22,80", "T:22,80,U:53", "", "", true},
+		{"排除端口不合法", "80", "abc-", "", "", true},
+	}
+	for _, tc := range cases {
+		cfg := defaultConfig()
+		cfg.Ports, cfg.ExcludePorts = tc.ports, tc.exclude
+		spec, err := resolvePorts(cfg)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: 错误为 %v，期望错误=%v", tc.name, err, tc.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got := fmt.Sprint(spec.TCP); got != tc.tcp {
+			t.Errorf("%s: tcp端口为 %s，期望 %s", tc.name, got, tc.tcp)
+		}
+		if got := fmt.Sprint(spec.UDP); got != tc.udp {
+			t.Errorf("%s: udp端口为 %s，期望 %s", tc.name, got, tc.udp)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package tools
+
+import (
+	_ "embed"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 内置的端口配置
+//
+//go:embed data/profiles.yaml
+var embeddedProfiles []byte
+
+// 端口配置引用前缀，例如 profile:web
+const profilePrefix = "profile:"
+
+// PortProfile 命名端口配置，Ports使用与-p参数相同的端口规格语法
+type PortProfile struct {
+	Description string `yaml:"description" json:"description"`
+	Ports       string `yaml:"ports" json:"ports"`
+}
+
+// 配置文件结构，yaml解析器同样可以解析json格式的文件
+type profileFile struct {
+	Profiles map[string]PortProfile `yaml:"profiles" json:"profiles"`
+}
+
+var (
+	profilePath    string
+	configProfiles map[string]PortProfile
+	profilesOnce   sync.Once
+	profiles       map[string]PortProfile
+	profilesErr    error
+)
+
+// 设置自定义端口配置文件，需要在首次使用端口配置之前调用
+func setProfilePath(path string) {
+	profilePath = path
+}
+
+// 设置配置文件中定义的端口配置，需要在首次使用端口配置之前调用
+func setConfigProfiles(p map[string]PortProfile) {
+	configProfiles = p
+}
+
+// 用户默认的端口配置文件路径
+func userProfilePath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "miao-portscan", "profiles.yaml")
+}
+
+// 获取全部端口配置，依次加载内置配置、用户默认配置文件、配置文件中的profiles和-profiles指定的文件，同名配置后者覆盖前者
+func loadProfiles() (map[string]PortProfile, error) {
+	profilesOnce.Do(func() {
+		profiles = make(map[string]PortProfile)
+
+		if profilesErr = mergeProfiles(profiles, embeddedProfiles); profilesErr != nil {
+			profilesErr = fmt.Errorf("内置端口配置解析失败: %v", profilesErr)
+			return
+		}
+
+		// 用户默认配置文件不存在时直接跳过
+		if path := userProfilePath(); path != "" {
+			if content, err := os.ReadFile(path); err == nil {
+				if profilesErr = mergeProfiles(profiles, content); profilesErr != nil {
+					profilesErr = fmt.Errorf("端口配置文件 %s 解析失败: %v", path, profilesErr)
+					return
+				}
+			}
+		}
+
+		for name, profile := range configProfiles {
+			if strings.TrimSpace(profile.Ports) == "" {
+				profilesErr = fmt.Errorf("配置文件中的端口配置 %q 缺少端口", name)
+				return
+			}
+			profiles[strings.ToLower(name)] = profile
+		}
+
+		if profilePath != "" {
+			content, err := os.ReadFile(profilePath)
+			if err != nil {
+				profilesErr = fmt.Errorf("端口配置文件读取失败: %v", err)
+				return
+			}
+			if profilesErr = mergeProfiles(profiles, content); profilesErr != nil {
+				profilesErr = fmt.Errorf("端口配置文件 %s 解析失败: %v", profilePath, profilesErr)
+				return
+			}
+		}
+	})
+	return profiles, profilesErr
+}
+
+func mergeProfiles(dst map[string]PortProfile, content []byte) error {
+	var file profileFile
+	if err := yaml.Unmarshal(content, &file); err != nil {
+		return err
+	}
+	for name, profile := range file.Profiles {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || strings.TrimSpace(profile.Ports) == "" {
+			return fmt.Errorf("端口配置 %q 缺少名称或端口", name)
+		}
+		dst[name] = profile
+	}
+	return nil
+}
+
+// 解析指定名称的端口配置，visited为正在解析中的配置，用于检测配置之间的循环引用
+func resolveProfile(name string, visited map[string]bool) (*PortSpec, error) {
+	all, err := loadProfiles()
+	if err != nil {
+		return nil, err
+	}
+
+	name = strings.ToLower(name)
+	profile, ok := all[name]
+	if !ok {
+		return nil, fmt.Errorf("未定义的端口配置 %q，可通过-list-profiles查看", name)
+	}
+
+	if visited[name] {
+		return nil, fmt.Errorf("端口配置 %q 存在循环引用", name)
+	}
+	if visited == nil {
+		visited = make(map[string]bool)
+	}
+	visited[name] = true
+	defer delete(visited, name)
+
+	spec, err := parsePortSpecVisited(profile.Ports, visited)
+	if err != nil {
+		return nil, fmt.Errorf("端口配置 %q 不合法: %v", name, err)
+	}
+	return spec, nil
+}
+
+// 输出全部端口配置
+func listProfiles() error {
+	all, err := loadProfiles()
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		profile := all[name]
+		count := "?"
+		if spec, err := resolveProfile(name, nil); err == nil {
+			count = fmt.Sprintf("%d", len(spec.TCP)+len(spec.UDP))
+		}
+		fmt.Printf("%-10s %5s个端口  %s\n", profilePrefix+name, count, profile.Description)
+		fmt.Printf("%-10s %s\n", "", profile.Ports)
+	}
+	return nil
+}
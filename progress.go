@@ -0,0 +1,280 @@
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+端口扫描进度
+1、stderr为终端时在最后一行显示进度条，输出结果前先清除进度条，输出后重新绘制，避免与结果混在一起
+2、stderr不是终端（重定向到文件或管道）时，每隔-progress-interval输出一行状态
+3、扫描过程中按回车或发送SIGUSR1信号（kill -USR1 <pid>）输出一次状态快照
+*/
+
+// 进度条宽度
+const progressBarWidth = 30
+
+// 终端下刷新进度条的间隔
+const progressRefresh = 200 * time.Millisecond
+
+type scanProgress struct {
+	total   int64
+	sent    atomic.Int64 // 已发起的连接
+	done    atomic.Int64 // 已完成的连接
+	open    atomic.Int64 // 开放端口数
+	hostsUp atomic.Int64 // 发现开放端口的主机数
+
+	start    time.Time
+	enabled  bool
+	tty      bool
+	interval time.Duration
+
+	mu         sync.Mutex // 保护终端输出与速率统计
+	barShown   bool
+	lastTime   time.Time
+	lastDone   int64
+	lastStatus time.Time
+	rate       float64
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+var (
+	activeProgress  atomic.Pointer[scanProgress]
+	statusWatchOnce sync.Once
+)
+
+// 判断文件是否为终端
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// 开始统计扫描进度，total为需要发起的连接总数，未开启-progress时只响应状态快照
+func newScanProgress(total int, cfg *Config) *scanProgress {
+	now := time.Now()
+	p := &scanProgress{
+		total:      int64(total),
+		start:      now,
+		enabled:    cfg.Progress && consoleEnabled(slog.LevelInfo),
+		tty:        isTerminal(os.Stderr),
+		interval:   cfg.ProgressInterval,
+		lastTime:   now,
+		lastStatus: now,
+		stop:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+	activeProgress.Store(p)
+	watchStatusRequests()
+	go p.loop()
+	return p
+}
+
+// 定期更新速率并刷新显示
+func (p *scanProgress) loop() {
+	defer close(p.stopped)
+
+	refresh := time.Second
+	if p.tty {
+		refresh = progressRefresh
+	}
+	ticker := time.NewTicker(refresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case now := <-ticker.C:
+			p.mu.Lock()
+			p.updateRate(now)
+			switch {
+			case !p.enabled:
+			case p.tty:
+				p.drawBar()
+			case now.Sub(p.lastStatus) >= p.interval:
+				p.lastStatus = now
+				fmt.Fprintln(os.Stderr, p.status())
+			}
+			p.mu.Unlock()
+		}
+	}
+}
+
+// 按指数滑动平均计算当前速率，调用方需持有锁
+func (p *scanProgress) updateRate(now time.Time) {
+	elapsed := now.Sub(p.lastTime).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	done := p.done.Load()
+	current := float64(done-p.lastDone) / elapsed
+	if p.rate == 0 {
+		p.rate = current
+	} else {
+		p.rate = 0.8*p.rate + 0.2*current
+	}
+	p.lastTime, p.lastDone = now, done
+}
+
+// 预计剩余时间
+func (p *scanProgress) eta() string {
+	remaining := p.total - p.done.Load()
+	if remaining <= 0 {
+		return "0s"
+	}
+	if p.rate <= 0 {
+		return "未知"
+	}
+	return time.Duration(float64(remaining) / p.rate * float64(time.Second)).Round(time.Second).String()
+}
+
+// 完成百分比
+func (p *scanProgress) percent() float64 {
+	if p.total == 0 {
+		return 100
+	}
+	return float64(p.done.Load()) * 100 / float64(p.total)
+}
+
+// 单行状态描述，用于非终端输出与状态快照
+func (p *scanProgress) status() string {
+	return fmt.Sprintf("进度 %.1f%% 已发送 %d/%d 开放端口 %d 存活主机 %d 速率 %.0f/s 已用时 %s 预计剩余 %s",
+		p.percent(), p.sent.Load(), p.total, p.open.Load(), p.hostsUp.Load(), p.rate,
+		time.Since(p.start).Round(time.Second), p.eta())
+}
+
+// 绘制进度条，调用方需持有锁
+func (p *scanProgress) drawBar() {
+	filled := int(p.percent() / 100 * progressBarWidth)
+	bar := strings.Repeat("=", filled)
+	if filled < progressBarWidth {
+		bar += ">" + strings.Repeat(" ", progressBarWidth-filled-1)
+	}
+	fmt.Fprintf(os.Stderr, "\r\033[K[%s] %5.1f%% %d/%d 开放:%d 主机:%d %.0f/s 剩余:%s",
+		bar, p.percent(), p.sent.Load(), p.total, p.open.Load(), p.hostsUp.Load(), p.rate, p.eta())
+	p.barShown = true
+}
+
+// 清除进度条，调用方需持有锁
+func (p *scanProgress) clearBar() {
+	if p.barShown {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+		p.barShown = false
+	}
+}
+
+// 输出一行，不与进度条混在同一行
+func (p *scanProgress) write(out *os.File, line string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.clearBar()
+	fmt.Fprintln(out, line)
+	if p.enabled && p.tty {
+		p.drawBar()
+	}
+}
+
+// 向stdout输出一行扫描结果
+func (p *scanProgress) println(line string) {
+	p.write(os.Stdout, line)
+}
+
+// 向stderr输出一行日志
+func (p *scanProgress) printErr(line string) {
+	p.write(os.Stderr, line)
+}
+
+// 进度的统计数据，供serve模式查询任务进度
+type progressStats struct {
+	Total   int64   `json:"total"`
+	Sent    int64   `json:"sent"`
+	Done    int64   `json:"done"`
+	Open    int64   `json:"open"`
+	HostsUp int64   `json:"hosts_up"`
+	Percent float64 `json:"percent"`
+	Rate    float64 `json:"rate"`
+	ETA     string  `json:"eta"`
+}
+
+func (p *scanProgress) stats() progressStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return progressStats{
+		Total:   p.total,
+		Sent:    p.sent.Load(),
+		Done:    p.done.Load(),
+		Open:    p.open.Load(),
+		HostsUp: p.hostsUp.Load(),
+		Percent: p.percent(),
+		Rate:    p.rate,
+		ETA:     p.eta(),
+	}
+}
+
+// 输出一次状态快照
+func (p *scanProgress) snapshot() {
+	p.mu.Lock()
+	line := p.status()
+	p.mu.Unlock()
+	p.printErr(line)
+}
+
+// 结束进度显示并输出最终状态
+func (p *scanProgress) finish() {
+	close(p.stop)
+	<-p.stopped
+	activeProgress.CompareAndSwap(p, nil)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.clearBar()
+	if p.enabled {
+		p.updateRate(time.Now())
+		fmt.Fprintln(os.Stderr, p.status())
+	}
+}
+
+// 监听状态快照请求：SIGUSR1信号，以及stdin为终端时的回车
+func watchStatusRequests() {
+	statusWatchOnce.Do(func() {
+		snapshot := func() {
+			if p := activeProgress.Load(); p != nil {
+				p.snapshot()
+			}
+		}
+
+		if len(statusSignals) > 0 {
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, statusSignals...)
+			go func() {
+				for range signals {
+					snapshot()
+				}
+			}()
+		}
+
+		if isTerminal(os.Stdin) {
+			go func() {
+				reader := bufio.NewReader(os.Stdin)
+				for {
+					if _, err := reader.ReadString('\n'); err != nil {
+						return
+					}
+					snapshot()
+				}
+			}()
+		}
+	})
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package tools
+
+import (
+	"os"
+	"syscall"
+)
+
+// 请求输出状态快照的信号
+var statusSignals = []os.Signal{syscall.SIGUSR1}
@@ -0,0 +1,6 @@
+package tools
+
+import "os"
+
+// windows没有SIGUSR1，只能通过回车请求状态快照
+var statusSignals []os.Signal
@@ -0,0 +1,323 @@
+package tools
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+代理
+1、-proxy 指定socks5://[user:pass@]host:port（socks5h相同）或 http://[user:pass@]host:port（CONNECT），多个以逗号分割组成代理链，依次经由各代理连接
+2、端口扫描、banner识别、HTTP/TLS探测、未授权访问检查与弱口令检测均经由代理连接；ping无法经由代理，主机存活探测只探测常用端口
+3、部分代理会先接受连接再断开，经由代理发现的开放端口会在-proxy-confirm时间内确认连接未被断开
+4、nmap无法经由代理进行端口与服务探测，使用代理时服务识别改为banner识别
+*/
+
+// 代理链中的一个代理
+type proxyHop struct {
+	scheme string // socks5 或 http
+	addr   string
+	user   *url.Userinfo
+}
+
+// 当前使用的代理链，为空时直接连接
+var proxyChain []proxyHop
+
+// 解析代理链，多个代理以逗号分割
+func parseProxyChain(spec string) ([]proxyHop, error) {
+	var chain []proxyHop
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		u, err := url.Parse(item)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("代理地址不合法: %s", item)
+		}
+
+		hop := proxyHop{scheme: strings.ToLower(u.Scheme), addr: u.Host, user: u.User}
+		switch hop.scheme {
+		case "socks5", "socks5h":
+			hop.scheme = "socks5"
+			if u.Port() == "" {
+				hop.addr = net.JoinHostPort(u.Hostname(), "1080")
+			}
+		case "http":
+			if u.Port() == "" {
+				hop.addr = net.JoinHostPort(u.Hostname(), "8080")
+			}
+		default:
+			return nil, fmt.Errorf("不支持的代理类型: %s，只支持socks5与http", u.Scheme)
+		}
+		chain = append(chain, hop)
+	}
+	return chain, nil
+}
+
+// 设置代理链，需要在开始扫描之前调用
+func setProxyChain(spec string) {
+	proxyChain, _ = parseProxyChain(spec)
+}
+
+// 是否经由代理连接
+func proxyEnabled() bool {
+	return len(proxyChain) > 0
+}
+
+// 建立tcp连接，配置了代理时经由代理链连接，timeout为建立连接（含代理握手）的总时间
+func dialTCP(addr string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return dialContext(ctx, "tcp", addr)
+}
+
+// 与net.Dialer.DialContext相同的签名，供http客户端与数据库驱动使用，源ip、网卡与源端口取自-S、-e、-g
+func dialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+	if !proxyEnabled() {
+		conn, err := newDialer(addr, sourcePort).DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return checkSelfConnect(conn)
+	}
+
+	// 与代理之间的连接不使用固定源端口，否则同一时间只能建立一个连接
+	conn, err := newDialer(proxyChain[0].addr, 0).DialContext(ctx, "tcp", proxyChain[0].addr)
+	if err != nil {
+		return nil, fmt.Errorf("连接代理%s失败: %w", proxyChain[0].addr, err)
+	}
+
+	// 握手期间使用ctx的截止时间，完成后清除
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	for i, hop := range proxyChain {
+		next := addr
+		if i+1 < len(proxyChain) {
+			next = proxyChain[i+1].addr
+		}
+		if conn, err = hop.connect(conn, next); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// 经由当前代理连接下一跳
+func (hop proxyHop) connect(conn net.Conn, addr string) (net.Conn, error) {
+	if hop.scheme == "http" {
+		return httpConnect(conn, hop, addr)
+	}
+	return conn, socks5Connect(conn, hop, addr)
+}
+
+// 目标拒绝连接或不可达时代理返回的错误，说明端口未开放，而不是代理本身出错
+var errProxyRefused = errors.New("代理报告目标拒绝连接")
+
+// socks5握手与CONNECT请求
+func socks5Connect(conn net.Conn, hop proxyHop, addr string) error {
+	methods := []byte{0x00}
+	if hop.user != nil {
+		methods = []byte{0x00, 0x02}
+	}
+	if _, err := conn.Write(append([]byte{0x05, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("socks5代理%s握手失败: %w", hop.addr, err)
+	}
+	switch {
+	case reply[0] != 0x05:
+		return fmt.Errorf("%s不是socks5代理", hop.addr)
+	case reply[1] == 0x02 && hop.user != nil:
+		// 用户名密码认证（RFC 1929）
+		pass, _ := hop.user.Password()
+		user := hop.user.Username()
+		request := []byte{0x01, byte(len(user))}
+		request = append(request, user...)
+		request = append(request, byte(len(pass)))
+		request = append(request, pass...)
+		if _, err := conn.Write(request); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return fmt.Errorf("socks5代理%s认证失败: %w", hop.addr, err)
+		}
+		if reply[1] != 0x00 {
+			return fmt.Errorf("socks5代理%s认证失败，用户名或密码错误", hop.addr)
+		}
+	case reply[1] != 0x00:
+		return fmt.Errorf("socks5代理%s不支持所需的认证方式", hop.addr)
+	}
+
+	host, portText, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, _ := strconv.Atoi(portText)
+
+	request := []byte{0x05, 0x01, 0x00}
+	if ip := net.ParseIP(host); ip == nil {
+		request = append(request, 0x03, byte(len(host)))
+		request = append(request, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		request = append(request, 0x01)
+		request = append(request, ip4...)
+	} else {
+		request = append(request, 0x04)
+		request = append(request, ip.To16()...)
+	}
+	request = binary.BigEndian.AppendUint16(request, uint16(port))
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	// 应答：VER REP RSV ATYP BND.ADDR BND.PORT
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("socks5代理%s连接%s失败: %w", hop.addr, addr, err)
+	}
+	if header[1] != 0x00 {
+		return socks5Error(header[1], addr)
+	}
+	var skip int
+	switch header[3] {
+	case 0x01:
+		skip = net.IPv4len + 2
+	case 0x04:
+		skip = net.IPv6len + 2
+	case 0x03:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		skip = int(length[0]) + 2
+	default:
+		return fmt.Errorf("socks5代理%s应答格式错误", hop.addr)
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip))
+	return err
+}
+
+// socks5应答码对应的错误，目标拒绝连接、不可达与超时均视为端口未开放
+func socks5Error(code byte, addr string) error {
+	reasons := map[byte]string{
+		0x01: "代理服务器错误",
+		0x02: "代理规则不允许连接",
+		0x03: "网络不可达",
+		0x04: "主机不可达",
+		0x05: "连接被拒绝",
+		0x06: "TTL超时",
+		0x07: "不支持的命令",
+		0x08: "不支持的地址类型",
+	}
+	reason, ok := reasons[code]
+	if !ok {
+		reason = fmt.Sprintf("未知错误%d", code)
+	}
+	if code >= 0x03 && code <= 0x06 {
+		return fmt.Errorf("%w: %s %s", errProxyRefused, addr, reason)
+	}
+	return fmt.Errorf("socks5代理连接%s失败: %s", addr, reason)
+}
+
+// http代理的CONNECT请求
+func httpConnect(conn net.Conn, hop proxyHop, addr string) (net.Conn, error) {
+	request := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", addr, addr)
+	if hop.user != nil {
+		pass, _ := hop.user.Password()
+		credential := base64.StdEncoding.EncodeToString([]byte(hop.user.Username() + ":" + pass))
+		request += "Proxy-Authorization: Basic " + credential + "\r\n"
+	}
+	if _, err := conn.Write([]byte(request + "\r\n")); err != nil {
+		return conn, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		return conn, fmt.Errorf("http代理%s应答错误: %w", hop.addr, err)
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode == http.StatusProxyAuthRequired:
+		return conn, fmt.Errorf("http代理%s需要认证或认证失败", hop.addr)
+	case resp.StatusCode == http.StatusBadGateway, resp.StatusCode == http.StatusServiceUnavailable,
+		resp.StatusCode == http.StatusGatewayTimeout:
+		return conn, fmt.Errorf("%w: %s %s", errProxyRefused, addr, resp.Status)
+	default:
+		return conn, fmt.Errorf("http代理%s拒绝CONNECT %s: %s", hop.addr, addr, resp.Status)
+	}
+
+	// 目标在应答后立即发送的数据可能已被读入缓冲区
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// 先读取缓冲区中剩余数据的连接
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// 代理接受连接后立即断开，端口实际未开放
+var errProxyDropped = errors.New("代理接受连接后断开")
+
+// 确认经由代理建立的连接没有被立即断开：在wait时间内收到数据或未被断开均视为端口开放
+// 未使用代理或wait为0时不进行确认
+func confirmOpen(conn net.Conn, wait time.Duration) bool {
+	if !proxyEnabled() || wait <= 0 {
+		return true
+	}
+	conn.SetReadDeadline(time.Now().Add(wait))
+	defer conn.SetReadDeadline(time.Time{})
+
+	n, err := conn.Read(make([]byte, 1))
+	if n > 0 {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// 供pq使用的拨号器
+type pqDialer struct{}
+
+func (pqDialer) Dial(network string, addr string) (net.Conn, error) {
+	return dialContext(context.Background(), network, addr)
+}
+
+func (pqDialer) DialTimeout(network string, addr string, timeout time.Duration) (net.Conn, error) {
+	return dialTCP(addr, timeout)
+}
+
+// 供mssql使用的拨号器
+type contextDialer struct{}
+
+func (contextDialer) DialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+	return dialContext(ctx, network, addr)
+}
@@ -0,0 +1,327 @@
+package tools
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testBanner = "SSH-2.0-OpenSSH_9.6\r\n"
+
+// 本地模拟的代理，记录经由该代理建立的连接数
+type testProxy struct {
+	addr  string
+	conns atomic.Int32
+}
+
+// 双向转发，任意一端关闭后结束
+func pipeConn(a net.Conn, b net.Conn) {
+	defer b.Close()
+	go io.Copy(b, a)
+	io.Copy(a, b)
+}
+
+// 本地socks5代理，user不为空时要求用户名密码认证，drop为true时应答成功后立即断开
+func startSOCKS5(t *testing.T, user string, pass string, drop bool) *testProxy {
+	t.Helper()
+	proxy := &testProxy{}
+	port := fakeTCPServer(t, func(conn net.Conn) {
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(conn, header); err != nil || header[0] != 0x05 {
+			return
+		}
+		methods := make([]byte, header[1])
+		if _, err := io.ReadFull(conn, methods); err != nil {
+			return
+		}
+		if user != "" {
+			if !strings.ContainsRune(string(methods), 0x02) {
+				conn.Write([]byte{0x05, 0xFF})
+				return
+			}
+			conn.Write([]byte{0x05, 0x02})
+			// RFC 1929：VER ULEN UNAME PLEN PASSWD
+			readField := func() string {
+				size := make([]byte, 1)
+				io.ReadFull(conn, size)
+				field := make([]byte, size[0])
+				io.ReadFull(conn, field)
+				return string(field)
+			}
+			if _, err := io.ReadFull(conn, make([]byte, 1)); err != nil {
+				return
+			}
+			gotUser, gotPass := readField(), readField()
+			if gotUser != user || gotPass != pass {
+				conn.Write([]byte{0x01, 0x01})
+				return
+			}
+			conn.Write([]byte{0x01, 0x00})
+		} else {
+			conn.Write([]byte{0x05, 0x00})
+		}
+
+		request := make([]byte, 4)
+		if _, err := io.ReadFull(conn, request); err != nil {
+			return
+		}
+		var host string
+		switch request[3] {
+		case 0x01:
+			ip := make([]byte, net.IPv4len)
+			io.ReadFull(conn, ip)
+			host = net.IP(ip).String()
+		case 0x04:
+			ip := make([]byte, net.IPv6len)
+			io.ReadFull(conn, ip)
+			host = net.IP(ip).String()
+		case 0x03:
+			size := make([]byte, 1)
+			io.ReadFull(conn, size)
+			name := make([]byte, size[0])
+			io.ReadFull(conn, name)
+			host = string(name)
+		}
+		portBytes := make([]byte, 2)
+		io.ReadFull(conn, portBytes)
+
+		target, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(portBytes)))), time.Second)
+		if err != nil {
+			conn.Write([]byte{0x05, 0x05, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		proxy.conns.Add(1)
+		conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 127, 0, 0, 1, 0, 0})
+		if drop {
+			target.Close()
+			return
+		}
+		pipeConn(conn, target)
+	})
+	proxy.addr = net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	return proxy
+}
+
+// 本地http CONNECT代理，user不为空时要求Basic认证
+func startHTTPConnect(t *testing.T, user string, pass string) *testProxy {
+	t.Helper()
+	proxy := &testProxy{}
+	port := fakeTCPServer(t, func(conn net.Conn) {
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+		if req.Method != http.MethodConnect {
+			io.WriteString(conn, "HTTP/1.1 405 Method Not Allowed\r\n\r\n")
+			return
+		}
+		credential := "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+pass))
+		if user != "" && req.Header.Get("Proxy-Authorization") != credential {
+			io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\nProxy-Authenticate: Basic\r\n\r\n")
+			return
+		}
+
+		target, err := net.DialTimeout("tcp", req.Host, time.Second)
+		if err != nil {
+			io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+			return
+		}
+		proxy.conns.Add(1)
+		io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		pipeConn(conn, target)
+	})
+	proxy.addr = net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	return proxy
+}
+
+// 连接后发送banner的目标
+func startBannerTarget(t *testing.T) string {
+	t.Helper()
+	port := fakeTCPServer(t, func(conn net.Conn) {
+		io.WriteString(conn, testBanner)
+		io.Copy(io.Discard, conn)
+	})
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+}
+
+// 未监听的本地端口
+func closedTarget(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	return addr
+}
+
+// 使用指定的代理链，测试结束后恢复直连
+func useProxy(t *testing.T, spec string) {
+	t.Helper()
+	chain, err := parseProxyChain(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyChain = chain
+	t.Cleanup(func() { proxyChain = nil })
+}
+
+// 经由当前代理链连接目标并读取banner
+func dialBanner(addr string) (string, error) {
+	conn, err := dialTCP(addr, 2*time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	return bufio.NewReader(conn).ReadString('\n')
+}
+
+func TestSOCKS5Auth(t *testing.T) {
+	target := startBannerTarget(t)
+	proxy := startSOCKS5(t, "scan", "p@ss:word", false)
+
+	cases := []struct {
+		name    string
+		spec    string
+		wantErr string
+	}{
+		{"认证成功", fmt.Sprintf("socks5://scan:%s@%s", "p%40ss%3Aword", proxy.addr), ""},
+		{"密码错误", "socks5://scan:wrong@" + proxy.addr, "用户名或密码错误"},
+		{"未提供认证", "socks5h://" + proxy.addr, "不支持所需的认证方式"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			useProxy(t, tc.spec)
+			banner, err := dialBanner(target)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) || errors.Is(err, errProxyRefused) {
+					t.Fatalf("错误为 %v，期望包含 %s", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil || banner != testBanner {
+				t.Fatalf("读取banner失败: %q %v", banner, err)
+			}
+		})
+	}
+}
+
+func TestHTTPConnectAuth(t *testing.T) {
+	target := startBannerTarget(t)
+	proxy := startHTTPConnect(t, "scan", "secret")
+
+	useProxy(t, "http://scan:secret@"+proxy.addr)
+	if banner, err := dialBanner(target); err != nil || banner != testBanner {
+		t.Fatalf("读取banner失败: %q %v", banner, err)
+	}
+
+	useProxy(t, "http://scan:wrong@"+proxy.addr)
+	if _, err := dialBanner(target); err == nil || !strings.Contains(err.Error(), "需要认证") {
+		t.Fatalf("错误为 %v，期望认证失败", err)
+	}
+}
+
+// 两跳代理链，两种代理的先后顺序均可
+func TestProxyChain(t *testing.T) {
+	target := startBannerTarget(t)
+	socks := startSOCKS5(t, "u", "p", false)
+	connect := startHTTPConnect(t, "scan", "secret")
+
+	chains := []string{
+		fmt.Sprintf("socks5://u:p@%s,http://scan:secret@%s", socks.addr, connect.addr),
+		fmt.Sprintf("http://scan:secret@%s, socks5://u:p@%s", connect.addr, socks.addr),
+	}
+	for i, spec := range chains {
+		useProxy(t, spec)
+		if banner, err := dialBanner(target); err != nil || banner != testBanner {
+			t.Fatalf("代理链%s读取banner失败: %q %v", spec, banner, err)
+		}
+		if socks.conns.Load() != int32(i+1) || connect.conns.Load() != int32(i+1) {
+			t.Fatalf("代理链%s未经过全部代理: socks5 %d次，http %d次", spec, socks.conns.Load(), connect.conns.Load())
+		}
+	}
+}
+
+// 代理报告目标拒绝连接时视为端口关闭，而不是代理出错
+func TestProxyRefused(t *testing.T) {
+	target := closedTarget(t)
+	socks := startSOCKS5(t, "", "", false)
+	connect := startHTTPConnect(t, "", "")
+
+	for _, spec := range []string{"socks5://" + socks.addr, "http://" + connect.addr} {
+		useProxy(t, spec)
+		_, err := dialTCP(target, 2*time.Second)
+		if !errors.Is(err, errProxyRefused) {
+			t.Fatalf("经由%s连接未开放端口，错误为 %v，期望errProxyRefused", spec, err)
+		}
+		if result, errType := classifyDialError(err); result != "closed" || errType != "proxy_refused" {
+			t.Fatalf("经由%s连接未开放端口，统计为 %s/%s", spec, result, errType)
+		}
+	}
+}
+
+// 代理接受连接后立即断开时，端口扫描不应报告端口开放
+func TestProxyConfirmOpen(t *testing.T) {
+	oldOutput := textOutputPath
+	setTextOutput(filepath.Join(t.TempDir(), "result.txt"))
+	t.Cleanup(func() { textOutputPath = oldOutput })
+
+	// 不发送banner、保持连接的目标，确认时应等到超时
+	silentPort := fakeTCPServer(t, func(conn net.Conn) { io.Copy(io.Discard, conn) })
+	silent := net.JoinHostPort("127.0.0.1", strconv.Itoa(silentPort))
+
+	cfg := defaultConfig()
+	cfg.Progress = false
+	cfg.Threads = 10
+	cfg.ProxyConfirm = 300 * time.Millisecond
+	scan := func() []string {
+		spec := &PortSpec{TCP: []int{silentPort}}
+		groups := []portGroup{{ips: []string{"127.0.0.1"}, spec: spec, ports: portStrings(spec.TCP)}}
+		return openPort(context.Background(), groups, cfg)["127.0.0.1"]
+	}
+
+	cases := []struct {
+		name string
+		drop bool
+		open bool
+	}{
+		{"保持连接", false, true},
+		{"接受后断开", true, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			useProxy(t, "socks5://"+startSOCKS5(t, "", "", tc.drop).addr)
+
+			conn, err := dialTCP(silent, 2*time.Second)
+			if err != nil {
+				t.Fatalf("经由代理连接失败: %v", err)
+			}
+			confirmed := confirmOpen(conn, cfg.ProxyConfirm)
+			conn.Close()
+			if confirmed != tc.open {
+				t.Fatalf("confirmOpen = %v，期望 %v", confirmed, tc.open)
+			}
+
+			if ports := scan(); (len(ports) == 1) != tc.open {
+				t.Fatalf("端口扫描结果为 %v，期望开放=%v", ports, tc.open)
+			}
+			if result, errType := classifyDialError(errProxyDropped); result != "closed" || errType != "proxy_dropped" {
+				t.Fatalf("errProxyDropped统计为 %s/%s", result, errType)
+			}
+		})
+	}
+}
@@ -0,0 +1,458 @@
+package tools
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rc4"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"golang.org/x/crypto/md4"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf16"
+)
+
+// RDP弱口令检测：通过CredSSP(NLA)完成NTLM认证，认证结果确定后即断开连接，
+// 不会发送凭据(authInfo)，因此不会在目标上创建登录会话
+
+// RDP协商请求中的安全协议
+const (
+	rdpProtocolSSL    = 0x1
+	rdpProtocolHybrid = 0x2
+)
+
+// 客户端声明的CredSSP版本，5及以上版本使用带随机数的公钥哈希防止中间人
+const credSSPVersion = 6
+
+// TSRequest CredSSP消息
+type tsRequest struct {
+	Version     int         `asn1:"explicit,tag:0"`
+	NegoTokens  []negoToken `asn1:"explicit,optional,tag:1"`
+	AuthInfo    []byte      `asn1:"explicit,optional,tag:2"`
+	PubKeyAuth  []byte      `asn1:"explicit,optional,tag:3"`
+	ErrorCode   int64       `asn1:"explicit,optional,tag:4"`
+	ClientNonce []byte      `asn1:"explicit,optional,tag:5"`
+}
+
+type negoToken struct {
+	Token []byte `asn1:"explicit,tag:0"`
+}
+
+func rdpLogin(t bruteTarget, user string, pass string) (bool, error) {
+	conn, err := t.dial()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	if err := rdpNegotiate(conn); err != nil {
+		return false, err
+	}
+
+	// 较早的系统只支持TLS1.0
+	tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true, MinVersion: tls.VersionTLS10})
+	if err := tlsConn.Handshake(); err != nil {
+		return false, err
+	}
+	publicKey, err := subjectPublicKey(tlsConn.ConnectionState().PeerCertificates[0])
+	if err != nil {
+		return false, err
+	}
+
+	domain, name := splitDomainUser(user)
+	client := &ntlmClient{user: name, password: pass, domain: domain}
+
+	if err := writeTSRequest(tlsConn, tsRequest{Version: credSSPVersion, NegoTokens: []negoToken{{client.negotiate()}}}); err != nil {
+		return false, err
+	}
+	challenge, err := readTSRequest(tlsConn)
+	if err != nil {
+		return false, err
+	}
+	if len(challenge.NegoTokens) == 0 {
+		return false, errors.New("无效的CredSSP响应")
+	}
+
+	authenticate, err := client.authenticate(challenge.NegoTokens[0].Token)
+	if err != nil {
+		return false, err
+	}
+	request := tsRequest{Version: credSSPVersion, NegoTokens: []negoToken{{authenticate}}}
+	if challenge.Version >= 5 {
+		request.ClientNonce = make([]byte, 32)
+		rand.Read(request.ClientNonce)
+		hash := sha256.New()
+		hash.Write([]byte("CredSSP Client-To-Server Binding Hash\x00"))
+		hash.Write(request.ClientNonce)
+		hash.Write(publicKey)
+		request.PubKeyAuth = client.seal(hash.Sum(nil))
+	} else {
+		request.PubKeyAuth = client.seal(publicKey)
+	}
+	if err := writeTSRequest(tlsConn, request); err != nil {
+		return false, err
+	}
+
+	// 认证成功时服务端返回自己的pubKeyAuth，失败时返回错误码，较早的系统直接断开连接
+	response, err := readTSRequest(tlsConn)
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) {
+			return false, nil
+		}
+		return false, err
+	}
+	if len(response.PubKeyAuth) > 0 {
+		return true, nil
+	}
+	if response.ErrorCode != 0 {
+		return ntStatusResult(uint32(response.ErrorCode))
+	}
+	return false, nil
+}
+
+// 发送X.224连接请求，确认服务端使用CredSSP(NLA)
+func rdpNegotiate(conn net.Conn) error {
+	// TPKT头、X.224连接请求与RDP_NEG_REQ
+	request := []byte{
+		0x03, 0x00, 0x00, 0x13,
+		0x0e, 0xe0, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x01, 0x00, 0x08, 0x00, rdpProtocolSSL | rdpProtocolHybrid, 0x00, 0x00, 0x00,
+	}
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	length := int(binary.BigEndian.Uint16(header[2:]))
+	if header[0] != 0x03 || length < 11 {
+		return errors.New("无效的RDP响应")
+	}
+	response := make([]byte, length-4)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return err
+	}
+
+	// X.224连接确认后为RDP_NEG_RSP(0x02)或RDP_NEG_FAILURE(0x03)
+	if response[1] != 0xd0 || len(response) < 15 {
+		return &bruteStopError{"服务端不支持安全协议协商，无法进行弱口令检测"}
+	}
+	negotiation := response[7:]
+	if negotiation[0] != 0x02 || binary.LittleEndian.Uint32(negotiation[4:])&rdpProtocolHybrid == 0 {
+		return &bruteStopError{"未启用NLA认证，无法进行弱口令检测"}
+	}
+	return nil
+}
+
+// 证书中的公钥(SubjectPublicKey)，用于CredSSP的公钥绑定
+func subjectPublicKey(cert *x509.Certificate) ([]byte, error) {
+	var info struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(cert.RawSubjectPublicKeyInfo, &info); err != nil {
+		return nil, err
+	}
+	return info.PublicKey.Bytes, nil
+}
+
+func writeTSRequest(conn net.Conn, request tsRequest) error {
+	content, err := asn1.Marshal(request)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(content)
+	return err
+}
+
+// 读取一个DER编码的TSRequest
+func readTSRequest(conn net.Conn) (*tsRequest, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	if header[0] != 0x30 {
+		return nil, errors.New("无效的CredSSP响应")
+	}
+
+	length := int(header[1])
+	if length&0x80 != 0 {
+		size := length & 0x7f
+		if size == 0 || size > 3 {
+			return nil, errors.New("无效的CredSSP响应长度")
+		}
+		lengthBytes := make([]byte, size)
+		if _, err := io.ReadFull(conn, lengthBytes); err != nil {
+			return nil, err
+		}
+		header = append(header, lengthBytes...)
+		length = 0
+		for _, b := range lengthBytes {
+			length = length<<8 | int(b)
+		}
+	}
+	if length > maxBodySize {
+		return nil, errors.New("无效的CredSSP响应长度")
+	}
+
+	content := make([]byte, length)
+	if _, err := io.ReadFull(conn, content); err != nil {
+		return nil, err
+	}
+
+	var request tsRequest
+	if _, err := asn1.Unmarshal(append(header, content...), &request); err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// NTLM协商标志
+const (
+	ntlmNegotiateUnicode         = 0x00000001
+	ntlmRequestTarget            = 0x00000004
+	ntlmNegotiateSign            = 0x00000010
+	ntlmNegotiateSeal            = 0x00000020
+	ntlmNegotiateNTLM            = 0x00000200
+	ntlmNegotiateAlwaysSign      = 0x00008000
+	ntlmNegotiateExtendedSession = 0x00080000
+	ntlmNegotiateVersion         = 0x02000000
+	ntlmNegotiate128             = 0x20000000
+	ntlmNegotiateKeyExch         = 0x40000000
+	ntlmNegotiate56              = 0x80000000
+
+	ntlmNegotiateFlags = ntlmNegotiateUnicode | ntlmRequestTarget | ntlmNegotiateSign | ntlmNegotiateSeal |
+		ntlmNegotiateNTLM | ntlmNegotiateAlwaysSign | ntlmNegotiateExtendedSession | ntlmNegotiateVersion |
+		ntlmNegotiate128 | ntlmNegotiateKeyExch | ntlmNegotiate56
+)
+
+// NTLM消息中的AV_PAIR类型
+const (
+	ntlmAvEOL       = 0x0000
+	ntlmAvFlags     = 0x0006
+	ntlmAvTimestamp = 0x0007
+)
+
+// 客户端版本：Windows 10 build 19041，NTLMSSP_REVISION_W2K3
+var ntlmVersion = []byte{10, 0, 0x61, 0x4a, 0, 0, 0, 0x0f}
+
+// NTLMv2客户端，只实现CredSSP需要的认证与加密
+type ntlmClient struct {
+	user     string
+	password string
+	domain   string
+
+	negotiateMessage []byte
+	flags            uint32
+	signingKey       []byte
+	sealingHandle    *rc4.Cipher
+	sequence         uint32
+}
+
+// 生成NEGOTIATE_MESSAGE
+func (c *ntlmClient) negotiate() []byte {
+	message := make([]byte, 40)
+	copy(message, "NTLMSSP\x00")
+	binary.LittleEndian.PutUint32(message[8:], 1)
+	binary.LittleEndian.PutUint32(message[12:], ntlmNegotiateFlags)
+	copy(message[32:], ntlmVersion)
+	c.negotiateMessage = message
+	return message
+}
+
+// 根据CHALLENGE_MESSAGE生成AUTHENTICATE_MESSAGE，同时计算后续加密使用的密钥
+func (c *ntlmClient) authenticate(challenge []byte) ([]byte, error) {
+	if len(challenge) < 48 || !bytes.HasPrefix(challenge, []byte("NTLMSSP\x00")) || binary.LittleEndian.Uint32(challenge[8:]) != 2 {
+		return nil, errors.New("无效的NTLM CHALLENGE消息")
+	}
+	c.flags = binary.LittleEndian.Uint32(challenge[20:])
+	serverChallenge := challenge[24:32]
+	infoLength := int(binary.LittleEndian.Uint16(challenge[40:]))
+	infoOffset := int(binary.LittleEndian.Uint32(challenge[44:]))
+	if infoOffset+infoLength > len(challenge) {
+		return nil, errors.New("无效的NTLM CHALLENGE消息")
+	}
+	targetInfo, timestamp := ntlmTargetInfo(challenge[infoOffset : infoOffset+infoLength])
+
+	clientChallenge := make([]byte, 8)
+	rand.Read(clientChallenge)
+	responseKey := ntowfv2(c.user, c.password, c.domain)
+	ntResponse := ntlmv2Response(responseKey, serverChallenge, clientChallenge, timestamp, targetInfo)
+	ntProof := ntResponse[:16]
+
+	// 服务端提供时间戳时使用MIC，LMv2响应置零
+	lmResponse := make([]byte, 24)
+	if timestamp == nil {
+		copy(lmResponse, hmacMD5(responseKey, serverChallenge, clientChallenge))
+		copy(lmResponse[16:], clientChallenge)
+	}
+
+	// NTLMv2的KeyExchangeKey即SessionBaseKey
+	keyExchangeKey := hmacMD5(responseKey, ntProof)
+	exportedSessionKey := keyExchangeKey
+	var encryptedSessionKey []byte
+	if c.flags&ntlmNegotiateKeyExch != 0 {
+		exportedSessionKey = make([]byte, 16)
+		rand.Read(exportedSessionKey)
+		encryptedSessionKey = make([]byte, 16)
+		cipher, _ := rc4.NewCipher(keyExchangeKey)
+		cipher.XORKeyStream(encryptedSessionKey, exportedSessionKey)
+	}
+
+	// 消息头88字节：固定字段64字节、版本8字节、MIC 16字节
+	const headerSize = 88
+	fields := [][]byte{lmResponse, ntResponse, encodeUTF16(c.domain), encodeUTF16(c.user), nil, encryptedSessionKey}
+	message := make([]byte, headerSize)
+	copy(message, "NTLMSSP\x00")
+	binary.LittleEndian.PutUint32(message[8:], 3)
+	offset := headerSize
+	for i, field := range fields {
+		binary.LittleEndian.PutUint16(message[12+i*8:], uint16(len(field)))
+		binary.LittleEndian.PutUint16(message[14+i*8:], uint16(len(field)))
+		binary.LittleEndian.PutUint32(message[16+i*8:], uint32(offset))
+		offset += len(field)
+	}
+	binary.LittleEndian.PutUint32(message[60:], c.flags)
+	copy(message[64:], ntlmVersion)
+	for _, field := range fields {
+		message = append(message, field...)
+	}
+	if timestamp != nil {
+		copy(message[72:], hmacMD5(exportedSessionKey, c.negotiateMessage, challenge, message))
+	}
+
+	c.signingKey = md5Sum(exportedSessionKey, []byte("session key to client-to-server signing key magic constant\x00"))
+	sealingKey := exportedSessionKey
+	switch {
+	case c.flags&ntlmNegotiate128 != 0:
+	case c.flags&ntlmNegotiate56 != 0:
+		sealingKey = sealingKey[:7]
+	default:
+		sealingKey = sealingKey[:5]
+	}
+	sealingKey = md5Sum(sealingKey, []byte("session key to client-to-server sealing key magic constant\x00"))
+	c.sealingHandle, _ = rc4.NewCipher(sealingKey)
+	return message, nil
+}
+
+// 加密并签名，输出格式为16字节签名加密文
+func (c *ntlmClient) seal(message []byte) []byte {
+	sealed := make([]byte, len(message))
+	c.sealingHandle.XORKeyStream(sealed, message)
+
+	sequence := make([]byte, 4)
+	binary.LittleEndian.PutUint32(sequence, c.sequence)
+	checksum := hmacMD5(c.signingKey, sequence, message)[:8]
+	if c.flags&ntlmNegotiateKeyExch != 0 {
+		c.sealingHandle.XORKeyStream(checksum, checksum)
+	}
+	c.sequence++
+
+	signature := make([]byte, 0, 16+len(sealed))
+	signature = append(signature, 1, 0, 0, 0)
+	signature = append(signature, checksum...)
+	signature = append(signature, sequence...)
+	return append(signature, sealed...)
+}
+
+// 在服务端的TargetInfo中声明MIC，返回新的TargetInfo与服务端时间戳（没有时返回nil）
+func ntlmTargetInfo(info []byte) ([]byte, []byte) {
+	var result []byte
+	var timestamp []byte
+	flags := uint32(0)
+	for len(info) >= 4 {
+		id := binary.LittleEndian.Uint16(info)
+		length := int(binary.LittleEndian.Uint16(info[2:]))
+		if id == ntlmAvEOL || 4+length > len(info) {
+			break
+		}
+		value := info[4 : 4+length]
+		switch id {
+		case ntlmAvFlags:
+			if length == 4 {
+				flags = binary.LittleEndian.Uint32(value)
+			}
+		case ntlmAvTimestamp:
+			timestamp = value
+			fallthrough
+		default:
+			result = append(result, info[:4+length]...)
+		}
+		info = info[4+length:]
+	}
+
+	if timestamp != nil {
+		flags |= 0x2 // MIC
+	}
+	if flags != 0 {
+		pair := make([]byte, 8)
+		binary.LittleEndian.PutUint16(pair, ntlmAvFlags)
+		binary.LittleEndian.PutUint16(pair[2:], 4)
+		binary.LittleEndian.PutUint32(pair[4:], flags)
+		result = append(result, pair...)
+	}
+	return append(result, 0, 0, 0, 0), timestamp
+}
+
+// NTOWFv2 = HMAC_MD5(MD4(UNICODE(password)), UNICODE(UPPER(user) + domain))
+func ntowfv2(user string, password string, domain string) []byte {
+	hash := md4.New()
+	hash.Write(encodeUTF16(password))
+	return hmacMD5(hash.Sum(nil), encodeUTF16(strings.ToUpper(user)+domain))
+}
+
+// NTLMv2响应：NTProofStr加客户端blob
+func ntlmv2Response(responseKey []byte, serverChallenge []byte, clientChallenge []byte, timestamp []byte, targetInfo []byte) []byte {
+	if timestamp == nil {
+		// 1601年1月1日起的100纳秒数
+		timestamp = make([]byte, 8)
+		binary.LittleEndian.PutUint64(timestamp, uint64(time.Now().UnixNano()/100+116444736000000000))
+	}
+
+	blob := []byte{1, 1, 0, 0, 0, 0, 0, 0}
+	blob = append(blob, timestamp...)
+	blob = append(blob, clientChallenge...)
+	blob = append(blob, 0, 0, 0, 0)
+	blob = append(blob, targetInfo...)
+	blob = append(blob, 0, 0, 0, 0)
+
+	return append(hmacMD5(responseKey, serverChallenge, blob), blob...)
+}
+
+func hmacMD5(key []byte, data ...[]byte) []byte {
+	mac := hmac.New(md5.New, key)
+	for _, d := range data {
+		mac.Write(d)
+	}
+	return mac.Sum(nil)
+}
+
+func md5Sum(data ...[]byte) []byte {
+	hash := md5.New()
+	for _, d := range data {
+		hash.Write(d)
+	}
+	return hash.Sum(nil)
+}
+
+// 转换为UTF-16LE编码
+func encodeUTF16(s string) []byte {
+	codes := utf16.Encode([]rune(s))
+	buf := make([]byte, len(codes)*2)
+	for i, code := range codes {
+		binary.LittleEndian.PutUint16(buf[i*2:], code)
+	}
+	return buf
+}
@@ -0,0 +1,530 @@
+package tools
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+/*
+REST API（serve子命令）
+1、POST   /api/jobs                 提交扫描任务，json格式：
+          {"targets": ["10.1.1.0/24", "10.1.2.3:80,443"], "ports": "top100", "exclude_ports": "", "tags": "bu=finance",
+           "options": {"detector": "banner", "threads": 500, "http_probe": true}}
+          targets每项支持-ip的全部格式以及 ip:端口，ports为空时使用serve的-p，options的键名与配置文件相同，只允许jobOptionKeys中的扫描参数
+2、GET    /api/jobs                 任务列表
+   GET    /api/jobs/{id}            任务状态与进度
+   GET    /api/jobs/{id}/results    任务结果，?format=json|xlsx|csv，?tag= 按标签过滤
+   DELETE /api/jobs/{id}            取消排队中或运行中的任务
+3、请求需携带 Authorization: Bearer <token> 或 X-API-Token: <token>
+4、任务按提交顺序依次执行，排队的任务超过-queue-size时拒绝提交；端口扫描、服务识别与各项探测与scan子命令相同
+5、任务结果保存在内存中，最多保留-history个已结束的任务；指定-db时同时记录到数据库
+*/
+
+// 任务状态
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobDone      = "done"
+	jobCancelled = "cancelled"
+)
+
+// 提交任务时允许覆盖的配置项，其余配置（代理、源地址、字典、漏洞库等）为全局设置，只能在启动serve时指定
+var jobOptionKeys = map[string]bool{
+	"threads": true, "timeout": true, "rate": true, "proxy_confirm": true,
+	"detector": true, "nmap_timeout": true,
+	"http_probe": true, "http_timeout": true, "http_max_redirects": true,
+	"fingerprint": true, "unauth_check": true,
+	"brute": true, "brute_services": true, "brute_threads": true, "brute_timeout": true, "brute_delay": true,
+	"brute_lockout_attempts": true, "brute_lockout_wait": true, "brute_stop_on_success": true, "brute_show_password": true,
+	"vuln_match": true, "vuln_confidence": true,
+	"tls_inspect": true, "tls_ciphers": true, "tls_timeout": true,
+}
+
+// 提交任务的请求
+type jobRequest struct {
+	Targets      []string       `json:"targets"`
+	Ports        string         `json:"ports"`
+	ExcludePorts string         `json:"exclude_ports"`
+	Tags         string         `json:"tags"`
+	Options      map[string]any `json:"options"`
+}
+
+// scanJob 扫描任务及其状态
+type scanJob struct {
+	ID          int            `json:"id"`
+	Status      string         `json:"status"`
+	Stage       string         `json:"stage,omitempty"`
+	Targets     []string       `json:"targets"`
+	Ports       string         `json:"ports"`
+	Hosts       int            `json:"hosts"`
+	CreatedAt   time.Time      `json:"created_at"`
+	StartedAt   *time.Time     `json:"started_at,omitempty"`
+	FinishedAt  *time.Time     `json:"finished_at,omitempty"`
+	Progress    *progressStats `json:"progress,omitempty"`
+	ResultCount int            `json:"result_count"`
+	Error       string         `json:"error,omitempty"`
+
+	cfg     *Config
+	targets []Target
+	cancel  context.CancelFunc
+	report  *ScanReport
+}
+
+// apiServer 接收任务并依次执行
+type apiServer struct {
+	mu        sync.Mutex
+	cfg       *Config
+	token     string
+	jobs      map[int]*scanJob
+	nextID    int
+	queue     []*scanJob // 排队中的任务，取消的任务直接移出
+	queueSize int
+	ready     *sync.Cond // 有新任务排队或服务停止时通知执行循环
+	history   int
+}
+
+func newAPIServer(cfg *Config, token string, queueSize int, history int) *apiServer {
+	s := &apiServer{
+		cfg:       cfg,
+		token:     token,
+		jobs:      make(map[int]*scanJob),
+		nextID:    1,
+		queueSize: queueSize,
+		history:   history,
+	}
+	s.ready = sync.NewCond(&s.mu)
+	return s
+}
+
+func (s *apiServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/jobs", s.authorize(s.handleSubmit))
+	mux.HandleFunc("GET /api/jobs", s.authorize(s.handleList))
+	mux.HandleFunc("GET /api/jobs/{id}", s.authorize(s.handleStatus))
+	mux.HandleFunc("GET /api/jobs/{id}/results", s.authorize(s.handleResults))
+	mux.HandleFunc("DELETE /api/jobs/{id}", s.authorize(s.handleCancel))
+	return mux
+}
+
+// 校验API口令
+func (s *apiServer) authorize(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("X-API-Token")
+		if token == "" {
+			token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			slog.Warn("拒绝口令错误的请求", "remote", r.RemoteAddr, "path", r.URL.Path)
+			writeError(w, http.StatusUnauthorized, "口令错误")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// 以json格式返回错误信息
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+func (s *apiServer) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	var req jobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "请求格式错误: "+err.Error())
+		return
+	}
+
+	job, err := s.newJob(&req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.queue) >= s.queueSize {
+		writeError(w, http.StatusTooManyRequests, fmt.Sprintf("排队的任务已达上限%d，请稍后再提交", s.queueSize))
+		return
+	}
+	s.queue = append(s.queue, job)
+	s.ready.Signal()
+	job.ID = s.nextID
+	s.nextID++
+	s.jobs[job.ID] = job
+	slog.Info(fmt.Sprintf("任务%d已提交：%d个ip，端口 %s", job.ID, job.Hosts, job.Ports))
+
+	w.Header().Set("Location", "/api/jobs/"+strconv.Itoa(job.ID))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// 根据请求生成任务的配置与目标，参数有误时返回错误
+func (s *apiServer) newJob(req *jobRequest) (*scanJob, error) {
+	cfg, err := s.jobConfig(req)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.Targets) == 0 {
+		return nil, errors.New("未指定targets")
+	}
+
+	portSpec, err := parsePortSpec(cfg.Ports)
+	if err != nil {
+		return nil, wrapError(ErrInvalidPortSpec, err)
+	}
+	excludeSpec, err := resolveExcludePorts(cfg)
+	if err != nil {
+		return nil, err
+	}
+	portSpec.Exclude(excludeSpec)
+	if portSpec.Empty() {
+		return nil, fmt.Errorf("%w: 排除后没有需要扫描的端口", ErrInvalidPortSpec)
+	}
+
+	parser := newTargetParser(portSpec, excludeSpec)
+	for i, entry := range req.Targets {
+		if err := parser.add(strings.TrimSpace(entry), "", nil); err != nil {
+			return nil, fmt.Errorf("targets第%d项: %w", i+1, err)
+		}
+	}
+	if len(parser.targets) == 0 {
+		return nil, errors.New("targets中没有任何目标")
+	}
+
+	if err := prepareProbes(cfg); err != nil {
+		return nil, err
+	}
+
+	return &scanJob{
+		Status:    jobQueued,
+		Targets:   req.Targets,
+		Ports:     cfg.Ports,
+		Hosts:     len(parser.targets),
+		CreatedAt: time.Now(),
+		cfg:       cfg,
+		targets:   parser.targets,
+	}, nil
+}
+
+// 在serve的配置上应用任务的端口、标签与扫描参数
+func (s *apiServer) jobConfig(req *jobRequest) (*Config, error) {
+	cfg := *s.cfg
+	cfg.Progress = false
+	if req.Ports != "" {
+		cfg.Ports = req.Ports
+	}
+	if req.ExcludePorts != "" {
+		cfg.ExcludePorts = req.ExcludePorts
+	}
+	if req.Tags != "" {
+		cfg.Tags = strings.Join(mergeTags(splitTags(cfg.Tags), splitTags(req.Tags)), ",")
+	}
+
+	if len(req.Options) > 0 {
+		var keys []string
+		for key := range req.Options {
+			if !jobOptionKeys[key] {
+				keys = append(keys, key)
+			}
+		}
+		if len(keys) > 0 {
+			sort.Strings(keys)
+			return nil, fmt.Errorf("不支持在任务中设置的选项: %s", strings.Join(keys, ", "))
+		}
+
+		// 选项的键名与配置文件相同，通过yaml解析到配置中，时长可使用"2s"等格式
+		content, err := yaml.Marshal(req.Options)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(content, &cfg); err != nil {
+			return nil, fmt.Errorf("options解析失败: %v", err)
+		}
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func (s *apiServer) handleList(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	jobs := make([]*scanJob, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, s.jobStatus(job))
+	}
+	s.mu.Unlock()
+
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID < jobs[j].ID })
+	writeJSON(w, jobs)
+}
+
+func (s *apiServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	job, ok := s.lookup(w, r)
+	var status *scanJob
+	if ok {
+		status = s.jobStatus(job)
+	}
+	s.mu.Unlock()
+
+	if ok {
+		writeJSON(w, status)
+	}
+}
+
+// 查找路径中指定的任务，不存在时返回404，调用时需持有锁
+func (s *apiServer) lookup(w http.ResponseWriter, r *http.Request) (*scanJob, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "任务不存在")
+		return nil, false
+	}
+	job, ok := s.jobs[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "任务不存在")
+		return nil, false
+	}
+	return job, true
+}
+
+// 任务状态的副本，运行中的任务附带当前阶段与端口扫描进度，调用时需持有锁
+func (s *apiServer) jobStatus(job *scanJob) *scanJob {
+	status := *job
+	if job.Status == jobRunning {
+		status.Stage, _ = currentSection.Load().(string)
+		if p := activeProgress.Load(); p != nil {
+			stats := p.stats()
+			status.Progress = &stats
+		}
+	}
+	return &status
+}
+
+func (s *apiServer) handleResults(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	job, ok := s.lookup(w, r)
+	var report *ScanReport
+	if ok {
+		report = job.report
+	}
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+	if report == nil {
+		writeError(w, http.StatusConflict, "任务尚未结束")
+		return
+	}
+
+	filtered := *report
+	filtered.Results = filterByTags(report.Results, r.URL.Query().Get("tag"))
+
+	format := r.URL.Query().Get("format")
+	if format == "" || format == formatJSON {
+		writeJSON(w, filtered)
+		return
+	}
+	if format != formatExcel && format != formatCSV {
+		writeError(w, http.StatusBadRequest, "不支持的结果格式: "+format)
+		return
+	}
+
+	// excel与csv先导出到临时文件
+	dir, err := os.MkdirTemp("", "miao-job-")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	name := fmt.Sprintf("portResult-job%d.%s", job.ID, format)
+	filename := filepath.Join(dir, name)
+	if err := exportReport(&filtered, format, filename); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	http.ServeFile(w, r, filename)
+}
+
+func (s *apiServer) handleCancel(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	switch job.Status {
+	case jobQueued:
+		// 移出队列，不再占用排队名额
+		s.queue = slices.DeleteFunc(s.queue, func(queued *scanJob) bool { return queued == job })
+		now := time.Now()
+		job.Status, job.FinishedAt = jobCancelled, &now
+		s.pruneHistory()
+	case jobRunning:
+		job.cancel()
+	default:
+		writeError(w, http.StatusConflict, "任务已结束")
+		return
+	}
+	slog.Info(fmt.Sprintf("任务%d已取消", job.ID))
+	writeJSON(w, s.jobStatus(job))
+}
+
+// 依次执行排队的任务，ctx取消后停止
+func (s *apiServer) run(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		s.mu.Lock()
+		s.ready.Broadcast()
+		s.mu.Unlock()
+	}()
+
+	for {
+		s.mu.Lock()
+		for len(s.queue) == 0 && ctx.Err() == nil {
+			s.ready.Wait()
+		}
+		if ctx.Err() != nil {
+			s.mu.Unlock()
+			return
+		}
+		job := s.queue[0]
+		s.queue = s.queue[1:]
+		jobCtx, cancel := context.WithCancel(ctx)
+		now := time.Now()
+		job.Status, job.StartedAt, job.cancel = jobRunning, &now, cancel
+		s.mu.Unlock()
+
+		slog.Info(fmt.Sprintf("开始执行任务%d", job.ID))
+		results, err := scanTargets(jobCtx, job.targets, job.cfg)
+		cancelled := jobCtx.Err() != nil
+		cancel()
+
+		report := &ScanReport{
+			StartTime: now,
+			EndTime:   time.Now(),
+			Targets:   strings.Join(job.Targets, ","),
+			Ports:     job.Ports,
+			Results:   results,
+		}
+		sortResults(report.Results)
+
+		s.mu.Lock()
+		job.report, job.ResultCount, job.FinishedAt = report, len(results), &report.EndTime
+		job.Status = jobDone
+		if cancelled {
+			job.Status = jobCancelled
+		}
+		if err != nil && !errors.Is(err, context.Canceled) {
+			job.Error = err.Error()
+		}
+		s.pruneHistory()
+		s.mu.Unlock()
+
+		slog.Info(fmt.Sprintf("任务%d结束：%s，结果%d条", job.ID, job.Status, len(results)))
+		if err := recordRun("serve", report); err != nil {
+			slog.Error(fmt.Sprintf("任务%d的结果记录到数据库失败: %v", job.ID, err))
+		}
+		if !cancelled {
+			notifyScan(job.cfg, fmt.Sprintf("serve任务%d", job.ID), report, err)
+		}
+	}
+}
+
+// 已结束的任务超过上限时删除最早的任务，调用时需持有锁
+func (s *apiServer) pruneHistory() {
+	var finished []int
+	for id, job := range s.jobs {
+		if job.Status == jobDone || job.Status == jobCancelled {
+			finished = append(finished, id)
+		}
+	}
+	if len(finished) <= s.history {
+		return
+	}
+	sort.Ints(finished)
+	for _, id := range finished[:len(finished)-s.history] {
+		delete(s.jobs, id)
+	}
+}
+
+func runServe(args []string) error {
+	fs := newFlagSet("serve", "以HTTP API的方式接收扫描任务，任务依次执行，可查询进度、获取结果与取消任务")
+	loadCfg := configFlags(fs, "serve")
+	listenInput := fs.String("listen", "127.0.0.1:9800", "监听地址")
+	tokenInput := fs.String("token", "", "API口令，请求需携带 Authorization: Bearer <token>，也可通过环境变量"+envPrefix+"API_TOKEN指定")
+	queueSizeInput := fs.Int("queue-size", 10, "最多排队的任务数，超过时拒绝提交")
+	historyInput := fs.Int("history", 100, "内存中最多保留的已结束任务数")
+	fs.Parse(args)
+
+	cfg, err := loadCfg()
+	if err != nil {
+		return err
+	}
+
+	token := *tokenInput
+	if token == "" {
+		token = os.Getenv(envPrefix + "API_TOKEN")
+	}
+	if token == "" {
+		return fmt.Errorf("%w: 未指定API口令，请通过-token或环境变量%sAPI_TOKEN指定", ErrInvalidArgument, envPrefix)
+	}
+	if *queueSizeInput <= 0 || *historyInput <= 0 {
+		return fmt.Errorf("%w: 排队任务数与保留任务数必须大于0", ErrInvalidArgument)
+	}
+
+	// 端口库与端口配置在提交任务时使用，提前加载以便发现错误
+	if _, err := defaultPortDB(); err != nil {
+		return err
+	}
+	if _, err := loadProfiles(); err != nil {
+		return wrapError(ErrInvalidArgument, err)
+	}
+	if err := prepareProbes(cfg); err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", *listenInput)
+	if err != nil {
+		return fmt.Errorf("监听%s失败: %w", *listenInput, err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server := newAPIServer(cfg, token, *queueSizeInput, *historyInput)
+	httpServer := &http.Server{Handler: server.handler()}
+	go httpServer.Serve(listener)
+	go server.run(ctx)
+
+	slog.Info(fmt.Sprintf("API服务已启动：http://%s", listener.Addr()))
+	<-ctx.Done()
+
+	slog.Info("正在停止API服务")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return httpServer.Shutdown(shutdownCtx)
+}
@@ -0,0 +1,155 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"syscall"
+)
+
+/*
+源地址
+1、-S 指定发起连接使用的源ip，-e 指定网卡，-g 指定源端口（部分防火墙只放行源端口为53或88的流量）
+2、端口扫描、存活探测、服务识别与各项探测的tcp连接均使用该设置；经由代理时源ip与网卡作用于与第一个代理之间的连接，
+   源端口由代理决定，-g不生效
+3、指定网卡时linux下将连接绑定到该网卡（需要root权限），其他系统使用网卡的地址作为源ip；未指定-S时ping使用网卡的地址
+4、指定源端口时同一时间只能有一个连接使用该端口访问同一目标端口，连接关闭时直接复位以便立即复用
+5、nmap服务识别同样使用该设置（对应nmap的-S、-e、-g参数）
+*/
+
+var (
+	sourceIP        net.IP
+	sourceInterface string
+	sourcePort      int
+	sourceIfaceIPs  []net.IP // 网卡的地址，未指定-S时作为源ip
+
+	bindWarnOnce sync.Once
+)
+
+// 设置源ip、网卡与源端口，需要在开始扫描之前调用
+func setSourceAddress(ip string, iface string, port int) {
+	sourceIP = net.ParseIP(ip)
+	sourceInterface = iface
+	sourcePort = port
+	sourceIfaceIPs = nil
+	if iface != "" {
+		sourceIfaceIPs, _ = interfaceIPs(iface)
+	}
+}
+
+// 校验源地址设置
+func checkSourceAddress(ip string, iface string, port int) error {
+	if ip != "" && net.ParseIP(ip) == nil {
+		return fmt.Errorf("源ip不合法: %s", ip)
+	}
+	if port < 0 || port > 65535 {
+		return fmt.Errorf("源端口不合法: %d", port)
+	}
+	if iface != "" {
+		if _, err := interfaceIPs(iface); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 网卡上配置的地址
+func interfaceIPs(name string) ([]net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("网卡%s不存在: %v", name, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("读取网卡%s的地址失败: %v", name, err)
+	}
+	var ips []net.IP
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			ips = append(ips, ipNet.IP)
+		}
+	}
+	return ips, nil
+}
+
+// 是否指定了源地址、网卡或源端口
+func sourceConfigured() bool {
+	return sourceIP != nil || sourceInterface != "" || sourcePort != 0
+}
+
+// 访问指定ip时使用的源ip，只在地址族相同时使用，未指定时返回nil
+func sourceIPFor(ip net.IP) net.IP {
+	v4 := ip == nil || ip.To4() != nil
+	if sourceIP != nil {
+		if (sourceIP.To4() != nil) == v4 {
+			return sourceIP
+		}
+		return nil
+	}
+	for _, addr := range sourceIfaceIPs {
+		// 跳过ipv6链路本地地址，需要额外指定zone才能使用
+		if (addr.To4() != nil) == v4 && !addr.IsLinkLocalUnicast() {
+			return addr
+		}
+	}
+	return nil
+}
+
+// 创建连接指定地址使用的拨号器，应用源ip、网卡绑定与源端口，port为0时使用随机源端口
+func newDialer(addr string, port int) *net.Dialer {
+	dialer := &net.Dialer{}
+	if !sourceConfigured() {
+		return dialer
+	}
+
+	host, _, _ := net.SplitHostPort(addr)
+	local := sourceIPFor(net.ParseIP(host))
+	if local != nil || port != 0 {
+		dialer.LocalAddr = &net.TCPAddr{IP: local, Port: port}
+	}
+
+	dialer.Control = func(network string, address string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			// 固定源端口时允许多个连接同时使用该端口
+			if port != 0 {
+				if sockErr = reuseAddr(fd); sockErr != nil {
+					return
+				}
+			}
+			if sourceInterface != "" {
+				if err := bindToDevice(fd, sourceInterface); err != nil {
+					bindWarnOnce.Do(func() {
+						slog.Warn(fmt.Sprintf("连接绑定到网卡%s失败，只使用网卡的地址作为源ip: %v", sourceInterface, err))
+					})
+				}
+			}
+		})
+		if err != nil {
+			return err
+		}
+		return sockErr
+	}
+	return dialer
+}
+
+// 扫描本机的源端口时连接到自身
+var errSelfConnect = errors.New("连接到自身")
+
+// 固定源端口时关闭连接直接复位，避免TIME_WAIT导致同一端口无法再次连接
+// 扫描本机的源端口时连接会连到自身，不是真正开放的端口
+func checkSelfConnect(conn net.Conn) (net.Conn, error) {
+	if sourcePort == 0 {
+		return conn, nil
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetLinger(0)
+	}
+	if conn.LocalAddr().String() == conn.RemoteAddr().String() {
+		conn.Close()
+		return nil, fmt.Errorf("%w: %s", errSelfConnect, conn.LocalAddr())
+	}
+	return conn, nil
+}
@@ -0,0 +1,13 @@
+package tools
+
+import "syscall"
+
+// 允许多个连接同时使用同一源端口
+func reuseAddr(fd uintptr) error {
+	return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+}
+
+// 将连接绑定到指定网卡，需要root权限
+func bindToDevice(fd uintptr, iface string) error {
+	return syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, iface)
+}
@@ -0,0 +1,16 @@
+package tools
+
+import (
+	"errors"
+	"syscall"
+)
+
+// 允许多个连接同时使用同一源端口
+func reuseAddr(fd uintptr) error {
+	return syscall.SetsockoptInt(syscall.Handle(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+}
+
+// windows不支持将连接绑定到网卡
+func bindToDevice(fd uintptr, iface string) error {
+	return errors.New("当前系统不支持绑定网卡")
+}
@@ -0,0 +1,584 @@
+package tools
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+结果数据库
+1、-db 指定数据库文件后，scan、detect、coordinator与serve每次扫描结束时记录扫描信息与全部结果（含服务、HTTP、TLS、未授权访问、弱口令、漏洞等探测详情）
+2、report与diff的输入文件可使用 db:<编号>、db:latest（最近一次）、db:previous（倒数第二次）读取数据库中的扫描记录
+3、history子命令查询历史：列出扫描记录，按ip、端口、服务、标签与时间范围统计各端口的首次与最近发现时间，或删除过早的记录
+4、数据库为单个bbolt文件，同一时间只能被一个进程写入，其他进程等待数秒后报错
+*/
+
+const (
+	bucketRuns    = "runs"    // 扫描记录，编号 -> storedRun
+	bucketResults = "results" // 扫描结果，编号+序号 -> ScanResult
+)
+
+// 数据库被其他进程占用时的等待时间
+const dbLockTimeout = 5 * time.Second
+
+// 数据库中扫描记录的引用前缀，例如 db:12、db:latest
+const dbRefPrefix = "db:"
+
+var resultDBPath string
+
+// 设置结果数据库路径，为空时不记录
+func setResultDBPath(path string) {
+	resultDBPath = path
+}
+
+// storedRun 数据库中的一次扫描记录
+type storedRun struct {
+	ID          uint64    `json:"id"`
+	Command     string    `json:"command"`
+	StartTime   time.Time `json:"start_time"`
+	EndTime     time.Time `json:"end_time"`
+	Targets     string    `json:"targets"`
+	Ports       string    `json:"ports"`
+	Hosts       int       `json:"hosts"` // 有开放端口的主机数
+	ResultCount int       `json:"result_count"`
+}
+
+// 扫描记录的key，编号以大端序保存，按编号顺序遍历
+func runKey(id uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, id)
+}
+
+// 扫描结果的key，以扫描编号为前缀
+func runResultKey(id uint64, seq int) []byte {
+	return binary.BigEndian.AppendUint32(runKey(id), uint32(seq))
+}
+
+// 打开结果数据库，只读时数据库文件需已存在
+func openResultDB(readOnly bool) (*bolt.DB, error) {
+	if resultDBPath == "" {
+		return nil, fmt.Errorf("%w: 未指定数据库文件，请通过-db指定", ErrInvalidArgument)
+	}
+	if readOnly {
+		if _, err := os.Stat(resultDBPath); err != nil {
+			return nil, fmt.Errorf("%w: 数据库文件读取失败: %v", ErrInvalidArgument, err)
+		}
+	} else if err := ensureDir(resultDBPath); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(resultDBPath, 0600, &bolt.Options{Timeout: dbLockTimeout, ReadOnly: readOnly})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("数据库%s被其他进程占用", resultDBPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("数据库%s打开失败: %v", resultDBPath, err)
+	}
+	return db, nil
+}
+
+// 将一次扫描的信息与结果记录到数据库，未指定数据库时跳过
+func recordRun(command string, report *ScanReport) error {
+	if resultDBPath == "" {
+		return nil
+	}
+	db, err := openResultDB(false)
+	if err != nil {
+		return wrapError(ErrExportFailed, err)
+	}
+	defer db.Close()
+
+	hosts := make(map[string]bool)
+	for _, result := range report.Results {
+		hosts[result.IP] = true
+	}
+	run := storedRun{
+		Command:     command,
+		StartTime:   report.StartTime,
+		EndTime:     report.EndTime,
+		Targets:     report.Targets,
+		Ports:       report.Ports,
+		Hosts:       len(hosts),
+		ResultCount: len(report.Results),
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		runs, err := tx.CreateBucketIfNotExists([]byte(bucketRuns))
+		if err != nil {
+			return err
+		}
+		results, err := tx.CreateBucketIfNotExists([]byte(bucketResults))
+		if err != nil {
+			return err
+		}
+		if run.ID, err = runs.NextSequence(); err != nil {
+			return err
+		}
+
+		for i, result := range report.Results {
+			content, err := json.Marshal(result)
+			if err != nil {
+				return err
+			}
+			if err := results.Put(runResultKey(run.ID, i), content); err != nil {
+				return err
+			}
+		}
+		content, err := json.Marshal(run)
+		if err != nil {
+			return err
+		}
+		return runs.Put(runKey(run.ID), content)
+	})
+	if err != nil {
+		return fmt.Errorf("%w: 结果写入数据库失败: %w", ErrExportFailed, err)
+	}
+	slog.Info(fmt.Sprintf("结果已记录到数据库%s，扫描编号%d", resultDBPath, run.ID))
+	return nil
+}
+
+// 读取全部扫描记录，按编号升序排列
+func loadRuns(tx *bolt.Tx) ([]storedRun, error) {
+	bucket := tx.Bucket([]byte(bucketRuns))
+	if bucket == nil {
+		return nil, nil
+	}
+	var runs []storedRun
+	err := bucket.ForEach(func(key []byte, value []byte) error {
+		var run storedRun
+		if err := json.Unmarshal(value, &run); err != nil {
+			return fmt.Errorf("扫描记录%d解析失败: %v", binary.BigEndian.Uint64(key), err)
+		}
+		runs = append(runs, run)
+		return nil
+	})
+	return runs, err
+}
+
+// 读取一次扫描的全部结果，fn返回错误时停止
+func eachRunResult(tx *bolt.Tx, id uint64, fn func(result ScanResult) error) error {
+	bucket := tx.Bucket([]byte(bucketResults))
+	if bucket == nil {
+		return nil
+	}
+	prefix := runKey(id)
+	cursor := bucket.Cursor()
+	for key, value := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, value = cursor.Next() {
+		var result ScanResult
+		if err := json.Unmarshal(value, &result); err != nil {
+			return fmt.Errorf("扫描记录%d的结果解析失败: %v", id, err)
+		}
+		if err := fn(result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 根据引用查找扫描记录，引用为编号、latest或previous
+func findRun(runs []storedRun, ref string) (storedRun, error) {
+	switch ref {
+	case "latest", "previous":
+		offset := 1
+		if ref == "previous" {
+			offset = 2
+		}
+		if len(runs) < offset {
+			return storedRun{}, fmt.Errorf("%w: 数据库中的扫描记录不足%d次", ErrInvalidArgument, offset)
+		}
+		return runs[len(runs)-offset], nil
+	}
+
+	id, err := strconv.ParseUint(ref, 10, 64)
+	if err != nil {
+		return storedRun{}, fmt.Errorf("%w: 扫描记录引用不合法: %s%s，应为编号、latest或previous", ErrInvalidArgument, dbRefPrefix, ref)
+	}
+	for _, run := range runs {
+		if run.ID == id {
+			return run, nil
+		}
+	}
+	return storedRun{}, fmt.Errorf("%w: 数据库中不存在编号为%d的扫描记录", ErrInvalidArgument, id)
+}
+
+// 读取数据库中的一次扫描作为结果，供report与diff使用
+func loadStoredReport(ref string) (*ScanReport, error) {
+	db, err := openResultDB(true)
+	if err != nil {
+		return nil, invalidStoredReport(err)
+	}
+	defer db.Close()
+
+	var report *ScanReport
+	err = db.View(func(tx *bolt.Tx) error {
+		runs, err := loadRuns(tx)
+		if err != nil {
+			return err
+		}
+		run, err := findRun(runs, ref)
+		if err != nil {
+			return err
+		}
+
+		report = &ScanReport{StartTime: run.StartTime, EndTime: run.EndTime, Targets: run.Targets, Ports: run.Ports}
+		return eachRunResult(tx, run.ID, func(result ScanResult) error {
+			report.Results = append(report.Results, result)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, invalidStoredReport(err)
+	}
+	return report, nil
+}
+
+// 无法读取的扫描记录与无法读取的结果文件相同，均视为输入有误
+func invalidStoredReport(err error) error {
+	if errors.Is(err, ErrInvalidArgument) {
+		return err
+	}
+	return wrapError(ErrInvalidArgument, err)
+}
+
+// 删除结束时间早于before的扫描记录及其结果，返回删除的记录数
+func pruneRuns(before time.Time) (int, error) {
+	db, err := openResultDB(false)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	var count int
+	err = db.Update(func(tx *bolt.Tx) error {
+		runs, err := loadRuns(tx)
+		if err != nil {
+			return err
+		}
+		results := tx.Bucket([]byte(bucketResults))
+		for _, run := range runs {
+			if !run.EndTime.Before(before) {
+				continue
+			}
+			if results != nil {
+				// 删除遍历中的key会使游标失效，先收集再删除
+				var keys [][]byte
+				prefix := runKey(run.ID)
+				cursor := results.Cursor()
+				for key, _ := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = cursor.Next() {
+					keys = append(keys, bytes.Clone(key))
+				}
+				for _, key := range keys {
+					if err := results.Delete(key); err != nil {
+						return err
+					}
+				}
+			}
+			if err := tx.Bucket([]byte(bucketRuns)).Delete(runKey(run.ID)); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// portHistory 同一端口在多次扫描中的发现情况
+type portHistory struct {
+	Result    ScanResult // 最近一次发现时的结果
+	FirstSeen time.Time
+	LastSeen  time.Time
+	FirstRun  uint64
+	LastRun   uint64
+	Count     int // 发现该端口的扫描次数
+}
+
+// historyFilter 历史查询的过滤条件，各条件为空时不过滤
+type historyFilter struct {
+	IPs      map[string]bool
+	TCP      map[int]bool
+	UDP      map[int]bool
+	Services map[string]bool
+	Tag      string
+	Since    time.Time
+	Until    time.Time
+}
+
+// 扫描记录是否在时间范围内
+func (f *historyFilter) matchRun(run storedRun) bool {
+	if !f.Since.IsZero() && run.EndTime.Before(f.Since) {
+		return false
+	}
+	return f.Until.IsZero() || !run.EndTime.After(f.Until)
+}
+
+func (f *historyFilter) matchResult(result ScanResult) bool {
+	if f.IPs != nil && !f.IPs[result.IP] {
+		return false
+	}
+	if f.TCP != nil || f.UDP != nil {
+		ports := f.TCP
+		if result.Protocol == "udp" {
+			ports = f.UDP
+		}
+		if !ports[result.Port] {
+			return false
+		}
+	}
+	if f.Services != nil && !f.Services[strings.ToLower(result.Service)] {
+		return false
+	}
+	return f.Tag == "" || matchTags(result.Tags, f.Tag)
+}
+
+// 统计满足条件的各端口的首次与最近发现时间，按ip与端口排序
+func queryPortHistory(filter *historyFilter) ([]*portHistory, error) {
+	db, err := openResultDB(true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	index := make(map[string]*portHistory)
+	err = db.View(func(tx *bolt.Tx) error {
+		runs, err := loadRuns(tx)
+		if err != nil {
+			return err
+		}
+		for _, run := range runs {
+			if !filter.matchRun(run) {
+				continue
+			}
+			err := eachRunResult(tx, run.ID, func(result ScanResult) error {
+				if !filter.matchResult(result) {
+					return nil
+				}
+				key := resultKey(result)
+				history, ok := index[key]
+				if !ok {
+					history = &portHistory{FirstSeen: run.EndTime, FirstRun: run.ID}
+					index[key] = history
+				}
+				history.Result, history.LastSeen, history.LastRun = result, run.EndTime, run.ID
+				history.Count++
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	histories := make([]*portHistory, 0, len(index))
+	results := make([]ScanResult, 0, len(index))
+	for _, history := range index {
+		results = append(results, history.Result)
+	}
+	sortResults(results)
+	for _, result := range results {
+		histories = append(histories, index[resultKey(result)])
+	}
+	return histories, nil
+}
+
+// 解析时间参数，支持 30d、12h 等相对时间以及 2026-09-01、2026-09-01 08:00 格式的日期
+func parseTimeSpec(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if duration, err := time.ParseDuration(value); err == nil {
+		return now.Add(-duration), nil
+	}
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04", "2006-01-02 15:04:05"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w: 时间格式不合法: %s，例如 30d、12h、2026-09-01", ErrInvalidArgument, value)
+}
+
+// 根据命令行参数生成历史查询的过滤条件
+func newHistoryFilter(ips string, ports string, services string, tag string, since string, until string) (*historyFilter, error) {
+	filter := &historyFilter{Tag: tag}
+	now := time.Now()
+
+	if ips != "" {
+		ipSlice, err := ipFormatCheck(ips)
+		if err != nil {
+			return nil, err
+		}
+		filter.IPs = make(map[string]bool, len(ipSlice))
+		for _, ip := range ipSlice {
+			filter.IPs[ip] = true
+		}
+	}
+	if ports != "" {
+		if _, err := defaultPortDB(); err != nil {
+			return nil, err
+		}
+		spec, err := parsePortSpec(ports)
+		if err != nil {
+			return nil, wrapError(ErrInvalidPortSpec, err)
+		}
+		filter.TCP, filter.UDP = make(map[int]bool), make(map[int]bool)
+		for _, port := range spec.TCP {
+			filter.TCP[port] = true
+		}
+		for _, port := range spec.UDP {
+			filter.UDP[port] = true
+		}
+	}
+	if services != "" {
+		filter.Services = make(map[string]bool)
+		for _, service := range strings.Split(services, ",") {
+			filter.Services[strings.ToLower(strings.TrimSpace(service))] = true
+		}
+	}
+
+	var err error
+	if since != "" {
+		if filter.Since, err = parseTimeSpec(since, now); err != nil {
+			return nil, err
+		}
+	}
+	if until != "" {
+		if filter.Until, err = parseTimeSpec(until, now); err != nil {
+			return nil, err
+		}
+	}
+	return filter, nil
+}
+
+// 列出扫描记录
+func printRuns(runs []storedRun) {
+	// 表头为中文，按显示宽度对齐
+	fmt.Println("编号   命令         开始时间            耗时         主机   结果  目标")
+	for _, run := range runs {
+		elapsed := run.EndTime.Sub(run.StartTime).Round(time.Second)
+		fmt.Printf("%-6d %-12s %-19s %-10s %6d %6d  %s\n", run.ID, run.Command,
+			run.StartTime.Local().Format("2006-01-02 15:04:05"), elapsed, run.Hosts, run.ResultCount, run.Targets)
+	}
+}
+
+// 输出各端口的发现情况
+func printPortHistory(histories []*portHistory) {
+	const layout = "2006-01-02 15:04"
+	for _, history := range histories {
+		result := history.Result
+		line := fmt.Sprintf("%s %d/%s %s 首次发现 %s（#%d） 最近发现 %s（#%d） 共%d次",
+			result.IP, result.Port, result.Protocol, serviceText(result),
+			history.FirstSeen.Local().Format(layout), history.FirstRun,
+			history.LastSeen.Local().Format(layout), history.LastRun, history.Count)
+		if len(result.Tags) > 0 {
+			line += " [" + strings.Join(result.Tags, ",") + "]"
+		}
+		fmt.Println(line)
+	}
+}
+
+func runHistory(args []string) error {
+	fs := newFlagSet("history", "查询数据库中的历史扫描，统计各端口的首次与最近发现时间，例如 miao history -db scan.db -port 3389 -since 30d")
+	loadCfg := configFlags(fs, "history")
+	runsInput := fs.Bool("runs", false, "列出满足时间范围的扫描记录")
+	ipInput := fs.String("ip", "", "只统计指定的ip，格式同scan的-ip参数")
+	portInput := fs.String("port", "", "只统计指定的端口，格式同-p参数，例如 3389、1-1024、T:80,U:53")
+	serviceInput := fs.String("service", "", "只统计指定的服务，多个以逗号分割，例如 ssh,rdp")
+	tagInput := fs.String("tag", "", "只统计带有指定标签的结果，多个标签以逗号分割需同时满足，只写键时匹配该键的任意值")
+	sinceInput := fs.String("since", "", "只统计该时间之后结束的扫描，例如 30d、12h、2026-09-01")
+	untilInput := fs.String("until", "", "只统计该时间之前结束的扫描，格式同-since")
+	outputInput := fs.String("o", "", "将各端口最近一次发现时的结果保存到指定文件")
+	formatInput := fs.String("format", "", "输出格式：xlsx、csv、json、txt，默认根据输出文件扩展名判断")
+	pruneInput := fs.String("prune", "", "删除该时间之前结束的扫描记录及其结果后退出，格式同-since，例如 180d")
+	fs.Parse(args)
+
+	if _, err := loadCfg(); err != nil {
+		return err
+	}
+
+	if *pruneInput != "" {
+		before, err := parseTimeSpec(*pruneInput, time.Now())
+		if err != nil {
+			return err
+		}
+		count, err := pruneRuns(before)
+		if err != nil {
+			return err
+		}
+		slog.Info(fmt.Sprintf("已删除%d次扫描记录", count))
+		return nil
+	}
+
+	filter, err := newHistoryFilter(*ipInput, *portInput, *serviceInput, *tagInput, *sinceInput, *untilInput)
+	if err != nil {
+		return err
+	}
+
+	if *runsInput {
+		db, err := openResultDB(true)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		var runs []storedRun
+		err = db.View(func(tx *bolt.Tx) error {
+			all, err := loadRuns(tx)
+			for _, run := range all {
+				if filter.matchRun(run) {
+					runs = append(runs, run)
+				}
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		printRuns(runs)
+		return nil
+	}
+
+	histories, err := queryPortHistory(filter)
+	if err != nil {
+		return err
+	}
+	printPortHistory(histories)
+	slog.Info(fmt.Sprintf("共%d个端口", len(histories)))
+	if *outputInput == "" {
+		return nil
+	}
+
+	report := &ScanReport{Targets: *ipInput, Ports: *portInput}
+	for _, history := range histories {
+		report.Results = append(report.Results, history.Result)
+		if report.StartTime.IsZero() || history.FirstSeen.Before(report.StartTime) {
+			report.StartTime = history.FirstSeen
+		}
+		if history.LastSeen.After(report.EndTime) {
+			report.EndTime = history.LastSeen
+		}
+	}
+	format := *formatInput
+	if format == "" {
+		format = formatFromPath(*outputInput)
+	}
+	if err := exportReport(report, format, *outputInput); err != nil {
+		return err
+	}
+	slog.Info("结果已保存: " + *outputInput)
+	return nil
+}
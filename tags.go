@@ -0,0 +1,209 @@
+package tools
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+标签
+1、标签为任意字符串，推荐使用 键=值 的形式，例如 bu=finance、env=prod
+2、来源：目标文件（-l）中为目标指定的标签、-tags参数（作用于全部结果），以及ip段与标签的对应关系
+   （-tag-map指定的yaml/json文件与配置文件中的tag_rules，例如 10.1.0.0/16: [bu=finance]，匹配多个时标签合并）
+3、标签保存在每条扫描结果中，导出到excel/csv的"标签"列与json的tags字段
+4、report与diff可通过-tag只输出带有指定标签的结果
+*/
+
+// 标签列表，yaml中可以是列表，也可以是以逗号或分号分割的字符串
+type tagList []string
+
+func (t *tagList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*t = splitTags(node.Value)
+		return nil
+	}
+	var tags []string
+	if err := node.Decode(&tags); err != nil {
+		return err
+	}
+	*t = tags
+	return nil
+}
+
+// 以;或,分割标签
+func splitTags(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool { return r == ';' || r == ',' })
+}
+
+// 合并标签，去掉空白与重复项，保持原有顺序
+func mergeTags(tags []string, more []string) []string {
+	var merged []string
+	seen := make(map[string]bool)
+	for _, tag := range append(append([]string{}, tags...), more...) {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		merged = append(merged, tag)
+	}
+	return merged
+}
+
+// ip段对应的标签
+type tagRule struct {
+	network *net.IPNet
+	tags    []string
+}
+
+var (
+	tagMapPath     string
+	configTagRules map[string]tagList
+	tagRulesOnce   sync.Once
+	tagRules       []tagRule
+	tagRulesErr    error
+)
+
+// 设置ip段与标签的对应关系，需要在首次使用之前调用
+func setTagRules(path string, rules map[string]tagList) {
+	tagMapPath = path
+	configTagRules = rules
+}
+
+// 获取ip段与标签的对应关系，依次合并配置文件中的tag_rules与-tag-map文件
+func loadTagRules() ([]tagRule, error) {
+	tagRulesOnce.Do(func() {
+		rules := make(map[string]tagList)
+		for network, tags := range configTagRules {
+			rules[network] = tagList(mergeTags(rules[network], tags))
+		}
+
+		if tagMapPath != "" {
+			content, err := os.ReadFile(tagMapPath)
+			if err != nil {
+				tagRulesErr = fmt.Errorf("%w: 标签对应文件读取失败: %v", ErrInvalidArgument, err)
+				return
+			}
+			var file map[string]tagList
+			if err := yaml.Unmarshal(content, &file); err != nil {
+				tagRulesErr = fmt.Errorf("%w: 标签对应文件 %s 解析失败: %v", ErrInvalidArgument, tagMapPath, err)
+				return
+			}
+			for network, tags := range file {
+				rules[network] = tagList(mergeTags(rules[network], tags))
+			}
+		}
+
+		tagRules, tagRulesErr = parseTagRules(rules)
+	})
+	return tagRules, tagRulesErr
+}
+
+// 解析ip段，单个ip视为只包含该ip的网段；按网段从大到小排列，使标签按由粗到细的顺序合并
+func parseTagRules(rules map[string]tagList) ([]tagRule, error) {
+	var parsed []tagRule
+	for network, tags := range rules {
+		network = strings.TrimSpace(network)
+		if ip := net.ParseIP(network); ip != nil {
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			parsed = append(parsed, tagRule{network: &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, tags: tags})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(network)
+		if err != nil {
+			return nil, fmt.Errorf("%w: 标签对应关系中的 %q 不是合法的ip或ip段", ErrInvalidArgument, network)
+		}
+		parsed = append(parsed, tagRule{network: ipNet, tags: tags})
+	}
+
+	sort.Slice(parsed, func(i, j int) bool {
+		si, _ := parsed[i].network.Mask.Size()
+		sj, _ := parsed[j].network.Mask.Size()
+		if si != sj {
+			return si < sj
+		}
+		return parsed[i].network.String() < parsed[j].network.String()
+	})
+	return parsed, nil
+}
+
+// ip所在网段对应的全部标签
+func ruleTags(rules []tagRule, ip string) []string {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return nil
+	}
+	var tags []string
+	for _, rule := range rules {
+		if rule.network.Contains(addr) {
+			tags = mergeTags(tags, rule.tags)
+		}
+	}
+	return tags
+}
+
+// 为扫描结果添加标签：目标文件中的标签、-tags参数以及ip段对应的标签，-tag-map读取失败时不添加标签并返回错误
+func tagResults(results []ScanResult, targets []Target, cfg *Config) error {
+	rules, err := loadTagRules()
+	if err != nil {
+		return err
+	}
+	targetTags := make(map[string][]string, len(targets))
+	for _, target := range targets {
+		targetTags[target.IP] = target.Tags
+	}
+	extra := splitTags(cfg.Tags)
+
+	cache := make(map[string][]string)
+	for i := range results {
+		ip := results[i].IP
+		tags, ok := cache[ip]
+		if !ok {
+			tags = mergeTags(mergeTags(targetTags[ip], extra), ruleTags(rules, ip))
+			cache[ip] = tags
+		}
+		results[i].Tags = mergeTags(results[i].Tags, tags)
+	}
+	return nil
+}
+
+// 判断结果的标签是否满足过滤条件，多个条件以逗号分割，需同时满足
+// 条件为 键=值 时需完全一致，只有键时匹配该键的任意值或同名标签
+func matchTags(tags []string, filter string) bool {
+	for _, want := range splitTags(filter) {
+		want = strings.TrimSpace(want)
+		matched := false
+		for _, tag := range tags {
+			if tag == want || (!strings.Contains(want, "=") && strings.HasPrefix(tag, want+"=")) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// 只保留满足标签过滤条件的结果，条件为空时不过滤
+func filterByTags(results []ScanResult, filter string) []ScanResult {
+	if strings.TrimSpace(filter) == "" {
+		return results
+	}
+	var kept []ScanResult
+	for _, result := range results {
+		if matchTags(result.Tags, filter) {
+			kept = append(kept, result)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,75 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// 使用指定的标签对应关系，测试结束后恢复
+func useTagRules(t *testing.T, path string, rules map[string]tagList) {
+	t.Helper()
+	reset := func() {
+		tagRulesOnce, tagRules, tagRulesErr = sync.Once{}, nil, nil
+	}
+	reset()
+	setTagRules(path, rules)
+	t.Cleanup(func() {
+		setTagRules("", nil)
+		reset()
+	})
+}
+
+func TestTagResults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tags.yaml")
+	content := "10.1.0.0/16: [bu=finance]\n10.1.2.3: [owner=alice]\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	useTagRules(t, path, map[string]tagList{"10.0.0.0/8": {"env=prod"}})
+
+	cfg := defaultConfig()
+	cfg.Tags = "scan=weekly"
+	results := []ScanResult{{IP: "10.1.2.3", Port: 22}, {IP: "10.2.0.1", Port: 80}, {IP: "192.168.1.1", Port: 443}}
+	targets := []Target{{IP: "10.1.2.3", Tags: []string{"role=db"}}}
+	if err := tagResults(results, targets, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"[role=db scan=weekly env=prod bu=finance owner=alice]",
+		"[scan=weekly env=prod]",
+		"[scan=weekly]",
+	}
+	for i, result := range results {
+		if fmt.Sprint(result.Tags) != want[i] {
+			t.Errorf("%s 的标签为 %v，期望 %s", result.IP, result.Tags, want[i])
+		}
+	}
+}
+
+// -tag-map有误时返回错误，不依赖调用方是否提前检查
+func TestTagResultsBadTagMap(t *testing.T) {
+	for name, content := range map[string]string{"不是ip段": "finance: [bu=finance]\n", "格式错误": "[a, b\n"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tags.yaml")
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			useTagRules(t, path, nil)
+
+			results := []ScanResult{{IP: "10.1.2.3", Port: 22}}
+			if err := tagResults(results, nil, defaultConfig()); !errors.Is(err, ErrInvalidArgument) {
+				t.Fatalf("错误为 %v，期望ErrInvalidArgument", err)
+			}
+		})
+	}
+
+	useTagRules(t, filepath.Join(t.TempDir(), "missing.yaml"), nil)
+	if err := tagResults(nil, nil, defaultConfig()); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("文件不存在时错误为 %v，期望ErrInvalidArgument", err)
+	}
+}
@@ -0,0 +1,288 @@
+package tools
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+目标文件格式（-l），"-l -" 从标准输入读取
+1、文本文件：每行一个或多个以空白分割的目标，支持-ip参数的全部格式，#之后为注释，空行忽略
+   目标后可用 :端口 指定该行单独扫描的端口，格式同-p参数，例如 10.1.1.0/24:80,443、[::1]:22
+2、csv文件（.csv）：首行为表头时按列名识别，target/ip/host为目标，ports为端口，tags为标签（以;或,分割），
+   其余列以 列名=值 的形式作为标签；无表头时依次为目标、端口、标签
+3、json文件（.json）：目标数组，元素为字符串或 {"target": "10.1.1.0/24", "ports": "80,443", "tags": ["web"]}，
+   也可为 {"targets": [...]}
+4、标准输入按内容识别json，否则按文本处理
+同一ip多次出现时端口取并集，标签合并
+*/
+
+// Target 扫描目标及其需要扫描的端口
+type Target struct {
+	IP    string
+	Ports *PortSpec
+	Tags  []string
+}
+
+// 从标准输入读取目标时-l的取值
+const stdinTarget = "-"
+
+// csv表头中表示目标、端口与标签的列名
+var (
+	targetColumns = map[string]bool{"target": true, "ip": true, "host": true, "address": true}
+	portsColumns  = map[string]bool{"ports": true, "port": true}
+	tagsColumns   = map[string]bool{"tags": true, "tag": true, "labels": true, "label": true}
+)
+
+// 读取目标文件，按扩展名识别csv与json，其余按文本处理
+// defaults为未单独指定端口时扫描的端口，exclude为需要从单独指定的端口中排除的端口
+func readTargetFile(path string, defaults *PortSpec, exclude *PortSpec) ([]Target, error) {
+	var content []byte
+	var err error
+	if path == stdinTarget {
+		content, err = io.ReadAll(os.Stdin)
+	} else {
+		content, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: 文件读取失败: %v", ErrInvalidTarget, err)
+	}
+
+	parser := newTargetParser(defaults, exclude)
+	switch ext := strings.ToLower(filepath.Ext(path)); {
+	case ext == ".csv":
+		err = parser.parseCSV(content)
+	case ext == ".json", path == stdinTarget && looksLikeJSON(content):
+		err = parser.parseJSON(content)
+	default:
+		err = parser.parseText(content)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parser.targets, nil
+}
+
+// 内容以[或{开头时按json处理
+func looksLikeJSON(content []byte) bool {
+	content = bytes.TrimSpace(content)
+	return len(content) > 0 && (content[0] == '[' || content[0] == '{')
+}
+
+// 解析目标并按ip合并
+type targetParser struct {
+	defaults *PortSpec
+	exclude  *PortSpec
+	targets  []Target
+	index    map[string]int       // ip在targets中的位置
+	specs    map[string]*PortSpec // 已解析的端口规格，相同的端口共用同一对象，便于按端口分组扫描
+}
+
+func newTargetParser(defaults *PortSpec, exclude *PortSpec) *targetParser {
+	return &targetParser{defaults: defaults, exclude: exclude, index: make(map[string]int), specs: make(map[string]*PortSpec)}
+}
+
+// 添加一条目标，entry可带 :端口，ports非空时覆盖entry中的端口
+func (p *targetParser) add(entry string, ports string, tags []string) error {
+	host, entryPorts := splitTargetPorts(entry)
+	if ports == "" {
+		ports = entryPorts
+	}
+
+	ipSlice, err := ipFormatCheck(host)
+	if err != nil {
+		return err
+	}
+
+	spec, err := p.portSpec(ports)
+	if err != nil {
+		return err
+	}
+
+	for _, ip := range ipSlice {
+		i, ok := p.index[ip]
+		if !ok {
+			p.index[ip] = len(p.targets)
+			p.targets = append(p.targets, Target{IP: ip, Ports: spec, Tags: tags})
+			continue
+		}
+		target := &p.targets[i]
+		target.Tags = mergeTags(target.Tags, tags)
+		if target.Ports != spec {
+			target.Ports = target.Ports.Union(spec)
+		}
+	}
+	return nil
+}
+
+// 解析单独指定的端口，未指定时使用默认端口
+func (p *targetParser) portSpec(ports string) (*PortSpec, error) {
+	if ports == "" {
+		return p.defaults, nil
+	}
+	if spec, ok := p.specs[ports]; ok {
+		return spec, nil
+	}
+
+	spec, err := parsePortSpec(ports)
+	if err != nil {
+		return nil, wrapError(ErrInvalidPortSpec, err)
+	}
+	spec.Exclude(p.exclude)
+	if spec.Empty() {
+		return nil, fmt.Errorf("%w: %q 排除后没有需要扫描的端口", ErrInvalidPortSpec, ports)
+	}
+	p.specs[ports] = spec
+	return spec, nil
+}
+
+func (p *targetParser) parseText(content []byte) error {
+	for n, line := range strings.Split(string(content), "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		for _, entry := range strings.Fields(line) {
+			if err := p.add(entry, "", nil); err != nil {
+				return fmt.Errorf("目标文件第%d行: %w", n+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (p *targetParser) parseCSV(content []byte) error {
+	reader := csv.NewReader(bytes.NewReader(content))
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return fmt.Errorf("%w: csv目标文件解析失败: %v", ErrInvalidTarget, err)
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	// 首行第一列为目标列名时视为表头
+	var header []string
+	if targetColumns[strings.ToLower(strings.TrimSpace(records[0][0]))] {
+		header = records[0]
+		records = records[1:]
+	}
+
+	for n, record := range records {
+		var target, ports string
+		var tags []string
+		for i, value := range record {
+			value = strings.TrimSpace(value)
+			if value == "" {
+				continue
+			}
+
+			name := ""
+			if header != nil && i < len(header) {
+				name = strings.ToLower(strings.TrimSpace(header[i]))
+			}
+			switch {
+			case header == nil && i == 0, targetColumns[name]:
+				target = value
+			case header == nil && i == 1, portsColumns[name]:
+				ports = value
+			case header == nil, tagsColumns[name]:
+				tags = mergeTags(tags, splitTags(value))
+			default:
+				tags = mergeTags(tags, []string{strings.TrimSpace(header[i]) + "=" + value})
+			}
+		}
+		if target == "" {
+			continue
+		}
+
+		line := n + 1
+		if header != nil {
+			line++
+		}
+		if err := p.add(target, ports, tags); err != nil {
+			return fmt.Errorf("目标文件第%d行: %w", line, err)
+		}
+	}
+	return nil
+}
+
+// json中的单个目标
+type targetRecord struct {
+	Target string   `json:"target"`
+	IP     string   `json:"ip"`
+	Host   string   `json:"host"`
+	Ports  string   `json:"ports"`
+	Tags   []string `json:"tags"`
+}
+
+func (p *targetParser) parseJSON(content []byte) error {
+	var items []json.RawMessage
+	if err := json.Unmarshal(content, &items); err != nil {
+		var wrapper struct {
+			Targets []json.RawMessage `json:"targets"`
+		}
+		if err := json.Unmarshal(content, &wrapper); err != nil {
+			return fmt.Errorf("%w: json目标文件解析失败: %v", ErrInvalidTarget, err)
+		}
+		items = wrapper.Targets
+	}
+
+	for n, item := range items {
+		var record targetRecord
+		var entry string
+		if err := json.Unmarshal(item, &entry); err == nil {
+			record.Target = entry
+		} else if err := json.Unmarshal(item, &record); err != nil {
+			return fmt.Errorf("%w: json目标文件第%d个目标解析失败: %v", ErrInvalidTarget, n+1, err)
+		}
+
+		target := firstNonEmpty(record.Target, record.IP, record.Host)
+		if target == "" {
+			return fmt.Errorf("%w: json目标文件第%d个目标未指定target", ErrInvalidTarget, n+1)
+		}
+		if err := p.add(target, record.Ports, mergeTags(nil, record.Tags)); err != nil {
+			return fmt.Errorf("json目标文件第%d个目标: %w", n+1, err)
+		}
+	}
+	return nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// 拆分目标与端口，ipv6地址需要用[]包裹才能指定端口
+func splitTargetPorts(entry string) (string, string) {
+	entry = strings.TrimSpace(entry)
+	if strings.HasPrefix(entry, "[") {
+		if end := strings.Index(entry, "]"); end > 0 {
+			return entry[1:end], strings.TrimPrefix(entry[end+1:], ":")
+		}
+	}
+
+	// 单个ipv6地址或ip段本身包含冒号
+	if net.ParseIP(entry) != nil {
+		return entry, ""
+	}
+	if _, _, err := net.ParseCIDR(entry); err == nil {
+		return entry, ""
+	}
+
+	host, ports, _ := strings.Cut(entry, ":")
+	return host, ports
+}
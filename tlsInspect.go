@@ -0,0 +1,516 @@
+package tools
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/md5"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TLSInfo 端口的TLS探测结果
+type TLSInfo struct {
+	StartTLS string   `json:"starttls,omitempty"` // 通过STARTTLS升级时记录所用协议
+	Versions []string `json:"versions"`           // 支持的协议版本
+	Ciphers  []string `json:"ciphers"`            // 支持的加密套件
+
+	Subject    string    `json:"subject"`
+	SANs       []string  `json:"sans,omitempty"`
+	Issuer     string    `json:"issuer"`
+	NotBefore  time.Time `json:"not_before"`
+	NotAfter   time.Time `json:"not_after"`
+	DaysLeft   int       `json:"days_left"`
+	Expired    bool      `json:"expired"`
+	KeyType    string    `json:"key_type"`
+	KeySize    int       `json:"key_size"`
+	SelfSigned bool      `json:"self_signed"`
+
+	JA3S string `json:"ja3s"`
+	JARM string `json:"jarm"` // JARM风格指纹，探测方式与标准JARM不同，不能与其他工具的JARM值直接比较
+}
+
+// STARTTLS协议
+const (
+	startTLSSMTP     = "smtp"
+	startTLSIMAP     = "imap"
+	startTLSPOP3     = "pop3"
+	startTLSFTP      = "ftp"
+	startTLSLDAP     = "ldap"
+	startTLSPostgres = "postgresql"
+)
+
+// 端口与服务名对应的STARTTLS协议
+var startTLSPorts = map[int]string{
+	21:   startTLSFTP,
+	25:   startTLSSMTP,
+	110:  startTLSPOP3,
+	143:  startTLSIMAP,
+	389:  startTLSLDAP,
+	587:  startTLSSMTP,
+	5432: startTLSPostgres,
+}
+
+var startTLSServices = map[string]string{
+	"ftp":        startTLSFTP,
+	"smtp":       startTLSSMTP,
+	"submission": startTLSSMTP,
+	"pop3":       startTLSPOP3,
+	"imap":       startTLSIMAP,
+	"ldap":       startTLSLDAP,
+	"postgresql": startTLSPostgres,
+}
+
+// 需要枚举的协议版本
+var tlsVersions = []uint16{tls.VersionTLS10, tls.VersionTLS11, tls.VersionTLS12, tls.VersionTLS13}
+
+// 握手时最多记录的服务端数据长度，足以容纳ServerHello
+const maxCaptureSize = 16 << 10
+
+// 对开放的TCP端口进行TLS探测，结果写入每个ScanResult的TLS字段
+func tlsInspectResults(results []ScanResult, cfg *Config) {
+	logSection("TLS探测")
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, cfg.Threads)
+	for i := range results {
+		if results[i].Status != "open" || results[i].Protocol == "udp" {
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(result *ScanResult) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			info := inspectTLS(result.IP, result.Port, result.Service, cfg)
+			if info == nil {
+				return
+			}
+			result.TLS = info
+
+			line := fmt.Sprintf("%s:%d [%s] [%s] [%s] [%d天]", result.IP, result.Port, strings.Join(info.Versions, ","), info.Subject, info.Issuer, info.DaysLeft)
+			fmt.Println(line) // 原子性输出日志
+			fileWrite(line)
+		}(&results[i])
+	}
+	wg.Wait()
+}
+
+// 对单个端口进行TLS探测，先尝试直接握手，失败后按端口或服务名尝试STARTTLS，均失败返回nil
+func inspectTLS(ip string, port int, service string, cfg *Config) *TLSInfo {
+	modes := []string{""}
+	if mode := startTLSMode(port, service); mode != "" {
+		modes = []string{mode, ""}
+	}
+
+	for _, mode := range modes {
+		state, hello, err := tlsHandshake(ip, port, mode, baseTLSConfig(), cfg)
+		if err != nil {
+			slog.Debug("TLS握手失败", "addr", net.JoinHostPort(ip, strconv.Itoa(port)), "starttls", mode, "error", err)
+			continue
+		}
+
+		info := &TLSInfo{StartTLS: mode, JA3S: ja3s(hello)}
+		fillCertificate(info, state)
+		enumerateTLS(info, ip, port, mode, cfg)
+		info.JARM = jarmFingerprint(ip, port, mode, cfg)
+		return info
+	}
+	return nil
+}
+
+// 根据端口号与服务名判断STARTTLS协议
+func startTLSMode(port int, service string) string {
+	if mode, ok := startTLSServices[strings.ToLower(service)]; ok {
+		return mode
+	}
+	return startTLSPorts[port]
+}
+
+// 探测使用的基础配置，不校验证书并允许旧版本协议
+func baseTLSConfig() *tls.Config {
+	return &tls.Config{
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS10,
+		MaxVersion:         tls.VersionTLS13,
+	}
+}
+
+// 全部可用的TLS1.0-1.2加密套件，包括不安全的套件
+func allCipherSuites() []*tls.CipherSuite {
+	return append(tls.CipherSuites(), tls.InsecureCipherSuites()...)
+}
+
+// 建立连接并完成TLS握手，返回连接状态与服务端发送的原始握手数据
+func tlsHandshake(ip string, port int, mode string, tlsConfig *tls.Config, cfg *Config) (tls.ConnectionState, []byte, error) {
+	conn, err := dialTCP(net.JoinHostPort(ip, strconv.Itoa(port)), cfg.Timeout)
+	if err != nil {
+		return tls.ConnectionState{}, nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(cfg.TLSTimeout))
+
+	if mode != "" {
+		if err := startTLS(conn, mode); err != nil {
+			return tls.ConnectionState{}, nil, err
+		}
+	}
+
+	capture := &captureConn{Conn: conn}
+	client := tls.Client(capture, tlsConfig)
+	if err := client.Handshake(); err != nil {
+		return tls.ConnectionState{}, nil, err
+	}
+	return client.ConnectionState(), capture.buf.Bytes(), nil
+}
+
+// 记录从服务端读取的数据，用于解析ServerHello
+type captureConn struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (c *captureConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 && c.buf.Len() < maxCaptureSize {
+		c.buf.Write(p[:n])
+	}
+	return n, err
+}
+
+// 按协议发送STARTTLS指令，服务端同意升级后返回nil
+func startTLS(conn net.Conn, mode string) error {
+	reader := bufio.NewReader(conn)
+
+	switch mode {
+	case startTLSSMTP:
+		if _, err := readReply(reader, "220"); err != nil {
+			return err
+		}
+		fmt.Fprint(conn, "EHLO miao\r\n")
+		if _, err := readReply(reader, "250"); err != nil {
+			return err
+		}
+		fmt.Fprint(conn, "STARTTLS\r\n")
+		_, err := readReply(reader, "220")
+		return err
+
+	case startTLSFTP:
+		if _, err := readReply(reader, "220"); err != nil {
+			return err
+		}
+		fmt.Fprint(conn, "AUTH TLS\r\n")
+		_, err := readReply(reader, "234")
+		return err
+
+	case startTLSPOP3:
+		if _, err := readLinePrefix(reader, "+OK"); err != nil {
+			return err
+		}
+		fmt.Fprint(conn, "STLS\r\n")
+		_, err := readLinePrefix(reader, "+OK")
+		return err
+
+	case startTLSIMAP:
+		if _, err := readLinePrefix(reader, "* OK"); err != nil {
+			return err
+		}
+		fmt.Fprint(conn, "a001 STARTTLS\r\n")
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return err
+			}
+			if strings.HasPrefix(line, "a001 ") {
+				if strings.HasPrefix(line, "a001 OK") {
+					return nil
+				}
+				return fmt.Errorf("IMAP STARTTLS被拒绝: %s", strings.TrimSpace(line))
+			}
+		}
+
+	case startTLSLDAP:
+		// StartTLS扩展操作，OID为1.3.6.1.4.1.1466.20037
+		oid := "1.3.6.1.4.1.1466.20037"
+		request := []byte{0x30, byte(len(oid) + 7), 0x02, 0x01, 0x01, 0x77, byte(len(oid) + 2), 0x80, byte(len(oid))}
+		conn.Write(append(request, oid...))
+
+		response := make([]byte, 256)
+		n, err := reader.Read(response)
+		if err != nil {
+			return err
+		}
+		// ExtendedResponse中的resultCode为0表示成功
+		if !bytes.Contains(response[:n], []byte{0x0a, 0x01, 0x00}) {
+			return fmt.Errorf("LDAP StartTLS被拒绝")
+		}
+		return nil
+
+	case startTLSPostgres:
+		// SSLRequest：长度8，请求码80877103
+		conn.Write([]byte{0x00, 0x00, 0x00, 0x08, 0x04, 0xd2, 0x16, 0x2f})
+		answer, err := reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		if answer != 'S' {
+			return fmt.Errorf("PostgreSQL不支持SSL")
+		}
+		return nil
+	}
+	return fmt.Errorf("不支持的STARTTLS协议: %s", mode)
+}
+
+// 读取FTP/SMTP风格的多行应答，直到出现"<code> "开头的行
+func readReply(reader *bufio.Reader, code string) (string, error) {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		if len(line) < 4 || line[:3] != code {
+			return "", fmt.Errorf("期望应答码%s，实际为: %s", code, strings.TrimSpace(line))
+		}
+		if line[3] == ' ' {
+			return line, nil
+		}
+	}
+}
+
+// 读取一行并校验前缀
+func readLinePrefix(reader *bufio.Reader, prefix string) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(line, prefix) {
+		return "", fmt.Errorf("期望以%s开头，实际为: %s", prefix, strings.TrimSpace(line))
+	}
+	return line, nil
+}
+
+// 从握手结果中提取证书信息
+func fillCertificate(info *TLSInfo, state tls.ConnectionState) {
+	if len(state.PeerCertificates) == 0 {
+		return
+	}
+	cert := state.PeerCertificates[0]
+
+	info.Subject = cert.Subject.String()
+	info.Issuer = cert.Issuer.String()
+	info.NotBefore = cert.NotBefore
+	info.NotAfter = cert.NotAfter
+	info.DaysLeft = int(time.Until(cert.NotAfter).Hours() / 24)
+	info.Expired = time.Now().After(cert.NotAfter)
+
+	info.SANs = append(info.SANs, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		info.SANs = append(info.SANs, ip.String())
+	}
+
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		info.KeyType, info.KeySize = "RSA", key.N.BitLen()
+	case *ecdsa.PublicKey:
+		info.KeyType, info.KeySize = "ECDSA", key.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		info.KeyType, info.KeySize = "Ed25519", 256
+	default:
+		info.KeyType = cert.PublicKeyAlgorithm.String()
+	}
+
+	info.SelfSigned = isSelfSigned(cert)
+}
+
+// 颁发者与主题一致且能用自身公钥验证签名时认为是自签名证书
+func isSelfSigned(cert *x509.Certificate) bool {
+	if !bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+		return false
+	}
+	return cert.CheckSignatureFrom(cert) == nil
+}
+
+// 枚举支持的协议版本，启用tls_ciphers时进一步逐个尝试加密套件，否则只记录各版本协商出的套件
+func enumerateTLS(info *TLSInfo, ip string, port int, mode string, cfg *Config) {
+	ciphers := make(map[string]bool)
+	addCipher := func(name string) {
+		if !ciphers[name] {
+			ciphers[name] = true
+			info.Ciphers = append(info.Ciphers, name)
+		}
+	}
+
+	for _, version := range tlsVersions {
+		tlsConfig := baseTLSConfig()
+		tlsConfig.MinVersion, tlsConfig.MaxVersion = version, version
+		if version != tls.VersionTLS13 {
+			tlsConfig.CipherSuites = cipherIDs(allCipherSuites(), version)
+		}
+
+		state, _, err := tlsHandshake(ip, port, mode, tlsConfig, cfg)
+		if err != nil {
+			continue
+		}
+		info.Versions = append(info.Versions, tls.VersionName(version))
+		addCipher(tls.CipherSuiteName(state.CipherSuite))
+
+		// TLS1.3的加密套件无法由客户端指定
+		if !cfg.TLSCiphers || version == tls.VersionTLS13 {
+			continue
+		}
+		for _, suite := range allCipherSuites() {
+			if !supportsVersion(suite, version) || ciphers[suite.Name] {
+				continue
+			}
+			tlsConfig.CipherSuites = []uint16{suite.ID}
+			if _, _, err := tlsHandshake(ip, port, mode, tlsConfig, cfg); err == nil {
+				addCipher(suite.Name)
+			}
+		}
+	}
+}
+
+func supportsVersion(suite *tls.CipherSuite, version uint16) bool {
+	for _, v := range suite.SupportedVersions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// 过滤出支持指定版本的套件ID
+func cipherIDs(suites []*tls.CipherSuite, version uint16) []uint16 {
+	var ids []uint16
+	for _, suite := range suites {
+		if supportsVersion(suite, version) {
+			ids = append(ids, suite.ID)
+		}
+	}
+	return ids
+}
+
+// 解析握手数据中的ServerHello，返回版本、加密套件与扩展类型列表
+func parseServerHello(data []byte) (version uint16, cipher uint16, extensions []uint16, ok bool) {
+	// 记录层：类型(1) 版本(2) 长度(2)，握手层：类型(1) 长度(3)
+	if len(data) < 9 || data[0] != 0x16 || data[5] != 0x02 {
+		return 0, 0, nil, false
+	}
+	body := data[9:]
+
+	// ServerHello：版本(2) 随机数(32) 会话ID长度(1)+会话ID 加密套件(2) 压缩方法(1) 扩展长度(2)+扩展
+	if len(body) < 35 {
+		return 0, 0, nil, false
+	}
+	version = uint16(body[0])<<8 | uint16(body[1])
+	pos := 34
+	pos += 1 + int(body[pos])
+	if len(body) < pos+3 {
+		return 0, 0, nil, false
+	}
+	cipher = uint16(body[pos])<<8 | uint16(body[pos+1])
+	pos += 3
+
+	if len(body) >= pos+2 {
+		end := pos + 2 + (int(body[pos])<<8 | int(body[pos+1]))
+		pos += 2
+		for pos+4 <= end && pos+4 <= len(body) {
+			extensions = append(extensions, uint16(body[pos])<<8|uint16(body[pos+1]))
+			pos += 4 + (int(body[pos+2])<<8 | int(body[pos+3]))
+		}
+	}
+	return version, cipher, extensions, true
+}
+
+// 计算JA3S指纹：md5(版本,加密套件,扩展列表)
+func ja3s(hello []byte) string {
+	version, cipher, extensions, ok := parseServerHello(hello)
+	if !ok {
+		return ""
+	}
+
+	exts := make([]string, 0, len(extensions))
+	for _, ext := range extensions {
+		exts = append(exts, strconv.Itoa(int(ext)))
+	}
+	raw := fmt.Sprintf("%d,%d,%s", version, cipher, strings.Join(exts, "-"))
+	sum := md5.Sum([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// JARM风格指纹的探测配置
+func jarmProbes() []*tls.Config {
+	var probes []*tls.Config
+	add := func(min, max uint16, suites []uint16, curves []tls.CurveID, alpn []string) {
+		c := baseTLSConfig()
+		c.MinVersion, c.MaxVersion = min, max
+		c.CipherSuites = suites
+		c.CurvePreferences = curves
+		c.NextProtos = alpn
+		probes = append(probes, c)
+	}
+
+	all := allCipherSuites()
+	filter := func(keep func(name string) bool) []uint16 {
+		var ids []uint16
+		for _, suite := range all {
+			if keep(suite.Name) {
+				ids = append(ids, suite.ID)
+			}
+		}
+		return ids
+	}
+
+	add(tls.VersionTLS10, tls.VersionTLS12, cipherIDs(all, tls.VersionTLS12), nil, []string{"h2", "http/1.1"})
+	add(tls.VersionTLS10, tls.VersionTLS12, cipherIDs(tls.CipherSuites(), tls.VersionTLS12), nil, nil)
+	add(tls.VersionTLS10, tls.VersionTLS12, filter(func(n string) bool { return strings.Contains(n, "CBC") }), nil, nil)
+	add(tls.VersionTLS10, tls.VersionTLS12, filter(func(n string) bool { return strings.HasPrefix(n, "TLS_RSA_") }), nil, nil)
+	add(tls.VersionTLS10, tls.VersionTLS12, filter(func(n string) bool { return strings.Contains(n, "CHACHA20") }), nil, []string{"http/1.1"})
+	add(tls.VersionTLS10, tls.VersionTLS11, cipherIDs(all, tls.VersionTLS11), nil, nil)
+	add(tls.VersionTLS10, tls.VersionTLS10, cipherIDs(all, tls.VersionTLS10), nil, nil)
+	add(tls.VersionTLS13, tls.VersionTLS13, nil, nil, []string{"h2"})
+	add(tls.VersionTLS13, tls.VersionTLS13, nil, []tls.CurveID{tls.CurveP256}, nil)
+	add(tls.VersionTLS13, tls.VersionTLS13, nil, []tls.CurveID{tls.X25519}, []string{"http/1.1"})
+	return probes
+}
+
+// 计算JARM风格指纹：每个探测记录协商出的套件与版本（共30个字符），再拼接扩展与ALPN的sha256前32个字符
+func jarmFingerprint(ip string, port int, mode string, cfg *Config) string {
+	var codes strings.Builder
+	var extensions []string
+	for _, probe := range jarmProbes() {
+		state, hello, err := tlsHandshake(ip, port, mode, probe, cfg)
+		if err != nil {
+			codes.WriteString("000")
+			extensions = append(extensions, "")
+			continue
+		}
+
+		_, _, exts, _ := parseServerHello(hello)
+		extText := make([]string, 0, len(exts))
+		for _, ext := range exts {
+			extText = append(extText, fmt.Sprintf("%04x", ext))
+		}
+		fmt.Fprintf(&codes, "%02x%x", state.CipherSuite&0xff, state.Version&0x0f)
+		extensions = append(extensions, state.NegotiatedProtocol+"|"+strings.Join(extText, "-"))
+	}
+
+	if strings.Trim(codes.String(), "0") == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(strings.Join(extensions, ",")))
+	return codes.String() + hex.EncodeToString(sum[:])[:32]
+}
@@ -0,0 +1,680 @@
+package tools
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/xuri/excelize/v2"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Finding 安全检查发现的问题
+type Finding struct {
+	Check    string `json:"check"`    // 检查项名称，例如 redis
+	Severity string `json:"severity"` // 严重、高危、中危、低危
+	Title    string `json:"title"`
+	Detail   string `json:"detail"`
+}
+
+// 问题等级
+const (
+	severityCritical = "严重"
+	severityHigh     = "高危"
+	severityMedium   = "中危"
+	severityLow      = "低危"
+)
+
+// 等级排序，数值越小越严重
+var severityOrder = map[string]int{severityCritical: 0, severityHigh: 1, severityMedium: 2, severityLow: 3}
+
+// 未授权访问检查项，端口、服务名或指纹任意一个匹配即执行检查
+// 所有检查只发送只读请求，不会修改目标上的数据
+type unauthCheck struct {
+	name     string
+	ports    []int
+	services []string // nmap识别的服务名
+	products []string // 指纹识别得到的产品名前缀
+	run      func(target checkTarget) (*Finding, error)
+}
+
+// 检查使用的目标信息
+type checkTarget struct {
+	ip     string
+	port   int
+	result *ScanResult
+	cfg    *Config
+	client *http.Client
+}
+
+var unauthChecks = []unauthCheck{
+	{"redis", []int{6379}, []string{"redis"}, []string{"Redis"}, checkRedis},
+	{"memcached", []int{11211}, []string{"memcached", "memcache"}, nil, checkMemcached},
+	{"mongodb", []int{27017, 27018}, []string{"mongodb", "mongod"}, nil, checkMongoDB},
+	{"zookeeper", []int{2181}, []string{"zookeeper"}, nil, checkZooKeeper},
+	{"elasticsearch", []int{9200}, []string{"elasticsearch"}, []string{"Elasticsearch"}, checkElasticsearch},
+	{"docker", []int{2375, 2376}, []string{"docker"}, []string{"Docker API"}, checkDocker},
+	{"etcd", []int{2379}, []string{"etcd", "etcd-client"}, []string{"etcd"}, checkEtcd},
+	{"kubelet", []int{10250}, []string{"kubelet"}, nil, checkKubelet},
+}
+
+// 对开放端口进行未授权访问检查，结果写入每个ScanResult的Findings字段
+func unauthCheckResults(results []ScanResult, cfg *Config) {
+	logSection("未授权访问检查")
+
+	client := newHTTPClient(cfg)
+
+	// 同一端口可能同时执行多个检查，写入结果时加锁
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, cfg.Threads)
+	for i := range results {
+		if results[i].Status != "open" || results[i].Protocol == "udp" {
+			continue
+		}
+
+		for _, check := range unauthChecks {
+			if !check.applies(&results[i]) {
+				continue
+			}
+
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(result *ScanResult, check unauthCheck) {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				finding, err := check.run(checkTarget{ip: result.IP, port: result.Port, result: result, cfg: cfg, client: client})
+				if err != nil {
+					slog.Debug("未授权访问检查失败", "addr", fmt.Sprintf("%s:%d", result.IP, result.Port), "check", check.name, "error", err)
+				}
+				if err != nil || finding == nil {
+					return
+				}
+				finding.Check = check.name
+
+				line := fmt.Sprintf("%s:%d [%s] %s %s", result.IP, result.Port, finding.Severity, finding.Title, finding.Detail)
+				color.Red(line) // 原子性输出日志
+				fileWrite(line)
+
+				mutex.Lock()
+				result.Findings = append(result.Findings, *finding)
+				mutex.Unlock()
+			}(&results[i], check)
+		}
+	}
+	wg.Wait()
+
+	for i := range results {
+		sortFindings(results[i].Findings)
+	}
+}
+
+// 判断检查项是否适用于该端口
+func (c unauthCheck) applies(result *ScanResult) bool {
+	for _, port := range c.ports {
+		if result.Port == port {
+			return true
+		}
+	}
+	service := strings.ToLower(result.Service)
+	for _, name := range c.services {
+		if service == name {
+			return true
+		}
+	}
+	for _, product := range result.Products {
+		for _, name := range c.products {
+			if strings.HasPrefix(product, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 建立tcp连接并设置整体超时
+func (t checkTarget) dial() (net.Conn, error) {
+	conn, err := dialTCP(net.JoinHostPort(t.ip, strconv.Itoa(t.port)), t.cfg.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(t.cfg.HTTPTimeout))
+	return conn, nil
+}
+
+// 发送文本命令并读取响应，直到出现结束标记或读取超时
+func (t checkTarget) command(cmd string, terminators ...string) (string, error) {
+	conn, err := t.dial()
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(cmd)); err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	reader := bufio.NewReader(conn)
+	for buf.Len() < maxBodySize {
+		line, err := reader.ReadString('\n')
+		buf.WriteString(line)
+		for _, terminator := range terminators {
+			if strings.HasSuffix(strings.TrimRight(buf.String(), "\r\n"), terminator) {
+				return buf.String(), nil
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	if buf.Len() == 0 {
+		return "", io.EOF
+	}
+	return buf.String(), nil
+}
+
+// 请求http接口，优先使用HTTP探测确定的协议
+func (t checkTarget) get(path string) (int, []byte, error) {
+	return t.request(http.MethodGet, path, "")
+}
+
+func (t checkTarget) request(method string, path string, body string) (int, []byte, error) {
+	base := "http://" + net.JoinHostPort(t.ip, strconv.Itoa(t.port))
+	if t.result.HTTP != nil && t.result.HTTP.base != "" {
+		base = t.result.HTTP.base
+	} else if t.port == 2376 || t.port == 10250 || httpsPorts[t.port] {
+		base = "https://" + net.JoinHostPort(t.ip, strconv.Itoa(t.port))
+	}
+
+	req, err := http.NewRequest(method, base+path, strings.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	return resp.StatusCode, content, err
+}
+
+// 读取 key:value 格式的文本中指定字段
+func infoField(info string, key string) string {
+	for _, line := range strings.Split(info, "\n") {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(line), key+":"); ok {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// Redis：执行INFO server，未开启认证时返回服务器信息，开启认证时返回-NOAUTH错误
+func checkRedis(t checkTarget) (*Finding, error) {
+	conn, err := t.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("INFO server\r\n")); err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+	if !strings.HasPrefix(line, "$") || err != nil || size <= 0 || size > maxBodySize {
+		return nil, nil
+	}
+	info := make([]byte, size)
+	if _, err := io.ReadFull(reader, info); err != nil {
+		return nil, err
+	}
+
+	reply := string(info)
+	if !strings.Contains(reply, "redis_version:") {
+		return nil, nil
+	}
+	return &Finding{
+		Severity: severityHigh,
+		Title:    "Redis未授权访问",
+		Detail:   fmt.Sprintf("redis_version=%s os=%s", infoField(reply, "redis_version"), infoField(reply, "os")),
+	}, nil
+}
+
+// Memcached：执行stats，memcached本身没有认证，能返回统计信息即可访问全部缓存
+func checkMemcached(t checkTarget) (*Finding, error) {
+	reply, err := t.command("stats\r\n", "END", "ERROR")
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(reply, "STAT ") {
+		return nil, nil
+	}
+
+	stats := make(map[string]string)
+	for _, line := range strings.Split(reply, "\n") {
+		if fields := strings.Fields(line); len(fields) == 3 && fields[0] == "STAT" {
+			stats[fields[1]] = fields[2]
+		}
+	}
+	return &Finding{
+		Severity: severityMedium,
+		Title:    "Memcached未授权访问",
+		Detail:   fmt.Sprintf("version=%s curr_items=%s", stats["version"], stats["curr_items"]),
+	}, nil
+}
+
+// ZooKeeper：执行srvr四字命令，能返回服务信息说明未限制客户端访问
+func checkZooKeeper(t checkTarget) (*Finding, error) {
+	reply, err := t.command("srvr")
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(reply, "Zookeeper version:") {
+		return nil, nil
+	}
+	version := infoField(reply, "Zookeeper version")
+	// 去掉版本号后的提交哈希与构建时间，例如 3.8.1-74db005..., built on ...
+	if i := strings.IndexAny(version, "-,"); i >= 0 {
+		version = version[:i]
+	}
+	return &Finding{
+		Severity: severityMedium,
+		Title:    "ZooKeeper未授权访问",
+		Detail:   fmt.Sprintf("version=%s mode=%s", version, infoField(reply, "Mode")),
+	}, nil
+}
+
+// MongoDB：通过OP_MSG执行listDatabases，开启认证时会返回Unauthorized错误
+func checkMongoDB(t checkTarget) (*Finding, error) {
+	conn, err := t.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	doc := bsonDocument(
+		bsonElement{"listDatabases", int32(1)},
+		bsonElement{"nameOnly", true},
+		bsonElement{"$db", "admin"},
+	)
+	// 消息头：长度、请求id、响应id、操作码2013(OP_MSG)，随后为flagBits与类型为0的section
+	msg := make([]byte, 21, 21+len(doc))
+	binary.LittleEndian.PutUint32(msg[0:], uint32(21+len(doc)))
+	binary.LittleEndian.PutUint32(msg[4:], 1)
+	binary.LittleEndian.PutUint32(msg[12:], 2013)
+	msg = append(msg, doc...)
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	length := int(binary.LittleEndian.Uint32(header))
+	if length < 21 || length > maxBodySize || binary.LittleEndian.Uint32(header[12:]) != 2013 {
+		return nil, errors.New("无效的MongoDB响应")
+	}
+	body := make([]byte, length-16)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return nil, err
+	}
+	reply, err := parseBSON(body[5:])
+	if err != nil {
+		return nil, err
+	}
+
+	if ok, _ := reply["ok"].(float64); ok != 1 {
+		return nil, nil
+	}
+	var names []string
+	databases, _ := reply["databases"].([]interface{})
+	for _, database := range databases {
+		if db, ok := database.(map[string]interface{}); ok {
+			if name, ok := db["name"].(string); ok {
+				names = append(names, name)
+			}
+		}
+	}
+	return &Finding{
+		Severity: severityHigh,
+		Title:    "MongoDB未授权访问",
+		Detail:   fmt.Sprintf("数据库: %s", strings.Join(names, ",")),
+	}, nil
+}
+
+// Elasticsearch：读取索引列表
+func checkElasticsearch(t checkTarget) (*Finding, error) {
+	status, body, err := t.get("/_cat/indices?format=json&h=index")
+	if err != nil || status != http.StatusOK {
+		return nil, err
+	}
+
+	var indices []struct {
+		Index string `json:"index"`
+	}
+	if err := json.Unmarshal(body, &indices); err != nil {
+		return nil, nil
+	}
+	var names []string
+	for _, index := range indices {
+		names = append(names, index.Index)
+	}
+	return &Finding{
+		Severity: severityHigh,
+		Title:    "Elasticsearch未授权访问",
+		Detail:   fmt.Sprintf("共%d个索引: %s", len(names), truncateList(names, 10)),
+	}, nil
+}
+
+// Docker：读取/version，未开启TLS认证的Docker API可直接控制宿主机
+func checkDocker(t checkTarget) (*Finding, error) {
+	status, body, err := t.get("/version")
+	if err != nil || status != http.StatusOK {
+		return nil, err
+	}
+
+	var version struct {
+		Version    string
+		APIVersion string `json:"ApiVersion"`
+		Os         string
+	}
+	if err := json.Unmarshal(body, &version); err != nil || version.APIVersion == "" {
+		return nil, nil
+	}
+	return &Finding{
+		Severity: severityCritical,
+		Title:    "Docker Remote API未授权访问",
+		Detail:   fmt.Sprintf("version=%s api=%s os=%s", version.Version, version.APIVersion, version.Os),
+	}, nil
+}
+
+// etcd：读取/version确认服务，再统计全部key的数量，开启认证时统计会失败
+func checkEtcd(t checkTarget) (*Finding, error) {
+	status, body, err := t.get("/version")
+	if err != nil || status != http.StatusOK {
+		return nil, err
+	}
+	var version struct {
+		Server string `json:"etcdserver"`
+	}
+	if err := json.Unmarshal(body, &version); err != nil || version.Server == "" {
+		return nil, nil
+	}
+
+	// key与range_end均为\0表示全部key，count_only只返回数量
+	status, body, err = t.request(http.MethodPost, "/v3/kv/range", `{"key":"AA==","range_end":"AA==","count_only":true}`)
+	if err != nil || status != http.StatusOK {
+		return nil, err
+	}
+	var count struct {
+		Count string `json:"count"`
+	}
+	if err := json.Unmarshal(body, &count); err != nil {
+		return nil, nil
+	}
+	if count.Count == "" {
+		count.Count = "0"
+	}
+	return &Finding{
+		Severity: severityHigh,
+		Title:    "etcd未授权访问",
+		Detail:   fmt.Sprintf("version=%s keys=%s", version.Server, count.Count),
+	}, nil
+}
+
+// Kubelet：读取/pods，开启匿名访问时可获取节点上全部pod并执行命令
+func checkKubelet(t checkTarget) (*Finding, error) {
+	status, body, err := t.get("/pods")
+	if err != nil || status != http.StatusOK {
+		return nil, err
+	}
+
+	var pods struct {
+		Kind  string
+		Items []json.RawMessage
+	}
+	if err := json.Unmarshal(body, &pods); err != nil || pods.Kind != "PodList" {
+		return nil, nil
+	}
+	return &Finding{
+		Severity: severityCritical,
+		Title:    "Kubelet未授权访问",
+		Detail:   fmt.Sprintf("共%d个pod", len(pods.Items)),
+	}, nil
+}
+
+// 最多展示前n项
+func truncateList(items []string, n int) string {
+	if len(items) <= n {
+		return strings.Join(items, ",")
+	}
+	return strings.Join(items[:n], ",") + fmt.Sprintf(",...等%d项", len(items))
+}
+
+// 问题列表的文字描述，例如 [高危] Redis未授权访问;[中危] ZooKeeper未授权访问
+func findingsText(findings []Finding) string {
+	texts := make([]string, 0, len(findings))
+	for _, finding := range findings {
+		texts = append(texts, fmt.Sprintf("[%s] %s", finding.Severity, finding.Title))
+	}
+	return strings.Join(texts, ";")
+}
+
+// 解析findingsText生成的文字描述
+func parseFindingsText(text string) []Finding {
+	var findings []Finding
+	for _, item := range strings.Split(text, ";") {
+		severity, title, ok := strings.Cut(strings.TrimPrefix(strings.TrimSpace(item), "["), "] ")
+		if ok {
+			findings = append(findings, Finding{Severity: severity, Title: title})
+		}
+	}
+	return findings
+}
+
+// 安全问题工作表名称
+const findingsSheet = "安全问题"
+
+// 存在安全问题时，在excel中增加单独的工作表，每个问题一行，按等级使用不同的底色
+func writeFindingsSheet(f *excelize.File, results []ScanResult) error {
+	var rows [][]interface{}
+	for _, result := range results {
+		for _, finding := range result.Findings {
+			rows = append(rows, []interface{}{finding.Severity, result.IP, result.Port, result.Service, finding.Title, finding.Detail})
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		return severityOrder[rows[i][0].(string)] < severityOrder[rows[j][0].(string)]
+	})
+
+	if _, err := writeExcelSheet(f, findingsSheet, []string{"等级", "IP", "端口", "服务", "问题", "详情"}, []float64{10, 18, 10, 15, 30, 50}, rows); err != nil {
+		return err
+	}
+
+	styles := severityStyles(f)
+	for i, row := range rows {
+		f.SetCellStyle(findingsSheet, fmt.Sprintf("A%d", i+2), fmt.Sprintf("F%d", i+2), styles[row[0].(string)])
+	}
+	return nil
+}
+
+// 各等级对应的单元格样式
+func severityStyles(f *excelize.File) map[string]int {
+	fills := map[string]string{
+		severityCritical: "#FF9999",
+		severityHigh:     "#FFC7CE",
+		severityMedium:   "#FFEB9C",
+		severityLow:      "#DDEBF7",
+	}
+	styles := make(map[string]int)
+	for severity, fill := range fills {
+		styles[severity], _ = f.NewStyle(&excelize.Style{
+			Fill:      excelize.Fill{Type: "pattern", Color: []string{fill}, Pattern: 1},
+			Alignment: &excelize.Alignment{Horizontal: "center", Vertical: "center"},
+		})
+	}
+	return styles
+}
+
+// 按等级排序问题
+func sortFindings(findings []Finding) {
+	sort.SliceStable(findings, func(i, j int) bool {
+		return severityOrder[findings[i].Severity] < severityOrder[findings[j].Severity]
+	})
+}
+
+// bsonElement 有序的bson字段
+type bsonElement struct {
+	key   string
+	value interface{}
+}
+
+// 编码bson文档，只支持检查需要的类型
+func bsonDocument(elements ...bsonElement) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{0, 0, 0, 0})
+	for _, element := range elements {
+		switch value := element.value.(type) {
+		case int32:
+			buf.WriteByte(0x10)
+			buf.WriteString(element.key)
+			buf.WriteByte(0)
+			binary.Write(&buf, binary.LittleEndian, value)
+		case bool:
+			buf.WriteByte(0x08)
+			buf.WriteString(element.key)
+			buf.WriteByte(0)
+			if value {
+				buf.WriteByte(1)
+			} else {
+				buf.WriteByte(0)
+			}
+		case string:
+			buf.WriteByte(0x02)
+			buf.WriteString(element.key)
+			buf.WriteByte(0)
+			binary.Write(&buf, binary.LittleEndian, int32(len(value)+1))
+			buf.WriteString(value)
+			buf.WriteByte(0)
+		}
+	}
+	buf.WriteByte(0)
+
+	doc := buf.Bytes()
+	binary.LittleEndian.PutUint32(doc, uint32(len(doc)))
+	return doc
+}
+
+// 解析bson文档，数组解析为[]interface{}，嵌套文档解析为map，不支持的类型直接结束解析
+func parseBSON(data []byte) (map[string]interface{}, error) {
+	if len(data) < 5 {
+		return nil, errors.New("bson文档长度错误")
+	}
+	length := int(binary.LittleEndian.Uint32(data))
+	if length < 5 || length > len(data) {
+		return nil, errors.New("bson文档长度错误")
+	}
+	data = data[4 : length-1]
+
+	doc := make(map[string]interface{})
+	for len(data) > 0 {
+		kind := data[0]
+		end := bytes.IndexByte(data[1:], 0)
+		if end < 0 {
+			return nil, errors.New("bson字段名错误")
+		}
+		key := string(data[1 : 1+end])
+		data = data[2+end:]
+
+		var size int
+		switch kind {
+		case 0x01: // double
+			if len(data) < 8 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			doc[key] = math.Float64frombits(binary.LittleEndian.Uint64(data))
+			size = 8
+		case 0x02: // string
+			if len(data) < 4 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			size = 4 + int(binary.LittleEndian.Uint32(data))
+			if size < 5 || size > len(data) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			doc[key] = string(data[4 : size-1])
+		case 0x03, 0x04: // document、array
+			if len(data) < 4 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			size = int(binary.LittleEndian.Uint32(data))
+			sub, err := parseBSON(data)
+			if err != nil {
+				return nil, err
+			}
+			if kind == 0x03 {
+				doc[key] = sub
+				break
+			}
+			items := make([]interface{}, 0, len(sub))
+			for i := 0; ; i++ {
+				item, ok := sub[strconv.Itoa(i)]
+				if !ok {
+					break
+				}
+				items = append(items, item)
+			}
+			doc[key] = items
+		case 0x08: // bool
+			if len(data) < 1 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			doc[key] = data[0] == 1
+			size = 1
+		case 0x0A: // null
+		case 0x10: // int32
+			if len(data) < 4 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			doc[key] = float64(int32(binary.LittleEndian.Uint32(data)))
+			size = 4
+		case 0x12: // int64
+			if len(data) < 8 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			doc[key] = float64(int64(binary.LittleEndian.Uint64(data)))
+			size = 8
+		default:
+			return doc, nil
+		}
+		data = data[size:]
+	}
+	return doc, nil
+}
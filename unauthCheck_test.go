@@ -0,0 +1,295 @@
+package tools
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 本地模拟的tcp服务，每个连接调用一次handle
+func fakeTCPServer(t *testing.T, handle func(conn net.Conn)) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(5 * time.Second))
+				handle(conn)
+			}()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func newTestCheckTarget(port int, base string) checkTarget {
+	cfg := defaultConfig()
+	cfg.Timeout = 2 * time.Second
+	cfg.HTTPTimeout = 2 * time.Second
+	result := &ScanResult{IP: "127.0.0.1", Port: port, Protocol: "tcp", Status: "open"}
+	if base != "" {
+		result.HTTP = &HTTPInfo{base: base}
+	}
+	return checkTarget{ip: "127.0.0.1", port: port, result: result, cfg: cfg, client: newHTTPClient(cfg)}
+}
+
+// 本地模拟的http服务
+func fakeHTTPTarget(t *testing.T, handler http.HandlerFunc) checkTarget {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	port := server.Listener.Addr().(*net.TCPAddr).Port
+	return newTestCheckTarget(port, server.URL)
+}
+
+// 模拟的服务按文本命令回复
+func fakeTextTarget(t *testing.T, expect string, reply string) checkTarget {
+	t.Helper()
+	port := fakeTCPServer(t, func(conn net.Conn) {
+		buf := make([]byte, len(expect))
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != expect {
+			return
+		}
+		io.WriteString(conn, reply)
+	})
+	return newTestCheckTarget(port, "")
+}
+
+type checkCase struct {
+	name    string
+	target  func(t *testing.T) checkTarget
+	check   func(target checkTarget) (*Finding, error)
+	finding string // 期望的问题标题，为空表示不应发现问题
+	detail  string // 期望详情中包含的内容
+}
+
+func runCheckCases(t *testing.T, cases []checkCase) {
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			finding, err := tc.check(tc.target(t))
+			if tc.finding == "" {
+				if finding != nil {
+					t.Fatalf("不应发现问题，实际为 %+v", finding)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("检查失败: %v", err)
+			}
+			if finding == nil {
+				t.Fatalf("未发现问题，期望 %s", tc.finding)
+			}
+			if finding.Title != tc.finding || !strings.Contains(finding.Detail, tc.detail) {
+				t.Fatalf("问题为 %s（%s），期望 %s（包含%s）", finding.Title, finding.Detail, tc.finding, tc.detail)
+			}
+		})
+	}
+}
+
+func TestCheckRedis(t *testing.T) {
+	info := "# Server\r\nredis_version:7.0.11\r\nos:Linux 5.15.0 x86_64\r\n"
+	runCheckCases(t, []checkCase{
+		{"未开启认证", func(t *testing.T) checkTarget {
+			return fakeTextTarget(t, "INFO server\r\n", "$"+strconv.Itoa(len(info))+"\r\n"+info+"\r\n")
+		}, checkRedis, "Redis未授权访问", "redis_version=7.0.11"},
+		{"需要认证", func(t *testing.T) checkTarget {
+			return fakeTextTarget(t, "INFO server\r\n", "-NOAUTH Authentication required.\r\n")
+		}, checkRedis, "", ""},
+	})
+}
+
+func TestCheckMemcached(t *testing.T) {
+	runCheckCases(t, []checkCase{
+		{"返回统计信息", func(t *testing.T) checkTarget {
+			return fakeTextTarget(t, "stats\r\n", "STAT pid 1\r\nSTAT version 1.6.21\r\nSTAT curr_items 42\r\nEND\r\n")
+		}, checkMemcached, "Memcached未授权访问", "version=1.6.21 curr_items=42"},
+		{"开启SASL认证", func(t *testing.T) checkTarget {
+			return fakeTextTarget(t, "stats\r\n", "ERROR\r\n")
+		}, checkMemcached, "", ""},
+	})
+}
+
+func TestCheckZooKeeper(t *testing.T) {
+	runCheckCases(t, []checkCase{
+		{"允许四字命令", func(t *testing.T) checkTarget {
+			return fakeTextTarget(t, "srvr", "Zookeeper version: 3.8.1-74db005175a4ec545697012f9069cb9dcc8cdda7, built on 2023-01-25 16:31 UTC\nLatency min/avg/max: 0/0.0/0\nMode: standalone\n")
+		}, checkZooKeeper, "ZooKeeper未授权访问", "version=3.8.1 mode=standalone"},
+		{"四字命令未在白名单", func(t *testing.T) checkTarget {
+			return fakeTextTarget(t, "srvr", "srvr is not executed because it is not in the whitelist.\n")
+		}, checkZooKeeper, "", ""},
+	})
+}
+
+// 编码测试用的bson文档，在bsonDocument的基础上支持数组
+func testBSON(elements ...bsonElement) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{0, 0, 0, 0})
+	for _, element := range elements {
+		switch value := element.value.(type) {
+		case [][]byte:
+			array := make([]bsonElement, 0, len(value))
+			for i, doc := range value {
+				array = append(array, bsonElement{strconv.Itoa(i), doc})
+			}
+			buf.WriteByte(0x04)
+			buf.WriteString(element.key)
+			buf.WriteByte(0)
+			buf.Write(testBSON(array...))
+		case []byte:
+			buf.WriteByte(0x03)
+			buf.WriteString(element.key)
+			buf.WriteByte(0)
+			buf.Write(value)
+		default:
+			doc := bsonDocument(element)
+			buf.Write(doc[4 : len(doc)-1])
+		}
+	}
+	buf.WriteByte(0)
+	doc := buf.Bytes()
+	binary.LittleEndian.PutUint32(doc, uint32(len(doc)))
+	return doc
+}
+
+// 模拟的MongoDB读取一条OP_MSG后回复reply文档
+func fakeMongoTarget(t *testing.T, reply []byte) checkTarget {
+	t.Helper()
+	port := fakeTCPServer(t, func(conn net.Conn) {
+		header := make([]byte, 16)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		body := make([]byte, binary.LittleEndian.Uint32(header)-16)
+		if _, err := io.ReadFull(conn, body); err != nil {
+			return
+		}
+		if request, err := parseBSON(body[5:]); err != nil || request["listDatabases"] == nil {
+			return
+		}
+
+		msg := make([]byte, 21, 21+len(reply))
+		binary.LittleEndian.PutUint32(msg[0:], uint32(21+len(reply)))
+		binary.LittleEndian.PutUint32(msg[8:], binary.LittleEndian.Uint32(header[4:]))
+		binary.LittleEndian.PutUint32(msg[12:], 2013)
+		conn.Write(append(msg, reply...))
+	})
+	return newTestCheckTarget(port, "")
+}
+
+func TestCheckMongoDB(t *testing.T) {
+	databases := [][]byte{testBSON(bsonElement{"name", "admin"}), testBSON(bsonElement{"name", "orders"})}
+	runCheckCases(t, []checkCase{
+		{"列出数据库", func(t *testing.T) checkTarget {
+			return fakeMongoTarget(t, testBSON(bsonElement{"databases", databases}, bsonElement{"ok", int32(1)}))
+		}, checkMongoDB, "MongoDB未授权访问", "admin,orders"},
+		{"需要认证", func(t *testing.T) checkTarget {
+			return fakeMongoTarget(t, testBSON(bsonElement{"ok", int32(0)}, bsonElement{"errmsg", "command listDatabases requires authentication"},
+				bsonElement{"code", int32(13)}, bsonElement{"codeName", "Unauthorized"}))
+		}, checkMongoDB, "", ""},
+	})
+}
+
+// 返回固定内容的http接口，未匹配的路径返回404
+func jsonRoutes(routes map[string]string, status int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, ok := routes[r.Method+" "+r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}
+}
+
+func TestCheckElasticsearch(t *testing.T) {
+	runCheckCases(t, []checkCase{
+		{"读取索引列表", func(t *testing.T) checkTarget {
+			return fakeHTTPTarget(t, jsonRoutes(map[string]string{"GET /_cat/indices": `[{"index":"logs-2024"},{"index":"users"}]`}, http.StatusOK))
+		}, checkElasticsearch, "Elasticsearch未授权访问", "共2个索引: logs-2024,users"},
+		{"需要认证", func(t *testing.T) checkTarget {
+			return fakeHTTPTarget(t, jsonRoutes(map[string]string{"GET /_cat/indices": `{"error":{"type":"security_exception"},"status":401}`}, http.StatusUnauthorized))
+		}, checkElasticsearch, "", ""},
+	})
+}
+
+func TestCheckDocker(t *testing.T) {
+	runCheckCases(t, []checkCase{
+		{"Remote API", func(t *testing.T) checkTarget {
+			return fakeHTTPTarget(t, jsonRoutes(map[string]string{"GET /version": `{"Version":"24.0.7","ApiVersion":"1.43","Os":"linux"}`}, http.StatusOK))
+		}, checkDocker, "Docker Remote API未授权访问", "version=24.0.7 api=1.43"},
+		{"其他web服务", func(t *testing.T) checkTarget {
+			return fakeHTTPTarget(t, jsonRoutes(map[string]string{"GET /version": `{"version":"1.0.0"}`}, http.StatusOK))
+		}, checkDocker, "", ""},
+	})
+}
+
+func TestCheckEtcd(t *testing.T) {
+	version := map[string]string{"GET /version": `{"etcdserver":"3.5.9","etcdcluster":"3.5.0"}`}
+	runCheckCases(t, []checkCase{
+		{"统计key数量", func(t *testing.T) checkTarget {
+			return fakeHTTPTarget(t, jsonRoutes(map[string]string{
+				"GET /version":      `{"etcdserver":"3.5.9","etcdcluster":"3.5.0"}`,
+				"POST /v3/kv/range": `{"header":{"revision":"7"},"count":"5"}`,
+			}, http.StatusOK))
+		}, checkEtcd, "etcd未授权访问", "version=3.5.9 keys=5"},
+		{"开启认证", func(t *testing.T) checkTarget {
+			return fakeHTTPTarget(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/version" {
+					jsonRoutes(version, http.StatusOK)(w, r)
+					return
+				}
+				w.WriteHeader(http.StatusUnauthorized)
+				io.WriteString(w, `{"error":"etcdserver: user name is empty","code":16}`)
+			})
+		}, checkEtcd, "", ""},
+	})
+}
+
+func TestCheckKubelet(t *testing.T) {
+	runCheckCases(t, []checkCase{
+		{"匿名访问", func(t *testing.T) checkTarget {
+			return fakeHTTPTarget(t, jsonRoutes(map[string]string{"GET /pods": `{"kind":"PodList","apiVersion":"v1","items":[{},{},{}]}`}, http.StatusOK))
+		}, checkKubelet, "Kubelet未授权访问", "共3个pod"},
+		{"需要认证", func(t *testing.T) checkTarget {
+			return fakeHTTPTarget(t, func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			})
+		}, checkKubelet, "", ""},
+	})
+}
+
+// 端口、服务名或指纹任意一个匹配即执行检查
+func TestUnauthCheckApplies(t *testing.T) {
+	redis := unauthChecks[0]
+	cases := []struct {
+		result ScanResult
+		want   bool
+	}{
+		{ScanResult{Port: 6379}, true},
+		{ScanResult{Port: 16379, Service: "Redis"}, true},
+		{ScanResult{Port: 8080, Products: []string{"Redis 7.0"}}, true},
+		{ScanResult{Port: 8080, Service: "http"}, false},
+	}
+	for _, tc := range cases {
+		if got := redis.applies(&tc.result); got != tc.want {
+			t.Errorf("applies(%+v) = %v，期望 %v", tc.result, got, tc.want)
+		}
+	}
+}
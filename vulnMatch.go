@@ -0,0 +1,866 @@
+package tools
+
+import (
+	"bytes"
+	"compress/gzip"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"github.com/xuri/excelize/v2"
+	"gopkg.in/yaml.v3"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+漏洞关联思路
+1、加载内置漏洞库、用户默认目录与-vuln-db指定的漏洞库，按产品名建立索引
+2、从nmap的cpe、nmap识别的产品名与版本、web指纹识别的产品与版本中提取待匹配的产品版本
+3、与漏洞库中的受影响版本范围比较，得到候选漏洞
+4、版本号并不可靠，按来源与版本号的精确程度给出置信度：
+   高：nmap给出的cpe中包含完整版本号
+   中：根据产品名称匹配，或cpe中没有版本号
+   低：版本号不完整（例如只有2.4、3.X）无法判断是否在范围内，或版本号带有发行版标记（可能已回溯修复），在上述基础上降一级
+*/
+
+// 内置漏洞库
+//
+//go:embed data/vulns.yaml
+var embeddedVulns []byte
+
+// 漏洞置信度
+const (
+	confidenceHigh   = "高"
+	confidenceMedium = "中"
+	confidenceLow    = "低"
+)
+
+var confidenceOrder = map[string]int{confidenceHigh: 0, confidenceMedium: 1, confidenceLow: 2}
+
+// 将配置中的置信度转换为内部表示，不合法时返回空
+func parseConfidence(value string) string {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "high", confidenceHigh:
+		return confidenceHigh
+	case "medium", confidenceMedium:
+		return confidenceMedium
+	case "low", confidenceLow, "":
+		return confidenceLow
+	}
+	return ""
+}
+
+// Vuln 根据产品版本关联到的候选漏洞，需要结合置信度人工确认
+type Vuln struct {
+	ID         string  `json:"id"`
+	CVSS       float64 `json:"cvss"`
+	Severity   string  `json:"severity"`
+	Confidence string  `json:"confidence"`
+	Match      string  `json:"match,omitempty"` // 匹配依据，例如 cpe:/a:openbsd:openssh:7.4 或 OpenSSH 7.4
+	Summary    string  `json:"summary,omitempty"`
+}
+
+// VulnEntry 漏洞库中的一条漏洞
+type VulnEntry struct {
+	ID      string       `yaml:"id" json:"id"`
+	CVSS    float64      `yaml:"cvss" json:"cvss"`
+	Summary string       `yaml:"summary" json:"summary"`
+	Affects []VulnAffect `yaml:"affects" json:"affects"`
+}
+
+// VulnAffect 受影响的产品与版本，未指定版本与范围时视为全部版本受影响
+type VulnAffect struct {
+	CPE            string `yaml:"cpe" json:"cpe"`
+	Version        string `yaml:"version" json:"version"`
+	StartIncluding string `yaml:"start_including" json:"start_including"`
+	StartExcluding string `yaml:"start_excluding" json:"start_excluding"`
+	EndIncluding   string `yaml:"end_including" json:"end_including"`
+	EndExcluding   string `yaml:"end_excluding" json:"end_excluding"`
+}
+
+type vulnFile struct {
+	Vendors map[string]string `yaml:"vendors" json:"vendors"`
+	Aliases map[string]string `yaml:"aliases" json:"aliases"`
+	Vulns   []VulnEntry       `yaml:"vulns" json:"vulns"`
+}
+
+// 加载后的漏洞库，按产品名索引
+type vulnDB struct {
+	vendors  map[string]string
+	aliases  map[string]string
+	products map[string][]vulnRef
+}
+
+type vulnRef struct {
+	entry  *VulnEntry
+	affect VulnAffect
+	vendor string
+}
+
+var (
+	vulnDBPaths []string
+	vulnDBOnce  sync.Once
+	vulnDBData  *vulnDB
+	vulnDBErr   error
+)
+
+// 设置额外的漏洞库文件或目录，多个以逗号分割，需要在首次使用漏洞库之前调用
+func setVulnDBPaths(paths string) {
+	vulnDBPaths = nil
+	for _, path := range strings.Split(paths, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			vulnDBPaths = append(vulnDBPaths, path)
+		}
+	}
+}
+
+// 用户默认的漏洞库目录
+func userVulnDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "miao-portscan", "vulns")
+}
+
+// 获取漏洞库，依次加载内置漏洞库、用户默认目录和-vuln-db指定的漏洞库，同一编号的漏洞后者覆盖前者
+func loadVulnDB() (*vulnDB, error) {
+	vulnDBOnce.Do(func() {
+		merged := &vulnFile{Vendors: make(map[string]string), Aliases: make(map[string]string)}
+		index := make(map[string]int)
+		add := func(file *vulnFile) {
+			for vendor, canonical := range file.Vendors {
+				merged.Vendors[strings.ToLower(vendor)] = strings.ToLower(canonical)
+			}
+			for name, cpe := range file.Aliases {
+				merged.Aliases[strings.ToLower(name)] = cpe
+			}
+			for _, entry := range file.Vulns {
+				if i, ok := index[entry.ID]; ok {
+					merged.Vulns[i] = entry
+					continue
+				}
+				index[entry.ID] = len(merged.Vulns)
+				merged.Vulns = append(merged.Vulns, entry)
+			}
+		}
+
+		file, err := parseVulnFile("vulns.yaml", embeddedVulns)
+		if err != nil {
+			vulnDBErr = fmt.Errorf("内置漏洞库解析失败: %v", err)
+			return
+		}
+		add(file)
+
+		// 用户默认目录不存在时直接跳过
+		paths := vulnDBPaths
+		if dir := userVulnDir(); dir != "" {
+			if _, err := os.Stat(dir); err == nil {
+				paths = append([]string{dir}, paths...)
+			}
+		}
+		for _, path := range paths {
+			files, err := vulnDBFiles(path)
+			if err != nil {
+				vulnDBErr = fmt.Errorf("漏洞库读取失败: %v", err)
+				return
+			}
+			for _, name := range files {
+				content, err := os.ReadFile(name)
+				if err != nil {
+					vulnDBErr = fmt.Errorf("漏洞库文件读取失败: %v", err)
+					return
+				}
+				file, err := parseVulnFile(name, content)
+				if err != nil {
+					vulnDBErr = fmt.Errorf("漏洞库文件 %s 解析失败: %v", name, err)
+					return
+				}
+				add(file)
+			}
+		}
+		vulnDBData = newVulnDB(merged)
+	})
+	return vulnDBData, vulnDBErr
+}
+
+// 漏洞库路径为目录时返回其中的yaml、json及其.gz压缩文件
+func vulnDBFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		ext := filepath.Ext(strings.TrimSuffix(strings.ToLower(entry.Name()), ".gz"))
+		if entry.IsDir() || (ext != ".yaml" && ext != ".yml" && ext != ".json") {
+			continue
+		}
+		files = append(files, filepath.Join(path, entry.Name()))
+	}
+	return files, nil
+}
+
+// 解析漏洞库文件，支持内置格式以及NVD的1.1数据文件与2.0接口返回的json
+func parseVulnFile(name string, content []byte) (*vulnFile, error) {
+	if strings.HasSuffix(strings.ToLower(name), ".gz") {
+		reader, err := gzip.NewReader(bytes.NewReader(content))
+		if err != nil {
+			return nil, err
+		}
+		if content, err = io.ReadAll(reader); err != nil {
+			return nil, err
+		}
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(content), []byte("{")) {
+		var feed nvdFeed
+		if err := json.Unmarshal(content, &feed); err != nil {
+			return nil, err
+		}
+		if feed.CVEItems != nil || feed.Vulnerabilities != nil {
+			return feed.vulnFile(), nil
+		}
+	}
+
+	var file vulnFile
+	if err := yaml.Unmarshal(content, &file); err != nil {
+		return nil, err
+	}
+	for i, entry := range file.Vulns {
+		if strings.TrimSpace(entry.ID) == "" || len(entry.Affects) == 0 {
+			return nil, fmt.Errorf("第%d条漏洞缺少编号或受影响产品", i+1)
+		}
+		for _, affect := range entry.Affects {
+			if _, product, _ := parseCPE(affect.CPE); product == "" {
+				return nil, fmt.Errorf("漏洞 %s 的cpe格式错误: %s", entry.ID, affect.CPE)
+			}
+		}
+	}
+	return &file, nil
+}
+
+// 建立产品名索引
+func newVulnDB(file *vulnFile) *vulnDB {
+	db := &vulnDB{vendors: file.Vendors, aliases: file.Aliases, products: make(map[string][]vulnRef)}
+	for i := range file.Vulns {
+		entry := &file.Vulns[i]
+		for _, affect := range entry.Affects {
+			vendor, product, version := parseCPE(affect.CPE)
+			if product == "" {
+				continue
+			}
+			// 完整的cpe中包含具体版本时作为受影响的单个版本
+			if affect.Version == "" {
+				affect.Version = version
+			}
+			db.products[product] = append(db.products[product], vulnRef{entry: entry, affect: affect, vendor: db.vendor(vendor)})
+		}
+	}
+	return db
+}
+
+// 统一厂商名称
+func (db *vulnDB) vendor(name string) string {
+	if canonical, ok := db.vendors[name]; ok {
+		return canonical
+	}
+	return name
+}
+
+// 解析cpe，支持 cpe:2.3:a:厂商:产品:版本:更新:... 、cpe:/a:厂商:产品:版本:更新 以及 厂商:产品 三种形式
+// 名称统一为小写，版本中的*与-视为未指定，更新字段（例如openssh的p1）拼接到版本之后
+func parseCPE(cpe string) (vendor, product, version string) {
+	cpe = strings.ToLower(strings.TrimSpace(cpe))
+	switch {
+	case strings.HasPrefix(cpe, "cpe:2.3:"):
+		cpe = cpe[len("cpe:2.3:"):]
+	case strings.HasPrefix(cpe, "cpe:/"):
+		cpe = cpe[len("cpe:/"):]
+	default:
+		cpe = "a:" + cpe
+	}
+
+	// 按未转义的冒号分割
+	var fields []string
+	var field strings.Builder
+	for i := 0; i < len(cpe); i++ {
+		switch {
+		case cpe[i] == '\\' && i+1 < len(cpe):
+			i++
+			field.WriteByte(cpe[i])
+		case cpe[i] == ':':
+			fields = append(fields, field.String())
+			field.Reset()
+		default:
+			field.WriteByte(cpe[i])
+		}
+	}
+	fields = append(fields, field.String())
+
+	get := func(i int) string {
+		if i >= len(fields) || fields[i] == "*" || fields[i] == "-" {
+			return ""
+		}
+		return fields[i]
+	}
+	vendor, product, version = get(1), get(2), get(3)
+	if version != "" {
+		version += get(4)
+	}
+	return vendor, product, version
+}
+
+// 待匹配的产品版本
+type vulnTarget struct {
+	vendor     string
+	product    string
+	version    string
+	backport   bool   // 版本号带有发行版标记，可能已回溯修复
+	confidence string // 版本在受影响范围内时的置信度
+	match      string
+}
+
+// 发行版在版本号中的常见标记
+var distroPattern = regexp.MustCompile(`(?i)ubuntu|debian|deb\d|\.el\d|centos|rhel|red ?hat|fedora|\.fc\d|amzn|suse|alpine`)
+
+// 提取用于比较的版本号，返回版本号以及是否带有发行版标记
+func cleanVersion(version string) (string, bool) {
+	backport := distroPattern.MatchString(version)
+	fields := strings.Fields(version)
+	if len(fields) == 0 {
+		return "", backport
+	}
+
+	// 去掉发行版附加的修订号，例如 5.7.33-0ubuntu0.18.04.1
+	v := fields[0]
+	if i := strings.IndexAny(v, "-+~"); i > 0 && distroPattern.MatchString(v[i:]) {
+		v = v[:i]
+	}
+	// 3.X、4.x等通配写法只保留确定的部分
+	parts := strings.Split(v, ".")
+	for i, part := range parts {
+		if part == "x" || part == "X" || part == "*" {
+			parts = parts[:i]
+			break
+		}
+	}
+	v = strings.Join(parts, ".")
+	if v == "" || v[0] < '0' || v[0] > '9' {
+		return "", backport
+	}
+	return v, backport
+}
+
+// 按别名将产品名称转换为厂商与产品，未配置别名时以下划线连接的小写名称作为产品名
+func (db *vulnDB) resolveName(name string) (vendor, product string) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if cpe, ok := db.aliases[name]; ok {
+		vendor, product, _ = parseCPE(cpe)
+		return vendor, product
+	}
+	return "", strings.ReplaceAll(name, " ", "_")
+}
+
+// 从扫描结果中提取待匹配的产品版本，同一产品只保留置信度最高的来源
+func (db *vulnDB) targets(result *ScanResult) []vulnTarget {
+	var targets []vulnTarget
+	seen := make(map[string]bool)
+	add := func(target vulnTarget) {
+		if target.product == "" || target.version == "" || seen[target.product] {
+			return
+		}
+		seen[target.product] = true
+		targets = append(targets, target)
+	}
+
+	version, backport := cleanVersion(result.Version)
+	for _, cpe := range result.CPEs {
+		vendor, product, cpeVersion := parseCPE(cpe)
+		target := vulnTarget{vendor: vendor, product: product, version: cpeVersion, backport: backport, confidence: confidenceHigh, match: cpe}
+		if cpeVersion == "" {
+			target.version = version
+			target.confidence = confidenceMedium
+			target.match = strings.TrimSpace(cpe + " " + result.Version)
+		} else {
+			target.version, _ = cleanVersion(cpeVersion)
+		}
+		add(target)
+	}
+
+	if result.Product != "" {
+		vendor, product := db.resolveName(result.Product)
+		add(vulnTarget{vendor: vendor, product: product, version: version, backport: backport,
+			confidence: confidenceMedium, match: strings.TrimSpace(result.Product + " " + result.Version)})
+	}
+
+	// web指纹识别的产品，格式为 名称 版本
+	for _, item := range result.Products {
+		i := strings.LastIndex(item, " ")
+		if i < 0 {
+			continue
+		}
+		fingerVersion, fingerBackport := cleanVersion(item[i+1:])
+		vendor, product := db.resolveName(item[:i])
+		add(vulnTarget{vendor: vendor, product: product, version: fingerVersion, backport: fingerBackport,
+			confidence: confidenceMedium, match: item})
+	}
+	return targets
+}
+
+// 关联单个端口的候选漏洞，同一漏洞保留置信度最高的匹配，按评分从高到低排序
+func (db *vulnDB) match(result *ScanResult) []Vuln {
+	var vulns []Vuln
+	index := make(map[string]int)
+	for _, target := range db.targets(result) {
+		for _, ref := range db.products[target.product] {
+			if target.vendor != "" && ref.vendor != "" && db.vendor(target.vendor) != ref.vendor {
+				continue
+			}
+			state := ref.affect.check(target.version)
+			if state == versionNotAffected {
+				continue
+			}
+
+			confidence := target.confidence
+			switch {
+			case state == versionMaybe:
+				confidence = confidenceLow
+			case target.backport && confidence == confidenceHigh:
+				confidence = confidenceMedium
+			case target.backport:
+				confidence = confidenceLow
+			}
+
+			vuln := Vuln{
+				ID:         ref.entry.ID,
+				CVSS:       ref.entry.CVSS,
+				Severity:   cvssSeverity(ref.entry.CVSS),
+				Confidence: confidence,
+				Match:      target.match,
+				Summary:    ref.entry.Summary,
+			}
+			if i, ok := index[vuln.ID]; ok {
+				if confidenceOrder[confidence] < confidenceOrder[vulns[i].Confidence] {
+					vulns[i] = vuln
+				}
+				continue
+			}
+			index[vuln.ID] = len(vulns)
+			vulns = append(vulns, vuln)
+		}
+	}
+	sortVulns(vulns)
+	return vulns
+}
+
+// 版本比较结果
+const (
+	versionNotAffected = iota
+	versionAffected
+	versionMaybe // 版本号不完整，无法确定是否受影响
+)
+
+// 判断版本是否受影响
+func (a VulnAffect) check(version string) int {
+	maybe := false
+	bounds := []struct {
+		bound string
+		ok    func(cmp int) bool
+	}{
+		{a.Version, func(cmp int) bool { return cmp == 0 }},
+		{a.StartIncluding, func(cmp int) bool { return cmp >= 0 }},
+		{a.StartExcluding, func(cmp int) bool { return cmp > 0 }},
+		{a.EndIncluding, func(cmp int) bool { return cmp <= 0 }},
+		{a.EndExcluding, func(cmp int) bool { return cmp < 0 }},
+	}
+	for _, b := range bounds {
+		if b.bound == "" {
+			continue
+		}
+		cmp, exact := compareVersions(version, b.bound)
+		if !exact {
+			maybe = true
+			continue
+		}
+		if !b.ok(cmp) {
+			return versionNotAffected
+		}
+	}
+	if maybe {
+		return versionMaybe
+	}
+	return versionAffected
+}
+
+// 比较版本号，exact为false表示a的版本号较短且是b的前缀，无法确定大小
+func compareVersions(a, b string) (cmp int, exact bool) {
+	x, y := versionTokens(a), versionTokens(b)
+	for i := 0; i < len(x) && i < len(y); i++ {
+		if c := compareVersionToken(x[i], y[i]); c != 0 {
+			return c, true
+		}
+	}
+
+	switch {
+	case len(x) == len(y):
+		return 0, true
+	case len(x) < len(y):
+		// b多出的部分为预发布标记时a为正式版本，例如 9.0.0 > 9.0.0.M1
+		if isPreRelease(y[len(x)]) {
+			return 1, true
+		}
+		if isPatchRelease(y[len(x)]) {
+			return 0, true
+		}
+		return -1, false
+	default:
+		// 补丁版本与基础版本视为同一版本，例如 7.7p1 与 7.7
+		if isPatchRelease(x[len(y)]) {
+			return 0, true
+		}
+		if isPreRelease(x[len(y)]) {
+			return -1, true
+		}
+		return 1, true
+	}
+}
+
+// 将版本号拆分为连续的数字与字母，例如 7.4p1 拆分为 7 4 p 1
+func versionTokens(version string) []string {
+	var tokens []string
+	start := -1
+	isDigit := func(c byte) bool { return c >= '0' && c <= '9' }
+	isAlpha := func(c byte) bool { return c >= 'a' && c <= 'z' }
+	version = strings.ToLower(version)
+	for i := 0; i <= len(version); i++ {
+		if start >= 0 && (i == len(version) || isDigit(version[i]) != isDigit(version[start]) || !(isDigit(version[i]) || isAlpha(version[i]))) {
+			tokens = append(tokens, version[start:i])
+			start = -1
+		}
+		if start < 0 && i < len(version) && (isDigit(version[i]) || isAlpha(version[i])) {
+			start = i
+		}
+	}
+	return tokens
+}
+
+// 比较版本号中的单个部分，数字按数值比较且大于字母
+func compareVersionToken(a, b string) int {
+	aNum, bNum := a[0] >= '0' && a[0] <= '9', b[0] >= '0' && b[0] <= '9'
+	switch {
+	case aNum && bNum:
+		a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+		if len(a) != len(b) {
+			if len(a) < len(b) {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a, b)
+	case aNum:
+		return 1
+	case bNum:
+		return -1
+	}
+	return strings.Compare(a, b)
+}
+
+// 预发布版本的标记
+func isPreRelease(token string) bool {
+	switch token {
+	case "alpha", "beta", "rc", "pre", "preview", "dev", "m", "milestone", "snapshot":
+		return true
+	}
+	return false
+}
+
+// 补丁版本的标记，例如 OpenSSH 可移植版的 7.7p1，NVD 中通常只记录 7.7
+func isPatchRelease(token string) bool {
+	return token == "p"
+}
+
+// 根据CVSS评分确定等级
+func cvssSeverity(score float64) string {
+	switch {
+	case score >= 9:
+		return severityCritical
+	case score >= 7:
+		return severityHigh
+	case score >= 4:
+		return severityMedium
+	}
+	return severityLow
+}
+
+// 按评分从高到低、置信度从高到低排序
+func sortVulns(vulns []Vuln) {
+	sort.SliceStable(vulns, func(i, j int) bool {
+		if vulns[i].CVSS != vulns[j].CVSS {
+			return vulns[i].CVSS > vulns[j].CVSS
+		}
+		return confidenceOrder[vulns[i].Confidence] < confidenceOrder[vulns[j].Confidence]
+	})
+}
+
+// 为全部结果关联漏洞，只保留不低于指定置信度的漏洞
+func correlateVulns(results []ScanResult, db *vulnDB, minConfidence string) {
+	for i := range results {
+		result := &results[i]
+		result.Vulns = nil
+		for _, vuln := range db.match(result) {
+			if confidenceOrder[vuln.Confidence] <= confidenceOrder[minConfidence] {
+				result.Vulns = append(result.Vulns, vuln)
+			}
+		}
+	}
+}
+
+// 根据服务识别与指纹识别得到的产品版本离线关联漏洞，结果写入每个ScanResult的Vulns字段
+func vulnResults(results []ScanResult, cfg *Config) {
+	db, err := loadVulnDB()
+	if err != nil {
+		slog.Error(err.Error())
+		return
+	}
+
+	logSection("漏洞关联")
+
+	correlateVulns(results, db, parseConfidence(cfg.VulnConfidence))
+	for _, result := range results {
+		if len(result.Vulns) == 0 {
+			continue
+		}
+		line := fmt.Sprintf("%s:%d %s [%s]", result.IP, result.Port, result.Vulns[0].Match, truncateList(vulnItems(result.Vulns), 5))
+		fmt.Println(line)
+		fileWrite(line)
+	}
+}
+
+// 漏洞列表的文字描述，每项为 编号(评分,置信度)
+func vulnItems(vulns []Vuln) []string {
+	items := make([]string, 0, len(vulns))
+	for _, vuln := range vulns {
+		items = append(items, fmt.Sprintf("%s(%.1f,%s)", vuln.ID, vuln.CVSS, vuln.Confidence))
+	}
+	return items
+}
+
+// 用于excel与csv的漏洞列表，例如 CVE-2023-38408(9.8,高);CVE-2016-6515(7.5,高)
+func vulnsText(vulns []Vuln) string {
+	return strings.Join(vulnItems(vulns), ";")
+}
+
+// 解析vulnsText生成的文字描述
+func parseVulnsText(text string) []Vuln {
+	var vulns []Vuln
+	for _, item := range strings.Split(text, ";") {
+		id, rest, ok := strings.Cut(strings.TrimSpace(item), "(")
+		if !ok {
+			continue
+		}
+		score, confidence, _ := strings.Cut(strings.TrimSuffix(rest, ")"), ",")
+		cvss, _ := strconv.ParseFloat(score, 64)
+		vulns = append(vulns, Vuln{ID: id, CVSS: cvss, Severity: cvssSeverity(cvss), Confidence: confidence})
+	}
+	return vulns
+}
+
+// 漏洞工作表名称
+const vulnsSheet = "漏洞"
+
+// 关联到漏洞时，在excel中增加单独的工作表，每个漏洞一行，按评分排序并按等级使用不同的底色
+func writeVulnsSheet(f *excelize.File, results []ScanResult) error {
+	type vulnRow struct {
+		result ScanResult
+		vuln   Vuln
+	}
+	var items []vulnRow
+	for _, result := range results {
+		for _, vuln := range result.Vulns {
+			items = append(items, vulnRow{result, vuln})
+		}
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].vuln.CVSS != items[j].vuln.CVSS {
+			return items[i].vuln.CVSS > items[j].vuln.CVSS
+		}
+		return confidenceOrder[items[i].vuln.Confidence] < confidenceOrder[items[j].vuln.Confidence]
+	})
+
+	rows := make([][]interface{}, 0, len(items))
+	for _, item := range items {
+		rows = append(rows, []interface{}{item.vuln.Severity, item.vuln.CVSS, item.vuln.Confidence, item.result.IP,
+			item.result.Port, item.result.Service, item.vuln.ID, item.vuln.Match, item.vuln.Summary})
+	}
+	if _, err := writeExcelSheet(f, vulnsSheet, []string{"等级", "CVSS", "置信度", "IP", "端口", "服务", "漏洞编号", "匹配依据", "描述"},
+		[]float64{10, 8, 8, 18, 10, 15, 18, 40, 60}, rows); err != nil {
+		return err
+	}
+
+	styles := severityStyles(f)
+	for i, item := range items {
+		f.SetCellStyle(vulnsSheet, fmt.Sprintf("A%d", i+2), fmt.Sprintf("I%d", i+2), styles[item.vuln.Severity])
+	}
+	return nil
+}
+
+// NVD 1.1数据文件与2.0接口返回结果中用到的字段
+type nvdFeed struct {
+	CVEItems        []nvdItem11 `json:"CVE_Items"`
+	Vulnerabilities []nvdItem20 `json:"vulnerabilities"`
+}
+
+type nvdItem11 struct {
+	CVE struct {
+		Meta struct {
+			ID string `json:"ID"`
+		} `json:"CVE_data_meta"`
+		Description struct {
+			Data []nvdText `json:"description_data"`
+		} `json:"description"`
+	} `json:"cve"`
+	Configurations struct {
+		Nodes []nvdNode `json:"nodes"`
+	} `json:"configurations"`
+	Impact struct {
+		V3 struct {
+			CVSS nvdScore `json:"cvssV3"`
+		} `json:"baseMetricV3"`
+		V2 struct {
+			CVSS nvdScore `json:"cvssV2"`
+		} `json:"baseMetricV2"`
+	} `json:"impact"`
+}
+
+type nvdItem20 struct {
+	CVE struct {
+		ID           string    `json:"id"`
+		Descriptions []nvdText `json:"descriptions"`
+		Metrics      map[string][]struct {
+			Data nvdScore `json:"cvssData"`
+		} `json:"metrics"`
+		Configurations []struct {
+			Nodes []nvdNode `json:"nodes"`
+		} `json:"configurations"`
+	} `json:"cve"`
+}
+
+type nvdText struct {
+	Lang  string `json:"lang"`
+	Value string `json:"value"`
+}
+
+type nvdScore struct {
+	BaseScore float64 `json:"baseScore"`
+}
+
+// 1.1中使用cpe_match并可嵌套children，2.0中使用cpeMatch
+type nvdNode struct {
+	Children  []nvdNode  `json:"children"`
+	Matches11 []nvdMatch `json:"cpe_match"`
+	Matches20 []nvdMatch `json:"cpeMatch"`
+}
+
+type nvdMatch struct {
+	Vulnerable     bool   `json:"vulnerable"`
+	URI            string `json:"cpe23Uri"`
+	Criteria       string `json:"criteria"`
+	StartIncluding string `json:"versionStartIncluding"`
+	StartExcluding string `json:"versionStartExcluding"`
+	EndIncluding   string `json:"versionEndIncluding"`
+	EndExcluding   string `json:"versionEndExcluding"`
+}
+
+// 转换为内置格式，只保留标记为受影响的cpe
+func (feed *nvdFeed) vulnFile() *vulnFile {
+	file := &vulnFile{}
+	add := func(id string, cvss float64, texts []nvdText, nodes []nvdNode) {
+		entry := VulnEntry{ID: id, CVSS: cvss, Summary: nvdDescription(texts)}
+		for _, node := range nodes {
+			entry.Affects = node.affects(entry.Affects)
+		}
+		if id != "" && len(entry.Affects) > 0 {
+			file.Vulns = append(file.Vulns, entry)
+		}
+	}
+
+	for _, item := range feed.CVEItems {
+		cvss := item.Impact.V3.CVSS.BaseScore
+		if cvss == 0 {
+			cvss = item.Impact.V2.CVSS.BaseScore
+		}
+		add(item.CVE.Meta.ID, cvss, item.CVE.Description.Data, item.Configurations.Nodes)
+	}
+
+	for _, item := range feed.Vulnerabilities {
+		var cvss float64
+		for _, key := range []string{"cvssMetricV31", "cvssMetricV30", "cvssMetricV40", "cvssMetricV2"} {
+			if metrics := item.CVE.Metrics[key]; len(metrics) > 0 {
+				cvss = metrics[0].Data.BaseScore
+				break
+			}
+		}
+		var nodes []nvdNode
+		for _, configuration := range item.CVE.Configurations {
+			nodes = append(nodes, configuration.Nodes...)
+		}
+		add(item.CVE.ID, cvss, item.CVE.Descriptions, nodes)
+	}
+	return file
+}
+
+// 收集节点及其子节点中受影响的cpe
+func (n nvdNode) affects(affects []VulnAffect) []VulnAffect {
+	for _, match := range append(n.Matches11, n.Matches20...) {
+		cpe := match.Criteria
+		if cpe == "" {
+			cpe = match.URI
+		}
+		if !match.Vulnerable || cpe == "" {
+			continue
+		}
+		affects = append(affects, VulnAffect{
+			CPE:            cpe,
+			StartIncluding: match.StartIncluding,
+			StartExcluding: match.StartExcluding,
+			EndIncluding:   match.EndIncluding,
+			EndExcluding:   match.EndExcluding,
+		})
+	}
+	for _, child := range n.Children {
+		affects = child.affects(affects)
+	}
+	return affects
+}
+
+// 取英文描述
+func nvdDescription(texts []nvdText) string {
+	for _, text := range texts {
+		if text.Lang == "en" {
+			return text.Value
+		}
+	}
+	if len(texts) > 0 {
+		return texts[0].Value
+	}
+	return ""
+}
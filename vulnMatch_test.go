@@ -0,0 +1,73 @@
+package tools
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	cases := []struct {
+		a, b  string
+		cmp   int
+		exact bool
+	}{
+		{"7.4", "7.7", -1, true},
+		{"7.7", "7.7", 0, true},
+		{"10.1", "9.8", 1, true},
+		{"010", "9", 1, true},
+		{"2.426.2", "2.426.3", -1, true},
+		// 补丁版本与基础版本视为同一版本
+		{"7.7p1", "7.7", 0, true},
+		{"7.7", "7.7p1", 0, true},
+		{"7.7p1", "7.7p2", -1, true},
+		{"7.7p1", "7.6", 1, true},
+		{"7.7p1", "7.8", -1, true},
+		{"7.7p1", "7.7.1", -1, true},
+		// 字母后缀为正式的新版本，例如 OpenSSL 1.0.2k
+		{"1.3.5e", "1.3.5", 1, true},
+		{"1.0.2k", "1.0.2l", -1, true},
+		// 预发布版本小于正式版本
+		{"9.0.0", "9.0.0.M1", 1, true},
+		{"9.0.0.M1", "9.0.0", -1, true},
+		{"10.1.0-M1", "10.1.0", -1, true},
+		{"8.0.0-beta1", "8.0.0", -1, true},
+		{"8.0.0-rc1", "8.0.0-rc2", -1, true},
+		// 版本号较短时无法确定大小
+		{"2.4", "2.4.49", -1, false},
+		{"7", "7.7", -1, false},
+	}
+	for _, tc := range cases {
+		cmp, exact := compareVersions(tc.a, tc.b)
+		if cmp != tc.cmp || exact != tc.exact {
+			t.Errorf("compareVersions(%q, %q) = %d %v，期望 %d %v", tc.a, tc.b, cmp, exact, tc.cmp, tc.exact)
+		}
+	}
+}
+
+func TestVulnAffectCheck(t *testing.T) {
+	cases := []struct {
+		name    string
+		affect  VulnAffect
+		version string
+		want    int
+	}{
+		{"补丁版本等于上限", VulnAffect{EndIncluding: "7.7"}, "7.7p1", versionAffected},
+		{"补丁版本超过上限", VulnAffect{EndIncluding: "7.7"}, "7.8p1", versionNotAffected},
+		{"补丁版本等于不含的上限", VulnAffect{EndExcluding: "7.7"}, "7.7p1", versionNotAffected},
+		{"补丁版本等于下限", VulnAffect{StartIncluding: "7.7", EndExcluding: "8.0"}, "7.7p1", versionAffected},
+		{"补丁版本等于不含的下限", VulnAffect{StartExcluding: "7.7", EndExcluding: "8.0"}, "7.7p1", versionNotAffected},
+		{"指定补丁版本", VulnAffect{Version: "7.4p1"}, "7.4p1", versionAffected},
+		{"指定其他补丁版本", VulnAffect{Version: "7.4p1"}, "7.4p2", versionNotAffected},
+		{"预发布版本低于上限", VulnAffect{EndExcluding: "9.0.0"}, "9.0.0-M1", versionAffected},
+		{"正式版本不低于上限", VulnAffect{EndExcluding: "9.0.0"}, "9.0.0", versionNotAffected},
+		{"预发布版本低于下限", VulnAffect{StartIncluding: "9.0.0", EndExcluding: "9.0.30"}, "9.0.0.M1", versionNotAffected},
+		{"版本在范围内", VulnAffect{StartIncluding: "2.4.0", EndIncluding: "2.4.49"}, "2.4.49", versionAffected},
+		{"版本号较短", VulnAffect{StartIncluding: "2.4.0", EndIncluding: "2.4.49"}, "2.4", versionMaybe},
+		{"版本号较短但已超出范围", VulnAffect{EndIncluding: "2.4.49"}, "2.5", versionNotAffected},
+		{"没有版本限制", VulnAffect{}, "1.0", versionAffected},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.affect.check(tc.version); got != tc.want {
+				t.Fatalf("%+v 检查 %s = %d，期望 %d", tc.affect, tc.version, got, tc.want)
+			}
+		})
+	}
+}